@@ -218,6 +218,7 @@ werks.directive('scrollToBottom', function($timeout) {
 var ChatCtrl = function($scope, $http, $timeout, GameSvc) {
     $scope.text = 'chat';
     $scope.chatMessages = [];
+    $scope.since = 0;
 
 		$scope.getMessage = function() {
 			var gameId = GameSvc.getGameId();
@@ -225,12 +226,12 @@ var ChatCtrl = function($scope, $http, $timeout, GameSvc) {
 			if (playerId == null) {
 				return;
 			}
-			var url = '/api/chat?g=' + gameId + '&p=' + playerId;
+			var url = '/api/chat?g=' + gameId + '&p=' + playerId + '&since=' + $scope.since;
 			$http.get(url).success(function(data) {
-				if (data) {
-					console.log(data)
-					$scope.chatMessages.push(data);
-				}
+				angular.forEach(data, function(message) {
+					$scope.chatMessages.push(message);
+					$scope.since = message.id;
+				});
 				$timeout($scope.getMessage, 1000);
 			});
 		}
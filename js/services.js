@@ -6,6 +6,7 @@ werks.service('GameSvc', ['$http', 'LocoSvc', function($http, LocoSvc) {
 		game: null,
 		username: null,
 		token: null,
+		playerIds: [],
 	};
 
 	// initialize the globals with the information from the current game.
@@ -49,8 +50,17 @@ werks.service('GameSvc', ['$http', 'LocoSvc', function($http, LocoSvc) {
 		return null;
 	}
 
+	// the server only tells each player their own ID, so the IDs handed
+	// out when the game was created are kept, by seat, for hot-seat play.
 	this.getCurrentPlayerId = function() {
-		return this.getCurrentPlayer().id;
+		var game = _globals.game;
+		for (var i=0; i<game.players.length; i++) {
+			var p = game.players[i];
+			if (p.isCurrent) {
+				return p.id || _globals.playerIds[i];
+			}
+		}
+		return null;
 	}
 
 	// get game game_id from the server, calling callback after it's been
@@ -92,6 +102,10 @@ werks.service('GameSvc', ['$http', 'LocoSvc', function($http, LocoSvc) {
 		}
 		$http.post('/api/newGame', params).success(function(data) {
 			_globals.game = data;
+			_globals.playerIds = [];
+			for (var i = 0; i < data.players.length; i++) {
+				_globals.playerIds.push(data.players[i].id);
+			}
 			_initFromGame();
 			callback();
 		});
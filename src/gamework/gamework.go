@@ -3,6 +3,7 @@ package gamework
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -12,13 +13,19 @@ import (
 // Game is a single instance of a game.  The Id uniquely identifies the Game
 // (typically it will be a uuid).  The Name is the game's human-readable name.
 // State is the game's current state.  Actions contains a list of all of the
-// Actions that have been taken in the game, in order.
+// Actions that have been taken in the game, in order, and Log contains the
+// Events that resulted from them.  Pending contains the actions submitted so
+// far during a simultaneous phase; see Submit.  Config, if set, is a JSON
+// payload describing how the game was set up; see Configurable.
 type Game struct {
 	Id      string
 	Name    string
 	Players []Player
 	Seed    int
+	Config  *[]byte
 	Actions []Action
+	Log     []Event
+	Pending []Action
 	State   GameState  `json:"-"`
 	Engine  GameEngine `json:"-"`
 }
@@ -40,9 +47,13 @@ type Player struct {
 
 // GameState indicates the game's current state.  Whenever the game changes state,
 // the game will send the Outcome (an Event describing the transition to the new
-// state, e.g. the result of the last action) to all players.  It will also send
-// the AvailableOptions to the ActingPlayer.  If there are ever no AvailableOptions,
-// the game is over.
+// state, e.g. the result of the last action) to every player allowed to see it.
+// It will also send the AvailableOptions to the ActingPlayer.
+//
+// During a simultaneous phase (e.g. secret bids), PendingPlayers lists every
+// player who must choose, and PlayerOptions maps each of their IDs to the
+// options available to that player; ActingPlayer and AvailableOptions are
+// unused.  If there are ever no options for anyone, the game is over.
 //
 // It's important to note that GameState is only a representation of the inputs
 // that the game is currently waiting on.  The actual "state" of the game is
@@ -53,6 +64,30 @@ type GameState struct {
 	Outcome          Event
 	ActingPlayer     Player
 	AvailableOptions []Option
+	PendingPlayers   []Player
+	PlayerOptions    map[string][]Option
+}
+
+// IsSimultaneous reports whether the state is waiting on several players at
+// once.
+func (s GameState) IsSimultaneous() bool {
+	return len(s.PendingPlayers) > 0
+}
+
+// IsOver reports whether the game is over, i.e. nobody has anything to do.
+func (s GameState) IsOver() bool {
+	return !s.IsSimultaneous() && len(s.AvailableOptions) == 0
+}
+
+// OptionsFor returns the options available to the player with the given ID.
+func (s GameState) OptionsFor(playerId string) []Option {
+	if s.IsSimultaneous() {
+		return s.PlayerOptions[playerId]
+	}
+	if playerId != "" && playerId == s.ActingPlayer.Id {
+		return s.AvailableOptions
+	}
+	return nil
 }
 
 // Option is something that a Player can do right now.  The Abbr uniquely identifies
@@ -61,28 +96,38 @@ type GameState struct {
 // option that is used when the game is running in console mode.  Detail is a payload
 // of additional JSON-encoded data sent along with the Option to the client, with the
 // expectation that the client will use that information in the presentation.
+// Visibility and Audience control who may see the Detail; see ViewFor.
 type Option struct {
-	Abbr   string
-	Text   string
-	Detail *[]byte
+	Abbr       string
+	Text       string
+	Detail     *[]byte
+	Visibility Visibility
+	Audience   []string
 }
 
 // Action represents the action chosen by the user.  It will contain the Abbr of one
 // of the chosen Options, and may additionally contain a payload of related JSON-encoded
-// data in the Detail.
+// data in the Detail.  PlayerId identifies the player who chose it.  At the end of a
+// simultaneous phase, the engine receives a single Action whose Batch contains the
+// action submitted by each of the PendingPlayers, in order.
 type Action struct {
-	Abbr   string
-	Detail *[]byte
+	Abbr     string
+	Detail   *[]byte
+	PlayerId string
+	Batch    []Action
 }
 
 // Event represents an occurrence in the game that should be presented to one ore
 // more users.  Abbr identifies the type of Event, Text is a description of the Event
 // (which more likely to be logged than simply presented to the user), and Detail is
-// a payload of JSON-encoded related data.
+// a payload of JSON-encoded related data.  Visibility and Audience determine which
+// players are allowed to see the Event.
 type Event struct {
-	Abbr   string
-	Text   string
-	Detail *[]byte
+	Abbr       string
+	Text       string
+	Detail     *[]byte
+	Visibility Visibility
+	Audience   []string
 }
 
 // GameEngine contains the actual logic of the game.
@@ -94,8 +139,9 @@ type GameEngine interface {
 	// initialize the random number generator.
 	Start(id string, name string, players []Player, seed int) GameState
 
-	// HandleAction is used to handle an action taken by the active player;
-	// it returns the game's new state.
+	// HandleAction is used to handle an action taken by the active player,
+	// or the Batch of actions taken by all of the pending players at the end
+	// of a simultaneous phase; it returns the game's new state.
 	HandleAction(action Action) GameState
 
 	// RefreshClient is used to refresh the client with the game, typically
@@ -112,16 +158,60 @@ type GameEngine interface {
 	Equals(e1 GameEngine) bool
 }
 
+// Configurable may be implemented by a GameEngine that can be set up in more
+// than one way.  Configure is passed the Game's Config before Start is called.
+type Configurable interface {
+	Configure(config []byte) error
+}
+
+// PlayerChecker may be implemented by a GameEngine that can only be played
+// by some numbers of players.  CheckPlayers is called after Configure, and
+// Start isn't called if it returns an error.
+type PlayerChecker interface {
+	CheckPlayers(players []Player) error
+}
+
+// startEngine configures (if need be) and starts an engine for the game,
+// unless the game has no players or the engine can't be played by them.
+func startEngine(g Game, e GameEngine) (GameState, error) {
+	if c, ok := e.(Configurable); ok && g.Config != nil {
+		if err := c.Configure(*g.Config); err != nil {
+			return GameState{}, err
+		}
+	}
+	if len(g.Players) == 0 {
+		return GameState{}, errors.New("The game has no players.")
+	}
+	if c, ok := e.(PlayerChecker); ok {
+		if err := c.CheckPlayers(g.Players); err != nil {
+			return GameState{}, err
+		}
+	}
+	return e.Start(g.Id, g.Name, g.Players, g.Seed), nil
+}
+
 // PlayToConsole allows testing and debugging of game engines without having to
 // build a UI.
 func PlayToConsole(g Game) {
 
+	var err error
 	g.Actions = make([]Action, 0, 100)
-	g.State = g.Engine.Start(g.Id, g.Name, g.Players, g.Seed)
+	g.State, err = startEngine(g, g.Engine)
+	if err != nil {
+		panic(err)
+	}
+	g.Log = []Event{g.State.Outcome}
 
 	for {
-		presentOptions(g.State)
-		action := getAction(g.State)
+		player := g.State.ActingPlayer
+		if g.State.IsSimultaneous() {
+			// players take turns at the console, which rather spoils
+			// the secrecy, but that's what hot-seat play is like.
+			player = Waiting(g)[0]
+		}
+		options := g.State.OptionsFor(player.Id)
+		presentOptions(player, options)
+		action := getAction(options)
 		if action.Abbr == "" {
 			s, err := WriteToString(g)
 			if err != nil {
@@ -130,30 +220,47 @@ func PlayToConsole(g Game) {
 			fmt.Printf("%s\n", s)
 			continue
 		}
-		g.State = performAction(&g, action)
+		action.PlayerId = player.Id
+		before := len(g.Actions)
+		g.State, err = Submit(&g, action)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			continue
+		}
+		if len(g.Actions) == before {
+			continue
+		}
 		fmt.Printf("Outcome: %s\n", g.State.Outcome.Text)
-		if len(g.State.AvailableOptions) == 0 {
+		if g.State.IsOver() {
 			break
 		}
 	}
 }
 
+// performAction hands the action to the engine, recording both the action
+// and the resulting Outcome.
 func performAction(g *Game, a Action) GameState {
 	if g.Actions != nil {
 		g.Actions = append(g.Actions, a)
 	}
-	return g.Engine.HandleAction(a)
+	actors := actorsOf(g.State, a)
+	s := g.Engine.HandleAction(a)
+	s.Outcome = s.Outcome.addressedFrom(actors)
+	if g.Log != nil {
+		g.Log = append(g.Log, s.Outcome)
+	}
+	return s
 }
 
-func presentOptions(state GameState) {
-	fmt.Printf("Acting player: %s\n\n", state.ActingPlayer.Name)
-	for _, option := range state.AvailableOptions {
+func presentOptions(player Player, options []Option) {
+	fmt.Printf("Acting player: %s\n\n", player.Name)
+	for _, option := range options {
 		fmt.Printf("%s: %s\n", option.Abbr, option.Text)
 	}
 	fmt.Printf("> ")
 }
 
-func getAction(state GameState) Action {
+func getAction(options []Option) Action {
 	var abbr string
 	var action Action
 	for {
@@ -167,7 +274,7 @@ func getAction(state GameState) Action {
 		if abbr == "" {
 			break
 		}
-		for _, option := range state.AvailableOptions {
+		for _, option := range options {
 			if strings.ToUpper(abbr) == strings.ToUpper(option.Abbr) {
 				action = Action{Abbr: option.Abbr}
 				break
@@ -184,12 +291,44 @@ func getAction(state GameState) Action {
 
 // Replay takes a Game that has been deserialized (and has had its Engine
 // assigned) and replays its stored actions to return the Engine to its
-// current internal state.
-func Replay(g Game) {
-	g.State = g.Engine.Start(g.Id, g.Name, g.Players, g.Seed)
+// current internal state.  It fails only if the engine can't be configured.
+func Replay(g Game) error {
+	var err error
+	g.State, err = startEngine(g, g.Engine)
+	if err != nil {
+		return err
+	}
 	for _, a := range g.Actions {
 		g.State = g.Engine.HandleAction(a)
 	}
+	return nil
+}
+
+// SerializeRedacted writes a JSON serialization of the game as the player
+// with the given ID is allowed to see it; pass the empty string to produce
+// a spectator's export.  Events the viewer can't see keep their Abbr but
+// lose their Text and Detail, and the Detail of every Action is dropped,
+// since it may carry a player's secret choices.  So are the Abbrs of the
+// actions pending in a simultaneous phase that hasn't been resolved.
+func SerializeRedacted(g Game, playerId string, w io.Writer) (n int, err error) {
+	if g.Log != nil {
+		log := make([]Event, len(g.Log))
+		for i, e := range g.Log {
+			log[i] = e.redactedFor(playerId)
+		}
+		g.Log = log
+	}
+	if g.Actions != nil {
+		g.Actions = redactActions(g.Actions)
+	}
+	if g.Pending != nil {
+		pending := make([]Action, len(g.Pending))
+		for i, a := range g.Pending {
+			pending[i] = Action{PlayerId: a.PlayerId}
+		}
+		g.Pending = pending
+	}
+	return Serialize(g, w)
 }
 
 // Serialize writes a JSON serialization of the game to the specified io.Writer.
@@ -229,6 +368,29 @@ func WriteToString(g Game) (s string, err error) {
 	return s, err
 }
 
+// redactActions returns a copy of the actions without their Details.
+func redactActions(actions []Action) []Action {
+	redacted := make([]Action, len(actions))
+	for i, a := range actions {
+		redacted[i] = Action{Abbr: a.Abbr, PlayerId: a.PlayerId}
+		if a.Batch != nil {
+			redacted[i].Batch = redactActions(a.Batch)
+		}
+	}
+	return redacted
+}
+
+// WriteRedactedToString returns the redacted serialization of the game for
+// the given player (or, if playerId is empty, for a spectator).
+func WriteRedactedToString(g Game, playerId string) (s string, err error) {
+	var b bytes.Buffer
+	_, err = SerializeRedacted(g, playerId, &b)
+	if err == nil {
+		s = b.String()
+	}
+	return s, err
+}
+
 func ReadFromString(s string, g *Game) (err error) {
 	b := bytes.NewBufferString(s)
 	return Deserialize(b, b.Len(), g)
@@ -6,11 +6,11 @@ import (
 )
 
 // makeAction finds the option matching abbr, and creates an Action
-// out of it.
-func makeAction(abbr string, options []Option) Action {
-	for _, o := range options {
+// out of it for the acting player.
+func makeAction(abbr string, state GameState) Action {
+	for _, o := range state.AvailableOptions {
 		if strings.ToUpper(o.Abbr) == strings.ToUpper(abbr) {
-			return Action{Abbr: o.Abbr}
+			return Action{Abbr: o.Abbr, PlayerId: state.ActingPlayer.Id}
 		}
 	}
 	return Action{}
@@ -22,13 +22,13 @@ func playGame(g *Game) {
 	var a Action
 
 	for i := 0; i < 3; i++ {
-		a = makeAction("P", g.State.AvailableOptions)
+		a = makeAction("P", g.State)
 		g.State = performAction(g, a)
 	}
-	a = makeAction("A", g.State.AvailableOptions)
+	a = makeAction("A", g.State)
 	g.State = performAction(g, a)
 
-	a = makeAction("P", g.State.AvailableOptions)
+	a = makeAction("P", g.State)
 	g.State = performAction(g, a)
 
 }
@@ -80,3 +80,334 @@ func TestSerializationAndDeserialization(t *testing.T) {
 		t.Errorf("\ng1.Engine.Debug() = \n%s", e1.Debug())
 	}
 }
+
+func TestEventVisibility(t *testing.T) {
+	public := Event{Text: "public"}
+	restricted := Event{Text: "restricted", Visibility: Restricted, Audience: []string{"A"}}
+	private := Event{Text: "private", Visibility: Private}.addressedFrom([]string{"B"})
+
+	tests := []struct {
+		e        Event
+		playerId string
+		expected bool
+	}{
+		{public, "A", true},
+		{public, "", true},
+		{restricted, "A", true},
+		{restricted, "B", false},
+		{restricted, "", false},
+		{private, "A", false},
+		{private, "B", true},
+		{private, "", false},
+	}
+	for _, test := range tests {
+		actual := test.e.VisibleTo(test.playerId)
+		if actual != test.expected {
+			t.Errorf("%s.VisibleTo(%q) = %t, expected %t",
+				test.e.Text, test.playerId, actual, test.expected)
+		}
+	}
+}
+
+// privateEngine is a TestGameEngine whose outcomes are all private.
+type privateEngine struct {
+	*TestGameEngine
+}
+
+func (e privateEngine) HandleAction(a Action) GameState {
+	s := e.TestGameEngine.HandleAction(a)
+	s.Outcome.Visibility = Private
+	return s
+}
+
+func TestPrivateOutcomesReachTheirActors(t *testing.T) {
+	g := InitTestGameWithTestEngine()
+	g.Engine = privateEngine{g.Engine.(*TestGameEngine)}
+	g.Log = make([]Event, 0, 10)
+	g.State = g.Engine.Start(g.Id, g.Name, g.Players, 0)
+
+	g.State, _ = Submit(&g, Action{Abbr: "R", PlayerId: "A"})
+	last := g.Log[len(g.Log)-1]
+	if !last.VisibleTo("A") || last.VisibleTo("B") {
+		t.Errorf("Only Allen should see the private outcome of Allen's action, audience %v", last.Audience)
+	}
+
+	Submit(&g, Action{Abbr: "1", PlayerId: "A"})
+	g.State, _ = Submit(&g, Action{Abbr: "2", PlayerId: "B"})
+	last = g.Log[len(g.Log)-1]
+	if !last.VisibleTo("A") || !last.VisibleTo("B") || last.VisibleTo("") {
+		t.Errorf("Everyone in the batch should see its private outcome, audience %v", last.Audience)
+	}
+}
+
+func TestViewFor(t *testing.T) {
+	secret := []byte(`{"hand":["x"]}`)
+	g := InitTestGameWithTestEngine()
+	g.State = GameState{
+		Outcome:      Event{Text: "Allen drew a card.", Visibility: Private, Audience: []string{"A"}},
+		ActingPlayer: g.Players[0],
+		AvailableOptions: []Option{
+			Option{Abbr: "P", Text: "Play", Detail: &secret, Visibility: Private},
+			Option{Abbr: "S", Text: "Show", Detail: &secret, Visibility: Restricted, Audience: []string{"B"}},
+		}}
+
+	views := FanOut(g)
+	if len(views) != 2 {
+		t.Fatalf("Expected 2 views, got %d", len(views))
+	}
+	a, b := views[0], views[1]
+	if a.Outcome == nil {
+		t.Errorf("Allen should see his own private outcome.")
+	}
+	if b.Outcome != nil {
+		t.Errorf("Bob shouldn't see Allen's private outcome.")
+	}
+	if len(b.Options) != 0 {
+		t.Errorf("Bob isn't acting, and shouldn't see any options.")
+	}
+	if len(a.Options) != 2 {
+		t.Fatalf("Allen should see 2 options, got %d", len(a.Options))
+	}
+	if a.Options[0].Detail == nil {
+		t.Errorf("Allen should see the detail of his private option.")
+	}
+	if a.Options[1].Detail != nil {
+		t.Errorf("Allen shouldn't see the detail of an option restricted to Bob.")
+	}
+}
+
+func TestSerializeRedacted(t *testing.T) {
+	detail := []byte(`{"bid":7}`)
+	g := InitTestGameWithTestEngine()
+	g.Actions = []Action{Action{Abbr: "B", Detail: &detail}}
+	g.Log = []Event{
+		Event{Abbr: "S", Text: "Started game."},
+		Event{Abbr: "B", Text: "Allen bid 7.", Visibility: Private, Audience: []string{"A"}},
+	}
+
+	s, err := WriteRedactedToString(g, "")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if strings.Contains(s, "bid") {
+		t.Errorf("Spectator export leaked the bid: %s", s)
+	}
+	if !strings.Contains(s, "Started game.") {
+		t.Errorf("Spectator export is missing a public event: %s", s)
+	}
+
+	s, err = WriteRedactedToString(g, "A")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !strings.Contains(s, "Allen bid 7.") {
+		t.Errorf("Allen's export is missing his own event: %s", s)
+	}
+
+	if g.Actions[0].Detail == nil || g.Log[1].Text == "" {
+		t.Errorf("Redacting an export shouldn't modify the game.")
+	}
+}
+
+func TestSimultaneousActions(t *testing.T) {
+	g := InitTestGameWithTestEngine()
+	e := g.Engine.(*TestGameEngine)
+	g.Actions = make([]Action, 0, 100)
+	g.State = g.Engine.Start(g.Id, g.Name, g.Players, 0)
+
+	g.State, _ = Submit(&g, Action{Abbr: "R", PlayerId: "A"})
+	if !g.State.IsSimultaneous() {
+		t.Fatalf("Calling for a reveal should start a simultaneous phase.")
+	}
+	if len(Waiting(g)) != 2 {
+		t.Errorf("Both players should be waiting, got %d", len(Waiting(g)))
+	}
+
+	// Bob changes his mind before Allen submits.
+	Submit(&g, Action{Abbr: "1", PlayerId: "B"})
+	Submit(&g, Action{Abbr: "2", PlayerId: "B"})
+	if len(Waiting(g)) != 1 || Waiting(g)[0].Id != "A" {
+		t.Errorf("Only Allen should still be waiting.")
+	}
+	if e.revealed != 0 {
+		t.Errorf("The engine shouldn't see any reveal until everyone submits.")
+	}
+
+	g.State, _ = Submit(&g, Action{Abbr: "1", PlayerId: "A"})
+	if g.State.IsSimultaneous() {
+		t.Errorf("The reveal should have been resolved.")
+	}
+	if e.revealed != 3 {
+		t.Errorf("Expected 3 fingers revealed, got %d", e.revealed)
+	}
+	if len(g.Pending) != 0 {
+		t.Errorf("Pending actions should be cleared after the reveal.")
+	}
+	if len(g.Actions) != 2 || len(g.Actions[1].Batch) != 2 {
+		t.Fatalf("The reveal should be stored as a single batch action.")
+	}
+	if g.Actions[1].Batch[0].PlayerId != "A" {
+		t.Errorf("The batch should be ordered like the pending players.")
+	}
+
+	// the batch should replay to the same state.
+	s, err := WriteToString(g)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var g1 Game
+	if err = ReadFromString(s, &g1); err != nil {
+		t.Fatalf("%s", err)
+	}
+	e1 := new(TestGameEngine)
+	g1.Engine = e1
+	Replay(g1)
+	if !e.Equals(e1) {
+		t.Errorf("Game engines aren't equal.\n%s\n%s", e.Debug(), e1.Debug())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	g := InitTestGameWithTestEngine()
+	e := g.Engine.(*TestGameEngine)
+	g.Actions = make([]Action, 0, 100)
+	g.State = g.Engine.Start(g.Id, g.Name, g.Players, 0)
+
+	var err error
+	_, err = Submit(&g, Action{Abbr: "A", PlayerId: "B"})
+	if _, ok := err.(*NotYourTurnError); !ok {
+		t.Errorf("Expected NotYourTurnError, got %v", err)
+	}
+	_, err = Submit(&g, Action{Abbr: "X", PlayerId: "A"})
+	if _, ok := err.(*UnknownOptionError); !ok {
+		t.Errorf("Expected UnknownOptionError, got %v", err)
+	}
+	_, err = Submit(&g, Action{Abbr: "P", PlayerId: "A", Batch: []Action{}})
+	if _, ok := err.(*UnknownOptionError); !ok {
+		t.Errorf("Players can't submit batches; expected UnknownOptionError, got %v", err)
+	}
+	bogus := []byte("{not json")
+	_, err = Submit(&g, Action{Abbr: "A", PlayerId: "A", Detail: &bogus})
+	if _, ok := err.(*InvalidDetailError); !ok {
+		t.Errorf("Expected InvalidDetailError, got %v", err)
+	}
+	if len(g.Actions) != 0 || e.actionCount != 0 {
+		t.Errorf("Rejected actions shouldn't reach the engine.")
+	}
+
+	_, err = Submit(&g, Action{Abbr: "A", PlayerId: "A"})
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	if e.actionCount != 1 {
+		t.Errorf("A valid action should reach the engine.")
+	}
+
+	// once the game is over, nobody can act.
+	g.State, _ = Submit(&g, Action{Abbr: "Q", PlayerId: "A"})
+	_, err = Submit(&g, Action{Abbr: "A", PlayerId: "A"})
+	if _, ok := err.(*NotYourTurnError); !ok {
+		t.Errorf("Expected NotYourTurnError after the game ended, got %v", err)
+	}
+}
+
+func newTestGameEngine() GameEngine {
+	return new(TestGameEngine)
+}
+
+func TestReplaySteps(t *testing.T) {
+	g := InitTestGameWithTestEngine()
+	g.Actions = make([]Action, 0, 100)
+	g.State = g.Engine.Start(g.Id, g.Name, g.Players, 0)
+	playGame(&g)
+
+	steps, err := Steps(g, newTestGameEngine, "")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(steps) != len(g.Actions)+1 {
+		t.Fatalf("Expected %d steps, got %d", len(g.Actions)+1, len(steps))
+	}
+	if steps[0].Action != nil || steps[0].Outcome.Text != "Started game." {
+		t.Errorf("Step 0 should be the start of the game.")
+	}
+	if steps[4].Action.Abbr != "A" || steps[4].Outcome.Text != "Bob acted." {
+		t.Errorf("Unexpected step 4: %s, %s", steps[4].Action.Abbr, steps[4].Outcome.Text)
+	}
+
+	step, err := ReplayTo(g, newTestGameEngine, 4, "")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	previous, err := ReplayTo(g, newTestGameEngine, 3, "")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	changes, err := Diff(previous.Snapshot, step.Snapshot)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(changes) != 1 || changes[0].Path != "actionCount" {
+		t.Errorf("Expected only actionCount to change, got %v", changes)
+	}
+
+	if _, err = ReplayTo(g, newTestGameEngine, len(g.Actions)+1, ""); err == nil {
+		t.Errorf("Replaying past the last action should fail.")
+	}
+}
+
+// brokenEngine is a TestGameEngine that can't handle any action.
+type brokenEngine struct {
+	*TestGameEngine
+}
+
+func (e brokenEngine) HandleAction(a Action) GameState {
+	panic("broken")
+}
+
+func TestReplayRejectsBadGames(t *testing.T) {
+	tests := []struct {
+		players []Player
+		actions []Action
+	}{
+		{nil, nil},
+		{[]Player{{Id: "A"}, {Id: "B"}}, []Action{{Abbr: "ZZ", PlayerId: "A"}}},
+		{[]Player{{Id: "A"}, {Id: "B"}}, []Action{{Abbr: "P", PlayerId: "B"}}},
+		{[]Player{{Id: "A"}, {Id: "B"}}, []Action{{Batch: []Action{{Abbr: "1", PlayerId: "A"}}}}},
+		{[]Player{{Id: "A"}, {Id: "B"}}, []Action{{Abbr: "R", PlayerId: "A"},
+			{Batch: []Action{{Abbr: "1", PlayerId: "B"}, {Abbr: "1", PlayerId: "A"}}}}},
+	}
+	for i, test := range tests {
+		g := Game{Players: test.players, Actions: test.actions}
+		if _, err := Steps(g, newTestGameEngine, ""); err == nil {
+			t.Errorf("Test %d: expected an error", i)
+		}
+	}
+
+	g := Game{Players: []Player{{Id: "A"}, {Id: "B"}}, Actions: []Action{{Abbr: "P", PlayerId: "A"}}}
+	broken := func() GameEngine { return brokenEngine{new(TestGameEngine)} }
+	if _, err := Steps(g, broken, ""); err == nil {
+		t.Errorf("A panicking engine should be an error.")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := `{"a":1,"b":{"c":[1,2]},"d":"x"}`
+	after := `{"a":1,"b":{"c":[1,3,4]},"e":true}`
+	changes, err := Diff(before, after)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := []string{"b.c.1", "b.c.2", "d", "e"}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %v", len(expected), changes)
+	}
+	for i, path := range expected {
+		if changes[i].Path != path {
+			t.Errorf("Expected change %d to be at %s, got %s", i, path, changes[i].Path)
+		}
+	}
+	if changes[2].New != nil || changes[3].Old != nil {
+		t.Errorf("Removed and added values should have nil New and Old.")
+	}
+}
@@ -0,0 +1,181 @@
+package gamework
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// EngineFactory returns a new, unstarted GameEngine.  Replaying a game to an
+// earlier point needs a fresh engine each time, since engines can only move
+// forward.
+type EngineFactory func() GameEngine
+
+// Step describes a game as it stood after its first Index actions were taken.
+// Action is the action that brought it there (nil for step 0, the start of
+// the game) and Outcome is the Event that resulted.  Snapshot is the engine's
+// RefreshClient payload, and is only filled in by ReplayTo.
+type Step struct {
+	Index    int
+	Action   *Action
+	Outcome  Event
+	Snapshot string
+}
+
+// Change describes one difference between two snapshots.  Path identifies
+// the value that changed, e.g. "players.0.money"; Old is nil if the value
+// was added, and New is nil if it was removed.
+type Change struct {
+	Path string
+	Old  interface{}
+	New  interface{}
+}
+
+// replayInto starts a fresh engine for the game, and applies the first n of
+// its stored actions.  It returns the engine and the steps along the way,
+// with the outcomes redacted for the player with the given ID.  The game
+// may have come from anywhere, so each action is validated before the
+// engine sees it, and if the engine panics anyway, that's an error too.
+func replayInto(g Game, newEngine EngineFactory, n int, playerId string) (e GameEngine, steps []Step, err error) {
+	if n < 0 || n > len(g.Actions) {
+		return nil, nil, fmt.Errorf("No step %d; the game has %d actions.", n, len(g.Actions))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, steps, err = nil, nil, fmt.Errorf("The game can't be replayed: %v", r)
+		}
+	}()
+	e = newEngine()
+	s, err := startEngine(g, e)
+	if err != nil {
+		return nil, nil, err
+	}
+	replayed := Game{Id: g.Id, Name: g.Name, Players: g.Players, State: s, Engine: e}
+	steps = make([]Step, 0, n+1)
+	steps = append(steps, Step{Outcome: s.Outcome.redactedFor(playerId)})
+	for i := 0; i < n; i++ {
+		a := g.Actions[i]
+		if err = replayAction(&replayed, a); err != nil {
+			return nil, nil, fmt.Errorf("Action %d: %s", i+1, err)
+		}
+		outcome := replayed.State.Outcome.redactedFor(playerId)
+		steps = append(steps, Step{Index: i + 1, Action: &a, Outcome: outcome})
+	}
+	return e, steps, nil
+}
+
+// replayAction validates a stored action against the game's state, and
+// performs it.  A batch must have one valid action for each of the pending
+// players, in order.
+func replayAction(g *Game, a Action) error {
+	if a.Batch == nil {
+		if err := Validate(*g, a); err != nil {
+			return err
+		}
+		g.State = performAction(g, a)
+		return nil
+	}
+	pending := g.State.PendingPlayers
+	if !g.State.IsSimultaneous() {
+		return fmt.Errorf("There's no simultaneous phase for the batch.")
+	}
+	if len(a.Batch) != len(pending) {
+		return fmt.Errorf("The batch has %d actions, for %d players.", len(a.Batch), len(pending))
+	}
+	for i, b := range a.Batch {
+		if b.PlayerId != pending[i].Id {
+			return &NotYourTurnError{PlayerId: b.PlayerId}
+		}
+		if err := Validate(*g, b); err != nil {
+			return err
+		}
+	}
+	g.State = performAction(g, a)
+	return nil
+}
+
+// Steps replays the game and returns every step in it, from the start of
+// the game to its current state, as the player with the given ID (or, if
+// playerId is empty, a spectator) is allowed to see them.
+func Steps(g Game, newEngine EngineFactory, playerId string) ([]Step, error) {
+	_, steps, err := replayInto(g, newEngine, len(g.Actions), playerId)
+	return steps, err
+}
+
+// ReplayTo replays the game's first n actions, and returns the resulting
+// step, including the engine's snapshot for the player with the given ID.
+func ReplayTo(g Game, newEngine EngineFactory, n int, playerId string) (Step, error) {
+	e, steps, err := replayInto(g, newEngine, n, playerId)
+	if err != nil {
+		return Step{}, err
+	}
+	step := steps[n]
+	step.Snapshot = e.RefreshClient(playerId)
+	return step, nil
+}
+
+// Diff compares two JSON snapshots and returns the values that changed,
+// ordered by path.
+func Diff(before string, after string) ([]Change, error) {
+	var b, a interface{}
+	if err := json.Unmarshal([]byte(before), &b); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(after), &a); err != nil {
+		return nil, err
+	}
+	changes := make([]Change, 0)
+	diff("", b, a, &changes)
+	return changes, nil
+}
+
+// diff appends the differences between old and new, found at path, to
+// changes.  It descends into objects and arrays; anything else that differs
+// is a single change.
+func diff(path string, old interface{}, new interface{}, changes *[]Change) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	switch o := old.(type) {
+	case map[string]interface{}:
+		if n, ok := new.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(o)+len(n))
+			for k := range o {
+				keys = append(keys, k)
+			}
+			for k := range n {
+				if _, ok := o[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				diff(join(k), o[k], n[k], changes)
+			}
+			return
+		}
+	case []interface{}:
+		if n, ok := new.([]interface{}); ok {
+			for i := 0; i < len(o) || i < len(n); i++ {
+				var ov, nv interface{}
+				if i < len(o) {
+					ov = o[i]
+				}
+				if i < len(n) {
+					nv = n[i]
+				}
+				diff(join(fmt.Sprintf("%d", i)), ov, nv, changes)
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		*changes = append(*changes, Change{Path: path, Old: old, New: new})
+	}
+}
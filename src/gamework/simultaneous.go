@@ -0,0 +1,69 @@
+package gamework
+
+// Submit validates an action (see Validate) and hands it to the game, so
+// that the engine never sees an illegal action.  Outside of a simultaneous
+// phase it's performed immediately.  During one, it's held in g.Pending (replacing any
+// earlier submission from the same player) until every pending player has
+// submitted; then the engine receives them all at once, as a single Action
+// whose Batch is ordered like g.State.PendingPlayers.  Submit returns the
+// game's state, which doesn't change while submissions are being collected.
+func Submit(g *Game, a Action) (GameState, error) {
+	if err := Validate(*g, a); err != nil {
+		return g.State, err
+	}
+	if !g.State.IsSimultaneous() {
+		g.State = performAction(g, a)
+		return g.State, nil
+	}
+
+	replaced := false
+	for i, p := range g.Pending {
+		if p.PlayerId == a.PlayerId {
+			g.Pending[i] = a
+			replaced = true
+		}
+	}
+	if !replaced {
+		g.Pending = append(g.Pending, a)
+	}
+	if len(Waiting(*g)) > 0 {
+		return g.State, nil
+	}
+
+	batch := make([]Action, len(g.State.PendingPlayers))
+	for i, p := range g.State.PendingPlayers {
+		batch[i] = g.pendingActionOf(p.Id)
+	}
+	g.Pending = nil
+	g.State = performAction(g, Action{Batch: batch})
+	return g.State, nil
+}
+
+// Waiting returns the players who still have to submit an action during a
+// simultaneous phase, or just the ActingPlayer otherwise.
+func Waiting(g Game) []Player {
+	if !g.State.IsSimultaneous() {
+		if g.State.IsOver() {
+			return nil
+		}
+		return []Player{g.State.ActingPlayer}
+	}
+	waiting := make([]Player, 0, len(g.State.PendingPlayers))
+	for _, p := range g.State.PendingPlayers {
+		if g.pendingActionOf(p.Id).PlayerId == "" {
+			waiting = append(waiting, p)
+		}
+	}
+	return waiting
+}
+
+// pendingActionOf returns the action that the player with the given ID has
+// submitted in the current simultaneous phase, or an empty Action.
+func (g *Game) pendingActionOf(playerId string) Action {
+	for _, a := range g.Pending {
+		if a.PlayerId == playerId {
+			return a
+		}
+	}
+	return Action{}
+}
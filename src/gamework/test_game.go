@@ -2,7 +2,10 @@
 
 TestGameEngine implements an extremely simple "game".  On each player's
 turn, he can act or pass.  If he acts, he can act again, and keep acting
-until he passes.  Finally, a player can quit, which ends the game.
+until he passes.  He can also call for a reveal, in which every player
+simultaneously reveals one or two fingers; the total is added to the
+engine's count of revealed fingers.  Finally, a player can quit, which
+ends the game.
 
 The purpose of this is to facilitate writing tests of the GameEngine,
 particularly the serialization and deserialization features.
@@ -20,6 +23,7 @@ type TestGameEngine struct {
 	players      []Player
 	actingPlayer int
 	actionCount  int
+	revealed     int
 }
 
 func InitTestGameWithTestEngine() Game {
@@ -53,6 +57,9 @@ func (e0 *TestGameEngine) Equals(e GameEngine) bool {
 	if e0.actionCount != e1.actionCount {
 		return false
 	}
+	if e0.revealed != e1.revealed {
+		return false
+	}
 	if len(e0.players) != len(e1.players) {
 		return false
 	}
@@ -87,16 +94,52 @@ func (g *TestGameEngine) defaultGameState(text string, player Player) GameState
 
 func (g *TestGameEngine) defaultOptions() []Option {
 
-	var options = make([]Option, 3)
+	var options = make([]Option, 4)
 	options[0] = Option{Abbr: "A", Text: "Act"}
 	options[1] = Option{Abbr: "P", Text: "Pass"}
-	options[2] = Option{Abbr: "Q", Text: "Quit"}
+	options[2] = Option{Abbr: "R", Text: "Reveal"}
+	options[3] = Option{Abbr: "Q", Text: "Quit"}
 
 	return options
 }
 
+// revealState is the simultaneous state in which every player chooses
+// how many fingers to reveal.
+func (g *TestGameEngine) revealState(text string) GameState {
+	playerOptions := make(map[string][]Option, len(g.players))
+	for _, p := range g.players {
+		playerOptions[p.Id] = []Option{
+			Option{Abbr: "1", Text: "Reveal one finger"},
+			Option{Abbr: "2", Text: "Reveal two fingers"}}
+	}
+	return GameState{
+		Outcome:        Event{Text: text},
+		PendingPlayers: g.players,
+		PlayerOptions:  playerOptions}
+}
+
+// handleReveal resolves a reveal once every player has chosen.
+func (g *TestGameEngine) handleReveal(batch []Action) GameState {
+	text := ""
+	for i, a := range batch {
+		fingers := 1
+		if a.Abbr == "2" {
+			fingers = 2
+		}
+		g.revealed += fingers
+		if i > 0 {
+			text += ", "
+		}
+		text += fmt.Sprintf("%s revealed %d", g.players[i].Name, fingers)
+	}
+	return g.defaultGameState(text+".", g.players[g.actingPlayer])
+}
+
 func (g *TestGameEngine) HandleAction(action Action) GameState {
 
+	if action.Batch != nil {
+		return g.handleReveal(action.Batch)
+	}
 	name := g.players[g.actingPlayer].Name
 	if action.Abbr == "A" {
 		g.actionCount += 1
@@ -115,6 +158,9 @@ func (g *TestGameEngine) HandleAction(action Action) GameState {
 			ActingPlayer:     g.players[g.actingPlayer],
 			AvailableOptions: g.defaultOptions()}
 	}
+	if action.Abbr == "R" {
+		return g.revealState(fmt.Sprintf("%s called for a reveal.", name))
+	}
 	if action.Abbr == "Q" {
 		return GameState{
 			Outcome: Event{Text: fmt.Sprintf("%s quit, game over.", name)}}
@@ -122,11 +168,13 @@ func (g *TestGameEngine) HandleAction(action Action) GameState {
 	panic(fmt.Sprintf("Invalid abbr: %s", action.Abbr))
 }
 
+// RefreshClient returns the engine's state as JSON.
 func (g *TestGameEngine) RefreshClient(_ string) string {
-	return "RefreshClient."
+	return fmt.Sprintf(`{"actingPlayer":%d,"actionCount":%d,"revealed":%d}`,
+		g.actingPlayer, g.actionCount, g.revealed)
 }
 
 func (g *TestGameEngine) Debug() string {
-	return fmt.Sprintf("id=%s\nname=%s\nactionCount=%d\nactingPlayer=%d\nlen(g.players)=%d\n",
-		g.id, g.name, g.actionCount, g.actingPlayer, len(g.players))
+	return fmt.Sprintf("id=%s\nname=%s\nactionCount=%d\nrevealed=%d\nactingPlayer=%d\nlen(g.players)=%d\n",
+		g.id, g.name, g.actionCount, g.revealed, g.actingPlayer, len(g.players))
 }
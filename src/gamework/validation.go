@@ -0,0 +1,93 @@
+package gamework
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// NotYourTurnError is returned when a player submits an action while the
+// game isn't waiting on that player.
+type NotYourTurnError struct {
+	PlayerId string
+}
+
+func (e *NotYourTurnError) Error() string {
+	return fmt.Sprintf("It's not player %s's turn.", e.PlayerId)
+}
+
+// UnknownOptionError is returned when an action's Abbr doesn't match any of
+// the options available to the player who submitted it.
+type UnknownOptionError struct {
+	PlayerId string
+	Abbr     string
+}
+
+func (e *UnknownOptionError) Error() string {
+	return fmt.Sprintf("%s isn't an option for player %s.", e.Abbr, e.PlayerId)
+}
+
+// InvalidDetailError is returned when an action's Detail isn't acceptable
+// for the option it was submitted for.  Err describes what's wrong with it.
+type InvalidDetailError struct {
+	Abbr string
+	Err  error
+}
+
+func (e *InvalidDetailError) Error() string {
+	return fmt.Sprintf("Invalid detail for %s: %s", e.Abbr, e.Err)
+}
+
+// DetailValidator may be implemented by a GameEngine whose options take a
+// Detail.  ValidateDetail is called before the action reaches HandleAction,
+// and should return an error if the detail isn't acceptable for the option.
+type DetailValidator interface {
+	ValidateDetail(option Option, detail *[]byte) error
+}
+
+// Validate checks an action against the game's current state:  the player
+// who submitted it must be one the game is waiting on, its Abbr must match
+// one of that player's options, and its Detail (if any) must be well-formed
+// JSON that the engine, if it's a DetailValidator, accepts.  The error is a
+// *NotYourTurnError, *UnknownOptionError, or *InvalidDetailError.
+func Validate(g Game, a Action) error {
+	// during a simultaneous phase, players may change their minds until
+	// everybody has submitted, so any pending player may act.
+	players := Waiting(g)
+	if g.State.IsSimultaneous() {
+		players = g.State.PendingPlayers
+	}
+	waiting := false
+	for _, p := range players {
+		if p.Id == a.PlayerId {
+			waiting = true
+		}
+	}
+	if !waiting {
+		return &NotYourTurnError{PlayerId: a.PlayerId}
+	}
+
+	var option *Option
+	for _, o := range g.State.OptionsFor(a.PlayerId) {
+		if o.Abbr == a.Abbr {
+			option = &o
+			break
+		}
+	}
+	if option == nil || a.Batch != nil {
+		return &UnknownOptionError{PlayerId: a.PlayerId, Abbr: a.Abbr}
+	}
+
+	if a.Detail == nil {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(*a.Detail, &v); err != nil {
+		return &InvalidDetailError{Abbr: a.Abbr, Err: err}
+	}
+	if dv, ok := g.Engine.(DetailValidator); ok {
+		if err := dv.ValidateDetail(*option, a.Detail); err != nil {
+			return &InvalidDetailError{Abbr: a.Abbr, Err: err}
+		}
+	}
+	return nil
+}
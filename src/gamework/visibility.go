@@ -0,0 +1,128 @@
+package gamework
+
+// Visibility determines which players are allowed to see an Event, or the
+// Detail of an Option.
+type Visibility int
+
+const (
+	// Public information is visible to every player and to spectators.
+	Public Visibility = iota
+
+	// Restricted information is visible only to the players whose IDs
+	// are listed in the Audience.
+	Restricted
+
+	// Private information is visible only to the player who was acting
+	// when it was produced.  The framework fills in the Audience when it
+	// records a private Event, so engines needn't.
+	Private
+)
+
+// View is what a single player is allowed to see of a GameState.  Outcome is
+// nil if the player may not see it.  Options is empty unless the player has
+// something to choose, and contains only the Details the player may see.
+type View struct {
+	PlayerId string
+	Outcome  *Event
+	Options  []Option
+}
+
+// visibleTo reports whether a player may see information with the given
+// visibility and audience.  Spectators (an empty playerId) only ever see
+// public information.
+func visibleTo(v Visibility, audience []string, playerId string) bool {
+	if v == Public {
+		return true
+	}
+	if playerId == "" {
+		return false
+	}
+	for _, id := range audience {
+		if id == playerId {
+			return true
+		}
+	}
+	return false
+}
+
+// VisibleTo reports whether the player with the given ID may see the Event.
+func (e Event) VisibleTo(playerId string) bool {
+	return visibleTo(e.Visibility, e.Audience, playerId)
+}
+
+// DetailVisibleTo reports whether the player with the given ID may see the
+// Option's Detail.
+func (o Option) DetailVisibleTo(playerId string) bool {
+	return visibleTo(o.Visibility, o.Audience, playerId)
+}
+
+// actorsOf returns the IDs of the players who took the action in the given
+// state:  the ActingPlayer, or, for a batch, every player in the batch.
+func actorsOf(s GameState, a Action) []string {
+	if a.Batch == nil {
+		if s.ActingPlayer.Id == "" {
+			return nil
+		}
+		return []string{s.ActingPlayer.Id}
+	}
+	actors := make([]string, 0, len(a.Batch))
+	for _, b := range a.Batch {
+		if b.PlayerId != "" {
+			actors = append(actors, b.PlayerId)
+		}
+	}
+	return actors
+}
+
+// addressedFrom fills in the Audience of a Private event with the IDs of
+// the players whose action produced it.
+func (e Event) addressedFrom(actors []string) Event {
+	if e.Visibility == Private && len(e.Audience) == 0 && len(actors) > 0 {
+		e.Audience = actors
+	}
+	return e
+}
+
+// redactedFor returns the Event as the given player is allowed to see it:
+// unchanged if it's visible, and stripped of its Text and Detail if not.
+func (e Event) redactedFor(playerId string) Event {
+	if e.VisibleTo(playerId) {
+		return e
+	}
+	return Event{Abbr: e.Abbr, Visibility: e.Visibility}
+}
+
+// ViewFor returns the portion of the state that the player with the given
+// ID is allowed to see.
+func ViewFor(state GameState, playerId string) View {
+	v := View{PlayerId: playerId}
+	if state.Outcome.VisibleTo(playerId) {
+		outcome := state.Outcome
+		v.Outcome = &outcome
+	}
+	options := state.OptionsFor(playerId)
+	if len(options) == 0 {
+		return v
+	}
+	v.Options = make([]Option, len(options))
+	for i, o := range options {
+		// Options only ever go to the player choosing them, so
+		// private details are always visible here.
+		if o.Visibility != Private && !o.DetailVisibleTo(playerId) {
+			o.Detail = nil
+		}
+		v.Options[i] = o
+	}
+	return v
+}
+
+// FanOut returns one View of the game's current state for each of its
+// players, in the order of g.Players.  This is what a server should send
+// to its clients whenever the state changes.
+func FanOut(g Game) []View {
+	views := make([]View, len(g.Players))
+	for i, p := range g.Players {
+		views[i] = ViewFor(g.State, p.Id)
+	}
+	return views
+}
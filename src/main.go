@@ -1,8 +1,21 @@
 package main
 
-import "werks"
-
+import (
+	"log"
+	"os"
+	"werks"
+)
 
+// main runs the server from src, so the static files are one directory up
+// unless the configuration says otherwise.
 func main() {
-	werks.Serve()
+	defaults := werks.DefaultServerConfig()
+	defaults.RootPath = ".."
+	config, err := werks.LoadServerConfig(defaults, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = werks.Serve(config); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,131 @@
+/*
+Package turnorder decides the order in which players take their turns.
+
+Players are identified by their seats, numbered from 0 clockwise around the
+table.  A Policy turns the previous turn's order into the next one's, and a
+Queue hands out the seats in that order, one decision at a time.
+*/
+package turnorder
+
+import "sort"
+
+// Policy decides the order of a turn.  Order returns the seats in the order
+// they'll play the given turn (numbered from 1), given the order they played
+// the previous turn in.  For the first turn, prev is Seats(n).
+type Policy interface {
+	Order(prev []int, turn int) []int
+}
+
+// Seats returns the seats of n players in clockwise order.
+func Seats(n int) []int {
+	seats := make([]int, n)
+	for i := range seats {
+		seats[i] = i
+	}
+	return seats
+}
+
+// Clockwise is the fixed order around the table:  seat 0 always starts.
+type Clockwise struct{}
+
+func (Clockwise) Order(prev []int, turn int) []int {
+	return Seats(len(prev))
+}
+
+// RotateStart goes clockwise, but the start player moves one seat to the
+// left each turn.
+type RotateStart struct{}
+
+func (RotateStart) Order(prev []int, turn int) []int {
+	n := len(prev)
+	order := make([]int, n)
+	if n == 0 {
+		return order
+	}
+	start := (turn - 1) % n
+	if start < 0 {
+		start += n
+	}
+	for i := range order {
+		order[i] = (start + i) % n
+	}
+	return order
+}
+
+// ByScore puts the seat with the highest Score first.  Ties are broken by
+// the previous order, so a player who tied with another keeps their place
+// relative to them.
+type ByScore struct {
+	Score func(seat int) int
+}
+
+func (p ByScore) Order(prev []int, turn int) []int {
+	order := make([]int, len(prev))
+	copy(order, prev)
+	sort.Stable(byScore{order, p.Score})
+	return order
+}
+
+type byScore struct {
+	seats []int
+	score func(seat int) int
+}
+
+func (s byScore) Len() int           { return len(s.seats) }
+func (s byScore) Less(i, j int) bool { return s.score(s.seats[i]) > s.score(s.seats[j]) }
+func (s byScore) Swap(i, j int)      { s.seats[i], s.seats[j] = s.seats[j], s.seats[i] }
+
+// Reverse plays the order Policy decides backwards, e.g. so that the player
+// who's furthest behind goes first under a catch-up rule.
+type Reverse struct {
+	Policy Policy
+}
+
+func (p Reverse) Order(prev []int, turn int) []int {
+	order := p.Policy.Order(prev, turn)
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// Ties returns the pairs of seats next to each other in order whose scores
+// are equal, i.e. the places where ByScore had to break a tie.
+func Ties(order []int, score func(seat int) int) [][2]int {
+	ties := make([][2]int, 0)
+	for i := 1; i < len(order); i++ {
+		if score(order[i-1]) == score(order[i]) {
+			ties = append(ties, [2]int{order[i-1], order[i]})
+		}
+	}
+	return ties
+}
+
+// Queue hands out the seats of one round of decisions in order.  The zero
+// Queue is empty.
+type Queue struct {
+	order []int
+}
+
+// NewQueue returns a Queue of the seats in order.
+func NewQueue(order []int) Queue {
+	q := Queue{order: make([]int, len(order))}
+	copy(q.order, order)
+	return q
+}
+
+// Len returns the number of seats left in the queue.
+func (q *Queue) Len() int {
+	return len(q.order)
+}
+
+// Next removes and returns the next seat.  It returns false if the queue is
+// empty.
+func (q *Queue) Next() (int, bool) {
+	if len(q.order) == 0 {
+		return -1, false
+	}
+	seat := q.order[0]
+	q.order = q.order[1:]
+	return seat, true
+}
@@ -0,0 +1,97 @@
+package turnorder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClockwise(t *testing.T) {
+	for turn := 1; turn <= 3; turn++ {
+		order := Clockwise{}.Order([]int{2, 0, 1}, turn)
+		if !reflect.DeepEqual(order, []int{0, 1, 2}) {
+			t.Errorf("Turn %d: expected [0 1 2], got %v", turn, order)
+		}
+	}
+}
+
+func TestRotateStart(t *testing.T) {
+	expected := [][]int{{0, 1, 2}, {1, 2, 0}, {2, 0, 1}, {0, 1, 2}}
+	prev := Seats(3)
+	for i, e := range expected {
+		order := RotateStart{}.Order(prev, i+1)
+		if !reflect.DeepEqual(order, e) {
+			t.Errorf("Turn %d: expected %v, got %v", i+1, e, order)
+		}
+		prev = order
+	}
+	if order := (RotateStart{}).Order([]int{}, 1); len(order) != 0 {
+		t.Errorf("No players should mean no order, got %v", order)
+	}
+}
+
+func TestByScore(t *testing.T) {
+	money := []int{12, 30, 12, 13}
+	p := ByScore{Score: func(seat int) int { return money[seat] }}
+
+	order := p.Order(Seats(4), 1)
+	if !reflect.DeepEqual(order, []int{1, 3, 0, 2}) {
+		t.Errorf("Expected [1 3 0 2], got %v", order)
+	}
+
+	// seat 2 was ahead of seat 0 last turn, so it stays ahead.
+	order = p.Order([]int{2, 1, 0, 3}, 2)
+	if !reflect.DeepEqual(order, []int{1, 3, 2, 0}) {
+		t.Errorf("Expected [1 3 2 0], got %v", order)
+	}
+
+	// the score is compared exactly, however far apart the previous
+	// places are.
+	money = []int{10, 11}
+	order = p.Order([]int{0, 1}, 3)
+	if !reflect.DeepEqual(order, []int{1, 0}) {
+		t.Errorf("Expected [1 0], got %v", order)
+	}
+}
+
+func TestReverse(t *testing.T) {
+	money := []int{5, 20, 10}
+	p := Reverse{ByScore{Score: func(seat int) int { return money[seat] }}}
+	order := p.Order(Seats(3), 1)
+	if !reflect.DeepEqual(order, []int{0, 2, 1}) {
+		t.Errorf("Expected [0 2 1], got %v", order)
+	}
+
+	order = Reverse{RotateStart{}}.Order(Seats(3), 2)
+	if !reflect.DeepEqual(order, []int{0, 2, 1}) {
+		t.Errorf("Expected [0 2 1], got %v", order)
+	}
+}
+
+func TestTies(t *testing.T) {
+	money := []int{12, 30, 12, 12}
+	score := func(seat int) int { return money[seat] }
+	ties := Ties([]int{1, 0, 2, 3}, score)
+	if !reflect.DeepEqual(ties, [][2]int{{0, 2}, {2, 3}}) {
+		t.Errorf("Expected [[0 2] [2 3]], got %v", ties)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	order := []int{2, 0, 1}
+	q := NewQueue(order)
+	order[0] = 9
+	for _, expected := range []int{2, 0, 1} {
+		seat, ok := q.Next()
+		if !ok || seat != expected {
+			t.Errorf("Expected %d, got %d (%t)", expected, seat, ok)
+		}
+	}
+	if seat, ok := q.Next(); ok || q.Len() != 0 {
+		t.Errorf("The queue should be empty, got %d", seat)
+	}
+
+	var empty Queue
+	if _, ok := empty.Next(); ok {
+		t.Errorf("The zero Queue should be empty.")
+	}
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+package user
+
+import (
+	"os"
+	"syscall"
+)
+
+// Lock locks the users file against other processes, until Unlock is
+// called or the process exits.  It returns ErrLocked if another process has
+// it locked.  The lock is taken on a file next to the users file, since the
+// users file itself is replaced whenever it's saved.
+func (s *Users) Lock() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.lock != nil {
+		return nil
+	}
+	f, err := os.OpenFile(s.userFilename+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return ErrLocked
+		}
+		return err
+	}
+	s.lock = f
+	return nil
+}
+
+// syncDir syncs a directory, so that the files renamed into it stay there.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
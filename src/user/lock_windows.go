@@ -0,0 +1,50 @@
+package user
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// The flags and error LockFileEx uses.
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// Lock locks the users file against other processes, until Unlock is
+// called or the process exits.  It returns ErrLocked if another process has
+// it locked.  The lock is taken on a file next to the users file, since the
+// users file itself is replaced whenever it's saved.
+func (s *Users) Lock() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.lock != nil {
+		return nil
+	}
+	f, err := os.OpenFile(s.userFilename+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	var overlapped syscall.Overlapped
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately,
+		0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r == 0 {
+		f.Close()
+		if err == errorLockViolation {
+			return ErrLocked
+		}
+		return err
+	}
+	s.lock = f
+	return nil
+}
+
+// syncDir does nothing on Windows, where directories can't be synced.
+func syncDir(dir string) error {
+	return nil
+}
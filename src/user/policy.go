@@ -0,0 +1,139 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy is what Register requires of nicknames and passwords.  A nickname
+// may have at most MaxNickname characters, which must be ASCII letters and
+// digits or in NicknameChars, and must have at least MinNickname letters and
+// digits.  Other scripts have letters that look like ASCII ones (such as the
+// Cyrillic а), which would let a nickname pass for a reserved or taken one.
+// Nicknames whose canonical forms match one of Reserved are refused.
+// Passwords must have between MinPassword and MaxPassword characters.  A
+// maximum of 0 means there's no maximum.
+type Policy struct {
+	MinNickname   int      `json:"minNickname"`
+	MaxNickname   int      `json:"maxNickname"`
+	NicknameChars string   `json:"nicknameChars"`
+	Reserved      []string `json:"reserved"`
+	MinPassword   int      `json:"minPassword"`
+	MaxPassword   int      `json:"maxPassword"`
+}
+
+// DefaultPolicy returns the policy Init gives new Users.
+func DefaultPolicy() Policy {
+	return Policy{
+		MinNickname:   2,
+		MaxNickname:   24,
+		NicknameChars: " -_.'",
+		Reserved:      []string{"admin", "administrator", "moderator", "root", "system", "werks"},
+		MinPassword:   8,
+		MaxPassword:   128}
+}
+
+// The codes of ValidationErrors.
+const (
+	TooShort          = "too_short"
+	TooLong           = "too_long"
+	InvalidCharacters = "invalid_characters"
+	Reserved          = "reserved"
+	Taken             = "taken"
+	Blank             = "blank"
+)
+
+// ValidationError is a reason a nickname or password was refused.  Field is
+// "nickname" or "password", and Code is one of the codes above.
+type ValidationError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors are every reason a registration was refused.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, v := range e {
+		messages[i] = v.Message
+	}
+	return strings.Join(messages, " ")
+}
+
+// normalizeNickname puts the nickname in the form it's saved in:  it
+// replaces fullwidth forms with their ASCII equivalents, and collapses
+// whitespace into single spaces.  It doesn't fold any other lookalikes, so
+// the Policy only allows ASCII letters and digits.
+func normalizeNickname(nickname string) string {
+	nickname = strings.Map(func(r rune) rune {
+		switch {
+		case r >= '！' && r <= '～':
+			return r - '！' + '!'
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return r
+	}, nickname)
+	return strings.Join(strings.Fields(nickname), " ")
+}
+
+// ValidateNickname returns the reasons the policy refuses the nickname.
+func (p *Policy) ValidateNickname(nickname string) ValidationErrors {
+	errs := make(ValidationErrors, 0)
+	add := func(code, format string, args ...interface{}) {
+		errs = append(errs, &ValidationError{Field: "nickname", Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+	n := normalizeNickname(nickname)
+	if p.MaxNickname > 0 && utf8.RuneCountInString(n) > p.MaxNickname {
+		add(TooLong, "Nicknames can't be longer than %d characters.", p.MaxNickname)
+	}
+	for _, r := range n {
+		if !isASCIILetterOrDigit(r) && !strings.ContainsRune(p.NicknameChars, r) {
+			add(InvalidCharacters, "Nicknames may only contain the letters A to Z, digits and %q.", p.NicknameChars)
+			break
+		}
+	}
+	key := canonicalizeNickname(n)
+	if utf8.RuneCountInString(key) < p.MinNickname || key == "" {
+		add(TooShort, "Nicknames need at least %d letters or digits.", p.MinNickname)
+	}
+	for _, reserved := range p.Reserved {
+		if key == canonicalizeNickname(reserved) {
+			add(Reserved, "%s is reserved.", n)
+			break
+		}
+	}
+	return errs
+}
+
+// isASCIILetterOrDigit returns whether r is one of A to Z, a to z or 0 to 9.
+func isASCIILetterOrDigit(r rune) bool {
+	return r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// ValidatePassword returns the reasons the policy refuses the password.
+func (p *Policy) ValidatePassword(password string) ValidationErrors {
+	errs := make(ValidationErrors, 0)
+	add := func(code, format string, args ...interface{}) {
+		errs = append(errs, &ValidationError{Field: "password", Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+	length := utf8.RuneCountInString(password)
+	if length < p.MinPassword {
+		add(TooShort, "Passwords need at least %d characters.", p.MinPassword)
+	}
+	if p.MaxPassword > 0 && length > p.MaxPassword {
+		add(TooLong, "Passwords can't be longer than %d characters.", p.MaxPassword)
+	}
+	if length > 0 && strings.TrimSpace(password) == "" {
+		add(Blank, "Passwords can't be only spaces.")
+	}
+	return errs
+}
@@ -0,0 +1,133 @@
+package user
+
+import (
+	"testing"
+)
+
+// codes returns the fields and codes of the errors, as "field:code".
+func codes(errs ValidationErrors) []string {
+	c := make([]string, len(errs))
+	for i, e := range errs {
+		c[i] = e.Field + ":" + e.Code
+	}
+	return c
+}
+
+func TestValidateNickname(t *testing.T) {
+	p := DefaultPolicy()
+	good := []string{"Al", "Mary-Jane", "O'Brien", "Jose", "Ｗｉｄｅ", "two  words"}
+	for _, n := range good {
+		if errs := p.ValidateNickname(n); len(errs) > 0 {
+			t.Errorf("%q should be allowed: %s", n, errs)
+		}
+	}
+	bad := map[string]string{
+		"":                          TooShort,
+		"!!!":                       InvalidCharacters,
+		"a":                         TooShort,
+		"Admin":                     Reserved,
+		"r.o.o.t":                   Reserved,
+		"ＡＤＭＩＮ":                     Reserved,
+		"Jose\u0301":                InvalidCharacters,
+		"José":                      InvalidCharacters,
+		"semi;colon":                InvalidCharacters,
+		"abcdefghijklmnopqrstuvwxy": TooLong,
+	}
+	for n, code := range bad {
+		found := false
+		for _, e := range p.ValidateNickname(n) {
+			found = found || (e.Field == "nickname" && e.Code == code && e.Message != "")
+		}
+		if !found {
+			t.Errorf("%q should be refused as %s, got %v", n, code, codes(p.ValidateNickname(n)))
+		}
+	}
+}
+
+func TestNicknameLookalikes(t *testing.T) {
+	p := DefaultPolicy()
+	lookalikes := []string{
+		"\u0430dmin",     // a Cyrillic a
+		"r\u043e\u043et", // Cyrillic o's
+		"\U0001d41a\U0001d41d\U0001d426\U0001d422\U0001d427", // mathematical bold
+		"werks\u0663", // an Arabic-Indic digit
+	}
+	for _, n := range lookalikes {
+		errs := p.ValidateNickname(n)
+		if len(errs) == 0 || errs[0].Code != InvalidCharacters {
+			t.Errorf("%q should be refused, got %v", n, codes(errs))
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	p := DefaultPolicy()
+	if errs := p.ValidatePassword("correct horse battery staple"); len(errs) > 0 {
+		t.Errorf("A passphrase should be allowed: %s", errs)
+	}
+	bad := map[string]string{
+		"":                        TooShort,
+		"short":                   TooShort,
+		"         ":               Blank,
+		string(make([]byte, 129)): TooLong,
+	}
+	for pw, code := range bad {
+		errs := p.ValidatePassword(pw)
+		if len(errs) == 0 || errs[0].Field != "password" || errs[0].Code != code {
+			t.Errorf("%q should be refused as %s, got %v", pw, code, codes(errs))
+		}
+	}
+}
+
+func TestRegisterPolicy(t *testing.T) {
+	s := Init(salt, filename)
+	_, err := s.Register("", "")
+	errs, ok := err.(ValidationErrors)
+	if !ok || len(errs) != 2 || errs[0].Field != "nickname" || errs[1].Field != "password" {
+		t.Errorf("Expected errors for both fields, got %v", err)
+	}
+	if _, err = s.Register("Ｗｉｄｅ  Load", "wide load ahead"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if u := s.LookupByNickname("wideload"); u == nil || u.Nickname != "Wide Load" {
+		t.Errorf("The nickname should have been normalized, got %+v", u)
+	}
+	_, err = s.Register("wide-load", "wide load ahead")
+	if errs, ok = err.(ValidationErrors); !ok || len(errs) != 1 || errs[0].Code != Taken {
+		t.Errorf("Expected the nickname to be taken, got %v", err)
+	}
+
+	// the application can still create reserved users.
+	if _, err = s.Add("admin", "admin"); err != nil {
+		t.Errorf("%s", err)
+	}
+	s.Policy.Reserved = nil
+	s.Policy.MinPassword = 4
+	if _, err = s.Register("root", "toor"); err != nil {
+		t.Errorf("The policy should be configurable: %s", err)
+	}
+}
+
+func TestPasswordWhitespace(t *testing.T) {
+	s := Init(salt, filename)
+	if _, err := s.Register("spacey", "open sesame"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := s.Login("spacey", "opensesame"); err != InvalidPasswordError {
+		t.Errorf("Whitespace in passwords should count, got %v", err)
+	}
+
+	// users whose passwords were hashed without whitespace can still log
+	// in, and have their passwords hashed again.
+	u := s.LookupByNickname("spacey")
+	u.Pwhash, u.PwVersion = s.hashPassword("opensesame"), 0
+	if _, err := s.Login("spacey", "open sesame"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if u.PwVersion != 1 || u.Pwhash != s.hashPassword("open sesame") {
+		t.Errorf("The password should have been hashed again.")
+	}
+	if _, err := s.Login("spacey", "opensesame"); err != InvalidPasswordError {
+		t.Errorf("Expected the new hash to be used, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package user
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The limits on profiles.
+const (
+	MaxDisplayName = 32
+	MaxColors      = 4
+	MaxBio         = 500
+)
+
+// The codes of ValidationErrors for profiles, besides TooLong.
+const (
+	InvalidURL   = "invalid_url"
+	InvalidColor = "invalid_color"
+)
+
+var colorPattern = regexp.MustCompile("^#[0-9a-f]{6}$")
+
+// Profile is what a user tells other players about themselves.  Colors are
+// the player colors the user prefers, most preferred first, as "#rrggbb".
+type Profile struct {
+	DisplayName string   `json:"displayName,omitempty"`
+	AvatarURL   string   `json:"avatarUrl,omitempty"`
+	Colors      []string `json:"colors,omitempty"`
+	Bio         string   `json:"bio,omitempty"`
+}
+
+// normalize trims the profile's text, and puts its colors in lower case.
+func (p *Profile) normalize() {
+	p.DisplayName = strings.Join(strings.Fields(p.DisplayName), " ")
+	p.AvatarURL = strings.TrimSpace(p.AvatarURL)
+	p.Bio = strings.TrimSpace(p.Bio)
+	for i, c := range p.Colors {
+		p.Colors[i] = strings.ToLower(strings.TrimSpace(c))
+	}
+}
+
+// Validate returns the reasons the profile can't be used.
+func (p *Profile) Validate() ValidationErrors {
+	errs := make(ValidationErrors, 0)
+	add := func(field, code, format string, args ...interface{}) {
+		errs = append(errs, &ValidationError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+	if utf8.RuneCountInString(p.DisplayName) > MaxDisplayName {
+		add("displayName", TooLong, "Display names can't be longer than %d characters.", MaxDisplayName)
+	}
+	if strings.IndexFunc(p.DisplayName, unicode.IsControl) >= 0 {
+		add("displayName", InvalidCharacters, "Display names can't contain control characters.")
+	}
+	if p.AvatarURL != "" {
+		u, err := url.Parse(p.AvatarURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("avatarUrl", InvalidURL, "Avatars must be http or https URLs.")
+		}
+	}
+	if len(p.Colors) > MaxColors {
+		add("colors", TooLong, "You can't prefer more than %d colors.", MaxColors)
+	}
+	for _, c := range p.Colors {
+		if !colorPattern.MatchString(c) {
+			add("colors", InvalidColor, "%q isn't a color like #c0ffee.", c)
+			break
+		}
+	}
+	if utf8.RuneCountInString(p.Bio) > MaxBio {
+		add("bio", TooLong, "Bios can't be longer than %d characters.", MaxBio)
+	}
+	return errs
+}
+
+// UpdateProfile replaces the user's profile, returning ValidationErrors if
+// the profile can't be used.
+func (s *Users) UpdateProfile(u *User, p Profile) error {
+	p.Colors = append([]string(nil), p.Colors...)
+	p.normalize()
+	if errs := p.Validate(); len(errs) > 0 {
+		return errs
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u.Profile = p
+	return nil
+}
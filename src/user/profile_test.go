@@ -0,0 +1,69 @@
+package user
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	s := Init(salt, filename)
+	u, err := s.Register("Painter", "open sesame")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	colors := []string{" #C0FFEE", "#000000"}
+	p := Profile{
+		DisplayName: "  The   Painter ",
+		AvatarURL:   "https://example.com/painter.png",
+		Colors:      colors,
+		Bio:         "Paints locomotives.\n"}
+	if err = s.UpdateProfile(u, p); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if u.Profile.DisplayName != "The Painter" || u.Profile.Colors[0] != "#c0ffee" ||
+		u.Profile.Bio != "Paints locomotives." {
+		t.Errorf("The profile should have been normalized, got %+v", u.Profile)
+	}
+	if colors[0] != " #C0FFEE" {
+		t.Errorf("The profile given shouldn't have been changed.")
+	}
+
+	bad := Profile{
+		DisplayName: strings.Repeat("x", MaxDisplayName+1),
+		AvatarURL:   "javascript:alert(1)",
+		Colors:      []string{"red"},
+		Bio:         strings.Repeat("x", MaxBio+1)}
+	err = s.UpdateProfile(u, bad)
+	errs, ok := err.(ValidationErrors)
+	expected := []string{"displayName:too_long", "avatarUrl:invalid_url", "colors:invalid_color", "bio:too_long"}
+	if !ok || strings.Join(codes(errs), " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+	if u.Profile.DisplayName != "The Painter" {
+		t.Errorf("A bad profile shouldn't replace the old one.")
+	}
+	if err = s.UpdateProfile(u, Profile{Colors: []string{"#000000", "#111111", "#222222", "#333333", "#444444"}}); err == nil {
+		t.Errorf("Too many colors should be refused.")
+	}
+}
+
+func TestSaveProfile(t *testing.T) {
+	filename, cleanup := tempFilename(t)
+	defer cleanup()
+	s := Init(salt, filename)
+	saveNew(t, s, "Painter")
+	u := s.LookupByNickname("painter")
+	if err := s.UpdateProfile(u, Profile{DisplayName: "The Painter", Colors: []string{"#c0ffee"}}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := s.SaveUsers(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	s = Init(salt, filename)
+	if err := s.LoadUsers(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if p := s.LookupByNickname("painter").Profile; p.DisplayName != "The Painter" || len(p.Colors) != 1 {
+		t.Errorf("The profile should have been saved, got %+v", p)
+	}
+}
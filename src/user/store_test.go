@@ -0,0 +1,177 @@
+package user
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// tempFilename returns the name of a users file in a new directory, and a
+// function that removes the directory.
+func tempFilename(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "users")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return filepath.Join(dir, filename), func() { os.RemoveAll(dir) }
+}
+
+// saveNew registers a user and saves the users.
+func saveNew(t *testing.T, s *Users, nickname string) {
+	if _, err := s.Register(nickname, "open sesame"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := s.SaveUsers(); err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func TestSaveBackups(t *testing.T) {
+	filename, cleanup := tempFilename(t)
+	defer cleanup()
+	s := Init(salt, filename)
+	s.Backups = 2
+	for _, nickname := range []string{"Alpha", "Beta", "Gamma", "Delta"} {
+		saveNew(t, s, nickname)
+	}
+
+	// the backups are the previous versions, newest first.
+	for i, count := range []int{3, 2} {
+		users, err := readUsers(s.backupName(i + 1))
+		if err != nil || len(users) != count {
+			t.Errorf("Backup %d should have %d users, got %d %v", i+1, count, len(users), err)
+		}
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(files) != 3 {
+		t.Errorf("Expected the users file and 2 backups, got %d files", len(files))
+	}
+}
+
+func TestRecover(t *testing.T) {
+	filename, cleanup := tempFilename(t)
+	defer cleanup()
+	s := Init(salt, filename)
+	saveNew(t, s, "Alpha")
+	saveNew(t, s, "Beta")
+	if err := ioutil.WriteFile(filename, []byte(`[{"id": "`), 0600); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	s = Init(salt, filename)
+	err := s.LoadUsers()
+	if _, ok := err.(*CorruptError); !ok {
+		t.Fatalf("Expected the file to be corrupt, got %v", err)
+	}
+	backup, err := s.Recover()
+	if err != nil || backup != filename+".1" {
+		t.Fatalf("Expected to recover from the first backup, got %s %v", backup, err)
+	}
+	if s.LookupByNickname("alpha") == nil || s.LookupByNickname("beta") != nil {
+		t.Errorf("Expected the users in the backup.")
+	}
+	if _, err = os.Stat(filename + ".corrupt"); err != nil {
+		t.Errorf("The corrupt file should have been kept: %s", err)
+	}
+	if err = Init(salt, filename).LoadUsers(); err != nil {
+		t.Errorf("The recovered users should have been saved: %s", err)
+	}
+}
+
+func TestLoadUsersForgetsTokens(t *testing.T) {
+	filename, cleanup := tempFilename(t)
+	defer cleanup()
+	s := Init(salt, filename)
+	saveNew(t, s, "Alpha")
+	token := s.LookupByNickname("alpha").Token
+	if err := s.LoadUsers(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if u := s.LookupByToken(token); u != nil {
+		t.Errorf("A token from before the users were loaded shouldn't work, got %s", u.Nickname)
+	}
+}
+
+func TestRecoverWithoutBackups(t *testing.T) {
+	filename, cleanup := tempFilename(t)
+	defer cleanup()
+	for _, contents := range []string{"", "null", `[{"nickname": "Alpha"}]`} {
+		if err := ioutil.WriteFile(filename, []byte(contents), 0600); err != nil {
+			t.Fatalf("%s", err)
+		}
+		s := Init(salt, filename)
+		if _, ok := s.LoadUsers().(*CorruptError); !ok {
+			t.Errorf("%q should be corrupt.", contents)
+		}
+		if _, err := s.Recover(); err == nil {
+			t.Errorf("There's no backup to recover from.")
+		}
+		if b, _ := ioutil.ReadFile(filename); string(b) != contents {
+			t.Errorf("The corrupt file shouldn't have been touched.")
+		}
+	}
+}
+
+func TestLock(t *testing.T) {
+	filename, cleanup := tempFilename(t)
+	defer cleanup()
+	s := Init(salt, filename)
+	if err := s.Lock(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	other := Init(salt, filename)
+	if err := other.Lock(); err != ErrLocked {
+		t.Errorf("Expected the file to be locked, got %v", err)
+	}
+	if err := s.Unlock(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := other.Lock(); err != nil {
+		t.Errorf("The file should have been unlocked: %s", err)
+	}
+	other.Unlock()
+}
+
+func TestConcurrentUsers(t *testing.T) {
+	filename, cleanup := tempFilename(t)
+	defer cleanup()
+	s := Init(salt, filename)
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u, err := s.Register(fmt.Sprintf("User %d", i), "open sesame")
+			if err != nil {
+				t.Errorf("%s", err)
+				return
+			}
+			if s.LookupByToken(u.Token) != u || s.LookupByNickname(u.Nickname) != u {
+				t.Errorf("%s should be found.", u.Nickname)
+			}
+			if err = s.SaveUsers(); err != nil {
+				t.Errorf("%s", err)
+			}
+			if _, err = s.Login(u.Nickname, "open sesame"); err != nil {
+				t.Errorf("%s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	saved := Init(salt, filename)
+	if err := saved.LoadUsers(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	for i := 0; i < n; i++ {
+		if saved.LookupByNickname(fmt.Sprintf("User %d", i)) == nil {
+			t.Errorf("User %d should have been saved.", i)
+		}
+	}
+}
@@ -7,17 +7,44 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"unicode"
 	"uuid"
 )
 
 var InvalidNicknameError = errors.New("Invalid nickname.")
 var InvalidPasswordError = errors.New("Invalid password.")
 
-// Users is a persistable collection of users.
+// ErrLocked is returned by Lock if another process has the users file
+// locked.
+var ErrLocked = errors.New("Another process is using the users file.")
+
+// CorruptError is returned when a users file can't be decoded.
+type CorruptError struct {
+	Path string
+	Err  error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("%s is corrupt: %s", e.Path, e.Err)
+}
+
+// Users is a persistable collection of users.  Policy is what Register
+// requires of new users' nicknames and passwords, and Backups is how many
+// previous versions of the users file SaveUsers keeps.  Requests use the
+// users concurrently, so mutex guards the rest of the fields, and the
+// users' Tokens, Pwhashes and Profiles; Policy and Backups are set before
+// the users are shared.
 type Users struct {
+	Policy          Policy
+	Backups         int
+	mutex           sync.RWMutex
+	lock            *os.File
 	passwordSalt    string
 	userFilename    string
 	users           []*User
@@ -26,12 +53,16 @@ type Users struct {
 	usersByToken    map[string]*User
 }
 
-// User is an individual user.
+// User is an individual user.  PwVersion is 0 if Pwhash is the hash of the
+// password with its whitespace stripped, as passwords used to be hashed,
+// and 1 if it's the hash of the password as given.
 type User struct {
-	Id       string `json:"id"`
-	Nickname string `json:"nickname"`
-	Pwhash   string `json:"pwhash"`
-	Token    string `json:"-"`
+	Id        string  `json:"id"`
+	Nickname  string  `json:"nickname"`
+	Pwhash    string  `json:"pwhash"`
+	PwVersion int     `json:"pwVersion,omitempty"`
+	Profile   Profile `json:"profile"`
+	Token     string  `json:"-"`
 }
 
 // Init creates a new Users structure.
@@ -39,6 +70,8 @@ func Init(salt string, filename string) *Users {
 	u := new(Users)
 	u.userFilename = filename
 	u.passwordSalt = salt
+	u.Policy = DefaultPolicy()
+	u.Backups = 3
 
 	u.users = make([]*User, 0)
 	u.usersByNickname = make(map[string]*User)
@@ -49,10 +82,35 @@ func Init(salt string, filename string) *Users {
 }
 
 // Register creates a new user, with the given nickname and password.  It returns
-// the user created, or an error if the nickname already exists.  The nickname
-// is canonicalized, and the password is salted and hashed.
+// the user created, or ValidationErrors if the Policy refuses the nickname or
+// password or the nickname already exists.
 func (s *Users) Register(nickname string, password string) (*User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	errs := s.Policy.ValidateNickname(nickname)
+	if len(errs) == 0 && s.lookupByNickname(nickname) != nil {
+		errs = append(errs, &ValidationError{Field: "nickname", Code: Taken,
+			Message: "That nickname is taken."})
+	}
+	errs = append(errs, s.Policy.ValidatePassword(password)...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return s.add(nickname, password)
+}
+
+// Add creates a new user without checking the Policy, for the users the
+// application creates itself.  It returns an error if the nickname already
+// exists.  The nickname is normalized, and the password is salted and
+// hashed.
+func (s *Users) Add(nickname string, password string) (*User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.add(nickname, password)
+}
 
+// add is Add, for a caller holding s.mutex.
+func (s *Users) add(nickname string, password string) (*User, error) {
 	if s.passwordSalt == "" {
 		return nil, errors.New("No password salt found.")
 	}
@@ -63,9 +121,10 @@ func (s *Users) Register(nickname string, password string) (*User, error) {
 	}
 
 	u := &User{
-		Id:       id,
-		Nickname: nickname,
-		Pwhash:   s.hashPassword(canonicalizePassword(password))}
+		Id:        id,
+		Nickname:  normalizeNickname(nickname),
+		Pwhash:    s.hashPassword(password),
+		PwVersion: 1}
 
 	u.Token, err = uuid.GenUUID()
 	if err != nil {
@@ -73,6 +132,9 @@ func (s *Users) Register(nickname string, password string) (*User, error) {
 	}
 
 	key := canonicalizeNickname(u.Nickname)
+	if key == "" {
+		return nil, InvalidNicknameError
+	}
 	if s.usersByNickname[key] != nil {
 		return nil, errors.New("Duplicate nickname.")
 	}
@@ -88,11 +150,13 @@ func (s *Users) Register(nickname string, password string) (*User, error) {
 // Login validates a user login and returns the User.  If a user is
 // logged in, he gets assigned a Token.
 func (s *Users) Login(nickname, password string) (*User, error) {
-	u := s.LookupByNickname(nickname)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u := s.lookupByNickname(nickname)
 	if u == nil {
 		return nil, InvalidNicknameError
 	}
-	if u.Pwhash != s.hashPassword(canonicalizePassword(password)) {
+	if !s.checkPassword(u, password) {
 		return nil, InvalidPasswordError
 	}
 
@@ -110,27 +174,55 @@ func (s *Users) Login(nickname, password string) (*User, error) {
 
 // LookupById returns the User with the given ID, or nil if none exists.
 func (s *Users) LookupById(id string) *User {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	return s.usersById[id]
 }
 
 // LookupByNickname returns the User with the given nickname, or nil if none exists.
 func (s *Users) LookupByNickname(nickname string) *User {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.lookupByNickname(nickname)
+}
+
+func (s *Users) lookupByNickname(nickname string) *User {
 	return s.usersByNickname[canonicalizeNickname(nickname)]
 }
 
 func (s *Users) LookupByToken(token string) *User {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	return s.usersByToken[token]
 }
 
-// canonicalizeNickname strips non-alpha-numeric characters from
-// the nickname and converts it to lower case.
+// checkPassword returns whether the password is the user's.  If the user's
+// password was hashed with its whitespace stripped, it's hashed again as
+// given, so that it's only stripped until the user next logs in.
+func (s *Users) checkPassword(u *User, password string) bool {
+	if u.PwVersion == 0 {
+		if u.Pwhash != s.hashPassword(canonicalizePassword(password)) {
+			return false
+		}
+		u.Pwhash, u.PwVersion = s.hashPassword(password), 1
+		return true
+	}
+	return u.Pwhash == s.hashPassword(password)
+}
+
+// canonicalizeNickname normalizes the nickname, strips everything but
+// letters and digits from it, and converts it to lower case.
 func canonicalizeNickname(nickname string) string {
-	re := regexp.MustCompile("[^A-Za-z0-9]*")
-	s := re.ReplaceAllLiteralString(nickname, "")
-	return strings.ToLower(s)
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, normalizeNickname(nickname))
 }
 
-// canonicalizePassword strips whitespace from the password.
+// canonicalizePassword strips whitespace from the password, as passwords
+// with a PwVersion of 0 were before they were hashed.
 func canonicalizePassword(password string) string {
 	re := regexp.MustCompile("[\\s]*")
 	s := re.ReplaceAllLiteralString(password, "")
@@ -147,74 +239,177 @@ func (s *Users) hashPassword(password string) string {
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
-// LoadUsers loads users saved in userFilename.
-func (s *Users) LoadUsers() error {
-	if s.userFilename == "" {
-		return errors.New("Must set user filename first.")
-	}
-	var err error
-	var f *os.File
-	var fi os.FileInfo
-	var b []byte
-
-	fi, err = os.Stat(s.userFilename)
+// readUsers reads the users saved in a file, returning a CorruptError if
+// they can't be decoded.
+func readUsers(filename string) ([]*User, error) {
+	b, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return parseUsers(filename, b)
+}
 
-	b = make([]byte, fi.Size())
-	f, err = os.Open(s.userFilename)
-	if err != nil {
-		return err
+// parseUsers decodes the users saved in a file.
+func parseUsers(filename string, b []byte) ([]*User, error) {
+	var users []*User
+	if err := json.Unmarshal(b, &users); err != nil {
+		return nil, &CorruptError{Path: filename, Err: err}
 	}
-	defer f.Close()
-
-	_, err = f.Read(b)
-	if err != nil {
-		return err
+	if users == nil {
+		return nil, &CorruptError{Path: filename, Err: errors.New("no users")}
 	}
-
-	err = json.Unmarshal(b, &s.users)
-	if err != nil {
-		return err
+	for i, u := range users {
+		if u == nil || u.Id == "" || canonicalizeNickname(u.Nickname) == "" {
+			return nil, &CorruptError{Path: filename, Err: fmt.Errorf("user %d is incomplete", i)}
+		}
 	}
+	return users, nil
+}
 
+// setUsers replaces the users with the ones given.  Tokens aren't saved, so
+// the new users are logged out, and the tokens of the old ones stop working.
+func (s *Users) setUsers(users []*User) {
+	s.users = users
 	s.usersByNickname = make(map[string]*User)
 	s.usersById = make(map[string]*User)
+	s.usersByToken = make(map[string]*User)
 	for _, u := range s.users {
 		s.usersByNickname[canonicalizeNickname(u.Nickname)] = u
 		s.usersById[u.Id] = u
 	}
+}
 
+// LoadUsers loads users saved in userFilename.  It returns a CorruptError
+// if the file can't be decoded; see Recover.
+func (s *Users) LoadUsers() error {
+	if s.userFilename == "" {
+		return errors.New("Must set user filename first.")
+	}
+	users, err := readUsers(s.userFilename)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.setUsers(users)
 	return nil
+}
 
+// Recover restores the users from the newest backup that can be read, after
+// LoadUsers has found userFilename corrupt, and saves them.  The corrupt
+// file is kept, with ".corrupt" added to its name.  It returns the name of
+// the backup the users were restored from.
+func (s *Users) Recover() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := 1; i <= s.Backups; i++ {
+		users, err := readUsers(s.backupName(i))
+		if err != nil {
+			continue
+		}
+		err = os.Rename(s.userFilename, s.userFilename+".corrupt")
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		s.setUsers(users)
+		return s.backupName(i), s.saveUsers()
+	}
+	return "", fmt.Errorf("None of the %d backups of %s can be read.", s.Backups, s.userFilename)
 }
 
-// SaveUsers writes the users out to userFilename.
+// SaveUsers writes the users out to userFilename.  It writes them to a
+// temporary file first, and renames it over userFilename once it's on disk,
+// so that userFilename always has every user, even if the process dies
+// while it's saving.  The previous file becomes the first backup.
 func (s *Users) SaveUsers() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.saveUsers()
+}
+
+// saveUsers is SaveUsers, for a caller holding s.mutex.
+func (s *Users) saveUsers() error {
 	if s.userFilename == "" {
 		return errors.New("Must set user filename first.")
 	}
 	if s.users == nil {
 		return errors.New("Must load or initialize users first.")
 	}
-	var b []byte
-	var f *os.File
-	var err error
-
-	b, err = json.Marshal(s.users)
+	b, err := json.Marshal(s.users)
 	if err != nil {
 		return err
 	}
-	f, err = os.Create(s.userFilename)
+	if err = s.rotateBackups(); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.userFilename, b)
+}
+
+// backupName returns the name of the ith backup; the first is the newest.
+func (s *Users) backupName(i int) string {
+	return fmt.Sprintf("%s.%d", s.userFilename, i)
+}
+
+// rotateBackups copies userFilename to the first backup, moving each
+// backup along and dropping the oldest.  A corrupt file isn't backed up, so
+// that it doesn't push out backups that can be recovered from.
+func (s *Users) rotateBackups() error {
+	if s.Backups == 0 {
+		return nil
+	}
+	b, err := ioutil.ReadFile(s.userFilename)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	if _, err = parseUsers(s.userFilename, b); err != nil {
+		return nil
+	}
+	for i := s.Backups - 1; i >= 1; i-- {
+		err = os.Rename(s.backupName(i), s.backupName(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return writeFileAtomic(s.backupName(1), b)
+}
 
+// writeFileAtomic writes b to a temporary file in filename's directory,
+// syncs it, and renames it to filename, so that filename has either its old
+// contents or b, never a mixture.
+func writeFileAtomic(filename string, b []byte) error {
+	dir := filepath.Dir(filename)
+	f, err := ioutil.TempFile(dir, filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
 	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
 	if err != nil {
+		os.Remove(f.Name())
 		return err
 	}
-	return nil
+	return syncDir(dir)
+}
+
+// Unlock releases the lock Lock took.
+func (s *Users) Unlock() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.lock == nil {
+		return nil
+	}
+	err := s.lock.Close()
+	s.lock = nil
+	return err
 }
@@ -58,7 +58,7 @@ func TestCanonicalizePassword(t *testing.T) {
 
 func TestLogin(t *testing.T) {
 	var nicknames = []string{"Alpha", "Beta", "Gamma", "Delta"}
-	var passwords = []string{"Epsilon", "Omicron", "Omega", "Upsilon"}
+	var passwords = []string{"Epsilon Eta", "Omicron Pi", "Omega Rho", "Upsilon Xi"}
 
 	s := Init(salt, filename)
 
@@ -69,7 +69,7 @@ func TestLogin(t *testing.T) {
 	var user *User
 	var err error
 
-	user, err = s.Login("..Alpha", "Epsilon")
+	user, err = s.Login("..Alpha", "Epsilon Eta")
 	if user == nil {
 		if s.LookupByToken(user.Token) != user {
 			t.Errorf("Token wasn't assigned.")
@@ -110,9 +110,9 @@ func TestLoginDoesntReassignToken(t *testing.T) {
 	var u *User
 
 	s := Init(salt, filename)
-	_, err = s.Register("foo", "bar")
+	_, err = s.Register("foo", "barbarian")
 
-	u, err = s.Login("foo", "bar")
+	u, err = s.Login("foo", "barbarian")
 	if err != nil {
 		t.Errorf("%s", err)
 		return
@@ -122,7 +122,7 @@ func TestLoginDoesntReassignToken(t *testing.T) {
 		t.Errorf("Token should have been assigned.")
 		return
 	}
-	u, err = s.Login("foo", "bar")
+	u, err = s.Login("foo", "barbarian")
 	if err != nil {
 		t.Errorf("%s", err)
 		return
@@ -135,7 +135,9 @@ func TestLoginDoesntReassignToken(t *testing.T) {
 
 func TestSaveAndLoad(t *testing.T) {
 	var nicknames = []string{"Alpha", "Beta", "Gamma", "Delta"}
-	var passwords = []string{"Epsilon", "Omicron", "Omega", "Upsilon"}
+	var passwords = []string{"Epsilon Eta", "Omicron Pi", "Omega Rho", "Upsilon Xi"}
+	filename, cleanup := tempFilename(t)
+	defer cleanup()
 
 	s := Init(salt, filename)
 
@@ -0,0 +1,436 @@
+package werks
+
+import (
+	"encoding/json"
+	"fmt"
+	"gamework"
+	"net/http"
+	"sort"
+	"strings"
+	"user"
+)
+
+// APIError is an error with the HTTP status it should be reported with, and
+// a machine-readable Code that clients can act on.  The /api/v1 handlers
+// return every error in an ErrorResponse.  If the error is in the values of
+// particular form fields, Fields says what's wrong with each.
+type APIError struct {
+	Status  int                   `json:"-"`
+	Code    string                `json:"code"`
+	Message string                `json:"message"`
+	Fields  user.ValidationErrors `json:"fields,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// ErrorResponse is the body of every /api/v1 error response.
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+var errUnknownToken = &APIError{
+	Status:  http.StatusUnauthorized,
+	Code:    "unknown_token",
+	Message: "Unknown user token."}
+
+// errUnknownGame returns the error for a game ID that isn't recognized.
+func errUnknownGame(id string) error {
+	return apiError(http.StatusNotFound, "game_not_found", "Unknown game ID: %s", id)
+}
+
+// apiError returns a new APIError.
+func apiError(status int, code string, format string, args ...interface{}) *APIError {
+	return &APIError{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// badRequest returns err as an APIError with status 400 and the given code,
+// unless it's already an APIError.
+func badRequest(code string, err error) *APIError {
+	if e, ok := err.(*APIError); ok {
+		return e
+	}
+	return apiError(http.StatusBadRequest, code, "%s", err)
+}
+
+// asAPIError returns err as an APIError.  Errors that aren't APIErrors and
+// that it doesn't recognize are internal errors.
+func asAPIError(err error) *APIError {
+	switch e := err.(type) {
+	case *APIError:
+		return e
+	case *gamework.NotYourTurnError:
+		return apiError(http.StatusForbidden, "not_your_turn", "%s", e)
+	case *gamework.UnknownOptionError:
+		return apiError(http.StatusBadRequest, "unknown_action", "%s", e)
+	case CatalogErrors:
+		return apiError(http.StatusBadRequest, "invalid_catalog", "%s", e)
+	case user.ValidationErrors:
+		a := apiError(http.StatusBadRequest, "invalid_registration", "%s", e)
+		a.Fields = e
+		return a
+	}
+	if err == ErrGameOver {
+		return apiError(http.StatusConflict, "game_over", "%s", err)
+	}
+	if err == ErrShuttingDown {
+		return apiError(http.StatusServiceUnavailable, "shutting_down", "%s", err)
+	}
+	return apiError(http.StatusInternalServerError, "internal_error", "%s", err)
+}
+
+// writeJSON writes v as the JSON body of a response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s", b)
+}
+
+// writeAPIError writes err in an ErrorResponse.
+func writeAPIError(w http.ResponseWriter, err error) {
+	e := asAPIError(err)
+	writeJSON(w, e.Status, &ErrorResponse{Error: e})
+}
+
+// apiHandler handles one method of an /api/v1 endpoint.  It returns the
+// status and body of the response; a body of nil means there's no content.
+type apiHandler func(r *http.Request) (int, interface{}, error)
+
+// apiEndpoint maps the methods an /api/v1 endpoint accepts to their
+// handlers.
+type apiEndpoint map[string]apiHandler
+
+func (e apiEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, ok := e[r.Method]
+	if !ok {
+		methods := make([]string, 0, len(e))
+		for m := range e {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		writeAPIError(w, apiError(http.StatusMethodNotAllowed, "method_not_allowed",
+			"%s isn't allowed; use %s.", r.Method, strings.Join(methods, " or ")))
+		return
+	}
+	status, body, err := handler(r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if body == nil {
+		w.WriteHeader(status)
+		return
+	}
+	writeJSON(w, status, body)
+}
+
+// apiV1 contains the /api/v1 endpoints, by path.  Games, players and
+// spectators are identified by the g, p and s parameters, as in the
+// original API.
+var apiV1 = map[string]apiEndpoint{
+	"games":      {"GET": v1ListGames, "POST": v1CreateGame},
+	"game":       {"GET": v1GetGame},
+	"players":    {"GET": v1GetPlayers},
+	"locos":      {"GET": v1GetLocos},
+	"actions":    {"GET": v1GetActions, "POST": v1PerformAction},
+	"messages":   {"GET": v1GetMessage},
+	"chat":       {"GET": v1GetChat, "POST": v1SendChat},
+	"lobby/chat": {"GET": v1GetLobbyChat, "POST": v1SendLobbyChat},
+	"spectate":   {"GET": v1GetSpectatorView, "POST": v1Spectate, "DELETE": v1StopSpectating},
+	"seat":       {"POST": v1ClaimSeat},
+	"awaiting":   {"GET": v1GetAwaiting},
+	"ledger":     {"GET": v1GetLedger},
+	"replay":     {"GET": v1Replay, "POST": v1Replay},
+	"catalogs":   {"GET": v1GetCatalogs},
+	"login":      {"POST": v1Login},
+	"register":   {"POST": v1Register},
+}
+
+// registerAPIV1 adds the /api/v1 endpoints to mux.
+func registerAPIV1(mux *http.ServeMux) {
+	for path, e := range apiV1 {
+		handleAPI(mux, "/api/v1/"+path, e)
+	}
+	mux.HandleFunc("/api/v1/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, apiError(http.StatusNotFound, "not_found",
+			"There's no endpoint %s.", r.URL.Path))
+	})
+}
+
+func v1ListGames(r *http.Request) (int, interface{}, error) {
+	return http.StatusOK, getLobbyGames(), nil
+}
+
+func v1CreateGame(r *http.Request) (int, interface{}, error) {
+	g, err := newGameFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, g, nil
+}
+
+func v1GetGame(r *http.Request) (int, interface{}, error) {
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, g.redactedFor(r.FormValue("p")), nil
+}
+
+func v1GetPlayers(r *http.Request) (int, interface{}, error) {
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, g.redactedFor(r.FormValue("p")).Players, nil
+}
+
+func v1GetLocos(r *http.Request) (int, interface{}, error) {
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, g.Locos, nil
+}
+
+// v1GetActions returns the actions available to the player, which are none
+// unless it's their turn.
+func v1GetActions(r *http.Request) (int, interface{}, error) {
+	g, p, err := getGameAndPlayerFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	actions := g.getActions()
+	if !p.IsCurrent {
+		actions.Actions = make([]Action, 0)
+	}
+	return http.StatusOK, actions, nil
+}
+
+func v1PerformAction(r *http.Request) (int, interface{}, error) {
+	g, p, err := getGameAndPlayerFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	abbr := r.FormValue("abbr")
+	if abbr == "" {
+		return 0, nil, apiError(http.StatusBadRequest, "missing_parameter", "abbr is required.")
+	}
+	if err = g.playAction(p, abbr); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, &GameState{Game: g.redactedFor(p.ID), Actions: g.getActions()}, nil
+}
+
+// v1GetMessage returns the game's next message, or the spectator's if s is
+// given; if there's none, there's no content.
+func v1GetMessage(r *http.Request) (int, interface{}, error) {
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	q := &g.Messages
+	if r.FormValue("s") != "" {
+		s, err := g.getSpectator(r.FormValue("s"))
+		if err != nil {
+			return 0, nil, err
+		}
+		q = &s.Messages
+	}
+	e := q.Pop()
+	if e == nil {
+		return http.StatusNoContent, nil, nil
+	}
+	return http.StatusOK, e.Value, nil
+}
+
+func v1GetChat(r *http.Request) (int, interface{}, error) {
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	playerID := ""
+	if r.FormValue("s") != "" {
+		if _, err = g.getSpectator(r.FormValue("s")); err != nil {
+			return 0, nil, err
+		}
+		if !g.SpectatorChat {
+			return 0, nil, apiError(http.StatusForbidden, "chat_forbidden",
+				"Spectators can't read this game's chat.")
+		}
+	} else {
+		p, err := g.getPlayer(r.FormValue("p"))
+		if err != nil {
+			return 0, nil, err
+		}
+		playerID = p.ID
+	}
+	messages, err := g.chat().since(getChatSince(r), playerID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, messages, nil
+}
+
+func v1SendChat(r *http.Request) (int, interface{}, error) {
+	if r.FormValue("s") != "" {
+		return 0, nil, apiError(http.StatusForbidden, "chat_forbidden", "Spectators can't chat.")
+	}
+	g, p, err := getGameAndPlayerFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	m, err := g.sendChat(p, r.FormValue("text"), r.FormValue("to"))
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, m, nil
+}
+
+// v1ClaimSeat links the player whose ID is p to the user whose token is u.
+func v1ClaimSeat(r *http.Request) (int, interface{}, error) {
+	u := userFromRequest(r)
+	if u == nil {
+		return 0, nil, errUnknownToken
+	}
+	g, p, err := getGameAndPlayerFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err = g.claimSeat(p, u); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, g.redactedFor(p.ID), nil
+}
+
+func v1GetLobbyChat(r *http.Request) (int, interface{}, error) {
+	messages, err := lobbyChat.since(getChatSince(r), "")
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, messages, nil
+}
+
+func v1SendLobbyChat(r *http.Request) (int, interface{}, error) {
+	u := users.LookupByToken(r.FormValue("u"))
+	if u == nil {
+		return 0, nil, errUnknownToken
+	}
+	m, err := sendLobbyChat(u.Id, u.Nickname, r.FormValue("text"))
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, m, nil
+}
+
+func v1Spectate(r *http.Request) (int, interface{}, error) {
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	userID := ""
+	if u := users.LookupByToken(r.FormValue("u")); u != nil {
+		userID = u.Id
+	}
+	s := g.addSpectator(userID)
+	return http.StatusCreated, &SpectateResponse{Spectator: s.ID, View: g.getSpectatorView()}, nil
+}
+
+func v1GetSpectatorView(r *http.Request) (int, interface{}, error) {
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err = g.getSpectator(r.FormValue("s")); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, g.getSpectatorView(), nil
+}
+
+func v1StopSpectating(r *http.Request) (int, interface{}, error) {
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err = g.getSpectator(r.FormValue("s")); err != nil {
+		return 0, nil, err
+	}
+	delete(g.Spectators, r.FormValue("s"))
+	return http.StatusNoContent, nil, nil
+}
+
+func v1GetAwaiting(r *http.Request) (int, interface{}, error) {
+	u := users.LookupByToken(r.FormValue("u"))
+	if u == nil {
+		return 0, nil, errUnknownToken
+	}
+	return http.StatusOK, gamesAwaitingMove(u.Id), nil
+}
+
+func v1GetLedger(r *http.Request) (int, interface{}, error) {
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if r.FormValue("p") != "" {
+		p, err := g.getPlayer(r.FormValue("p"))
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusOK, p.getLedger(), nil
+	}
+	ledgers := make([]PlayerLedger, len(g.Players))
+	for i, p := range g.Players {
+		ledgers[i] = p.getLedger()
+	}
+	return http.StatusOK, ledgers, nil
+}
+
+func v1Replay(r *http.Request) (int, interface{}, error) {
+	result, err := replay(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, result, nil
+}
+
+func v1GetCatalogs(r *http.Request) (int, interface{}, error) {
+	names, err := catalogNames()
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, names, nil
+}
+
+// TokenResponse is returned to a user who logs in or registers.
+type TokenResponse struct {
+	Token string `json:"token"`
+}
+
+func v1Login(r *http.Request) (int, interface{}, error) {
+	u, err := login(r, r.FormValue("u"), r.FormValue("p"))
+	if e, ok := err.(*APIError); ok {
+		return 0, nil, e
+	}
+	if err != nil {
+		return 0, nil, apiError(http.StatusUnauthorized, "invalid_login", "Invalid login.")
+	}
+	return http.StatusOK, &TokenResponse{Token: u.Token}, nil
+}
+
+func v1Register(r *http.Request) (int, interface{}, error) {
+	u, err := register(r, r.FormValue("u"), r.FormValue("p"))
+	if _, ok := err.(user.ValidationErrors); ok {
+		return 0, nil, err
+	}
+	if err != nil {
+		return 0, nil, badRequest("registration_failed", err)
+	}
+	return http.StatusCreated, &TokenResponse{Token: u.Token}, nil
+}
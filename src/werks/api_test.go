@@ -0,0 +1,306 @@
+package werks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"user"
+)
+
+// apiServer starts a test server for the /api/v1 endpoints.
+func apiServer() *httptest.Server {
+	mux := http.NewServeMux()
+	registerAPIV1(mux)
+	return httptest.NewServer(mux)
+}
+
+// call sends a request to the server, with the form in the body for a POST
+// and in the query otherwise, and decodes the response into v if it's given.
+func call(t *testing.T, s *httptest.Server, method, path string, form url.Values, v interface{}) *http.Response {
+	var r *http.Request
+	var err error
+	if method == "POST" {
+		r, err = http.NewRequest(method, s.URL+path, strings.NewReader(form.Encode()))
+		if err == nil {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		r, err = http.NewRequest(method, s.URL+path+"?"+form.Encode(), nil)
+	}
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+	}
+	return resp
+}
+
+// expectError checks that the request fails with the given status and code.
+func expectError(t *testing.T, s *httptest.Server, method, path string, form url.Values, status int, code string) {
+	var body ErrorResponse
+	resp := call(t, s, method, path, form, &body)
+	if resp.StatusCode != status {
+		t.Errorf("%s %s: expected status %d, got %d", method, path, status, resp.StatusCode)
+	}
+	if body.Error == nil || body.Error.Code != code || body.Error.Message == "" {
+		t.Errorf("%s %s: expected error %s, got %+v", method, path, code, body.Error)
+	}
+	if ct := resp.Header.Get("content-type"); ct != "application/json" {
+		t.Errorf("%s %s: expected a JSON error, got %s", method, path, ct)
+	}
+}
+
+func TestAPINotFound(t *testing.T) {
+	s := apiServer()
+	defer s.Close()
+	g := newGame()
+
+	expectError(t, s, "GET", "/api/v1/game", url.Values{"g": {"nonsense"}},
+		http.StatusNotFound, "game_not_found")
+	expectError(t, s, "GET", "/api/v1/actions", url.Values{"g": {g.ID}, "p": {"nonsense"}},
+		http.StatusNotFound, "player_not_found")
+	expectError(t, s, "GET", "/api/v1/spectate", url.Values{"g": {g.ID}, "s": {"nonsense"}},
+		http.StatusNotFound, "spectator_not_found")
+	expectError(t, s, "GET", "/api/v1/nonsense", url.Values{},
+		http.StatusNotFound, "not_found")
+}
+
+func TestAPIMethodNotAllowed(t *testing.T) {
+	s := apiServer()
+	defer s.Close()
+	g := newGame()
+
+	form := url.Values{"g": {g.ID}}
+	expectError(t, s, "POST", "/api/v1/game", form, http.StatusMethodNotAllowed, "method_not_allowed")
+	resp := call(t, s, "PUT", "/api/v1/spectate", form, nil)
+	if allow := resp.Header.Get("Allow"); allow != "DELETE, GET, POST" {
+		t.Errorf("Expected Allow: DELETE, GET, POST, got %s", allow)
+	}
+}
+
+func TestAPIActions(t *testing.T) {
+	s := apiServer()
+	defer s.Close()
+	g := newGame()
+	abel, baker := g.Players[0], g.Players[1]
+
+	var actions Actions
+	resp := call(t, s, "GET", "/api/v1/actions", url.Values{"g": {g.ID}, "p": {baker.ID}}, &actions)
+	if resp.StatusCode != http.StatusOK || len(actions.Actions) != 0 {
+		t.Errorf("Baker shouldn't have any actions, got %d %+v", resp.StatusCode, actions)
+	}
+
+	form := url.Values{"g": {g.ID}, "p": {abel.ID}}
+	expectError(t, s, "POST", "/api/v1/actions", form, http.StatusBadRequest, "missing_parameter")
+	form.Set("abbr", "nonsense")
+	expectError(t, s, "POST", "/api/v1/actions", form, http.StatusBadRequest, "unknown_action")
+	expectError(t, s, "POST", "/api/v1/actions", url.Values{"g": {g.ID}, "p": {baker.ID}, "abbr": {"P"}},
+		http.StatusForbidden, "not_your_turn")
+
+	var state GameState
+	form.Set("abbr", "P")
+	resp = call(t, s, "POST", "/api/v1/actions", form, &state)
+	if resp.StatusCode != http.StatusOK || state.Game == nil || state.Game.getCurrentPlayer().Name != "Baker" {
+		t.Errorf("Abel's pass should have been accepted, got %d", resp.StatusCode)
+	}
+
+	g.Over = true
+	form = url.Values{"g": {g.ID}, "p": {baker.ID}, "abbr": {"P"}}
+	expectError(t, s, "POST", "/api/v1/actions", form, http.StatusConflict, "game_over")
+}
+
+func TestAPICreateGame(t *testing.T) {
+	s := apiServer()
+	defer s.Close()
+
+	form := url.Values{
+		"name":        {"API game"},
+		"playerCount": {"2"},
+		"player0":     {"Abel"},
+		"player1":     {"Baker"}}
+	var g Game
+	resp := call(t, s, "POST", "/api/v1/games", form, &g)
+	if resp.StatusCode != http.StatusCreated || g.Name != "API game" || len(g.Players) != 2 {
+		t.Errorf("Expected a new game, got %d %+v", resp.StatusCode, g)
+	}
+	if _, ok := Games[g.ID]; !ok {
+		t.Errorf("The new game should have been added.")
+	}
+
+	form.Set("playerCount", "two")
+	expectError(t, s, "POST", "/api/v1/games", form, http.StatusBadRequest, "bad_request")
+	form.Set("playerCount", "1")
+	expectError(t, s, "POST", "/api/v1/games", form, http.StatusBadRequest, "invalid_config")
+	form.Set("playerCount", "2")
+	form.Set("perDecision", "soon")
+	expectError(t, s, "POST", "/api/v1/games", form, http.StatusBadRequest, "invalid_time_control")
+}
+
+func TestAPIMessages(t *testing.T) {
+	s := apiServer()
+	defer s.Close()
+	g := newGame()
+	for g.Messages.Pop() != nil {
+	}
+
+	resp := call(t, s, "GET", "/api/v1/messages", url.Values{"g": {g.ID}}, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected no content, got %d", resp.StatusCode)
+	}
+	g.addMessage("Hello")
+	var message TextMessage
+	resp = call(t, s, "GET", "/api/v1/messages", url.Values{"g": {g.ID}}, &message)
+	if resp.StatusCode != http.StatusOK || message.Text != "Hello" {
+		t.Errorf("Expected Hello, got %d %q", resp.StatusCode, message.Text)
+	}
+}
+
+func TestAPIChat(t *testing.T) {
+	_, restore := useFakeClock()
+	defer restore()
+	s := apiServer()
+	defer s.Close()
+	g := newGame()
+	abel := g.Players[0]
+
+	form := url.Values{"g": {g.ID}, "p": {abel.ID}, "text": {"Hello"}}
+	var m ChatMessage
+	resp := call(t, s, "POST", "/api/v1/chat", form, &m)
+	if resp.StatusCode != http.StatusCreated || m.Text != "Hello" || m.Who != "Abel" {
+		t.Errorf("Expected Abel's message, got %d %+v", resp.StatusCode, m)
+	}
+	form.Set("text", "/nonsense")
+	expectError(t, s, "POST", "/api/v1/chat", form, http.StatusBadRequest, "invalid_chat")
+	form.Set("text", "Hello again")
+	for i := 1; i < ChatBurst; i++ {
+		call(t, s, "POST", "/api/v1/chat", form, nil)
+	}
+	expectError(t, s, "POST", "/api/v1/chat", form, http.StatusTooManyRequests, "rate_limited")
+
+	s_id := g.addSpectator("").ID
+	expectError(t, s, "GET", "/api/v1/chat", url.Values{"g": {g.ID}, "s": {s_id}},
+		http.StatusForbidden, "chat_forbidden")
+	expectError(t, s, "POST", "/api/v1/chat", url.Values{"g": {g.ID}, "s": {s_id}, "text": {"Hi"}},
+		http.StatusForbidden, "chat_forbidden")
+}
+
+func TestAPIUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "werks")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	users = user.Init("salt", filepath.Join(dir, "users.json"))
+	defer useLimits(DefaultRateLimits())()
+	s := apiServer()
+	defer s.Close()
+
+	form := url.Values{"u": {"newcomer"}, "p": {"open sesame"}}
+	var token TokenResponse
+	resp := call(t, s, "POST", "/api/v1/register", form, &token)
+	if resp.StatusCode != http.StatusCreated || token.Token == "" {
+		t.Errorf("Expected a token, got %d %+v", resp.StatusCode, token)
+	}
+	expectError(t, s, "POST", "/api/v1/register", form, http.StatusBadRequest, "invalid_registration")
+	var body ErrorResponse
+	call(t, s, "POST", "/api/v1/register", url.Values{"u": {"x"}, "p": {"short"}}, &body)
+	if body.Error == nil || len(body.Error.Fields) != 2 ||
+		body.Error.Fields[0].Field != "nickname" || body.Error.Fields[1].Code != user.TooShort {
+		t.Errorf("Expected errors for each field, got %+v", body.Error)
+	}
+
+	resp = call(t, s, "POST", "/api/v1/login", form, &token)
+	if resp.StatusCode != http.StatusOK || token.Token == "" {
+		t.Errorf("Expected a token, got %d %+v", resp.StatusCode, token)
+	}
+	form.Set("p", "wrong")
+	expectError(t, s, "POST", "/api/v1/login", form, http.StatusUnauthorized, "invalid_login")
+	expectError(t, s, "GET", "/api/v1/awaiting", url.Values{"u": {"nonsense"}},
+		http.StatusUnauthorized, "unknown_token")
+}
+
+func TestRequestsHoldTheGamesLock(t *testing.T) {
+	g := newGame()
+	mux := http.NewServeMux()
+	registerHandlers(mux, nil)
+	request := func(path string) chan bool {
+		done := make(chan bool)
+		go func() {
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+			close(done)
+		}()
+		return done
+	}
+
+	gamesMutex.Lock()
+	waiting := []chan bool{
+		request("/api/v1/game?g=" + g.ID),
+		request("/api/v1/locos?g=" + g.ID),
+		request("/api/locos?g=" + g.ID)}
+	select {
+	case <-request("/api/v1/catalogs"):
+	case <-time.After(time.Second):
+		t.Errorf("Gameless requests shouldn't wait for the games.")
+	}
+	for _, done := range waiting {
+		select {
+		case <-done:
+			t.Errorf("Requests for a game should wait for the games.")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	gamesMutex.Unlock()
+	for _, done := range waiting {
+		<-done
+	}
+}
+
+func TestAPIPlayerIDsStaySecret(t *testing.T) {
+	s := apiServer()
+	defer s.Close()
+	users = user.Init("salt", "")
+	g := newGame()
+	abel := g.Players[0]
+	g.sendChat(abel, "hello", "")
+	g.sendChat(abel, "psst", g.Players[1].Name)
+	leaks := func(path string, form url.Values, allowed string) {
+		var b json.RawMessage
+		call(t, s, "GET", path, form, &b)
+		for _, p := range g.Players {
+			if p.ID != allowed && strings.Contains(string(b), p.ID) {
+				t.Errorf("%s?%s shouldn't reveal %s's ID: %s", path, form.Encode(), p.Name, b)
+			}
+		}
+		if allowed != "" && !strings.Contains(string(b), allowed) {
+			t.Errorf("%s?%s should tell the player their own ID: %s", path, form.Encode(), b)
+		}
+	}
+	leaks("/api/v1/games", url.Values{}, "")
+	leaks("/api/v1/game", url.Values{"g": {g.ID}}, "")
+	leaks("/api/v1/game", url.Values{"g": {g.ID}, "p": {abel.ID}}, abel.ID)
+	leaks("/api/v1/players", url.Values{"g": {g.ID}}, "")
+	leaks("/api/v1/players", url.Values{"g": {g.ID}, "p": {abel.ID}}, abel.ID)
+	leaks("/api/v1/ledger", url.Values{"g": {g.ID}}, "")
+	leaks("/api/v1/chat", url.Values{"g": {g.ID}, "p": {abel.ID}}, "")
+
+	var joined struct {
+		Spectator string `json:"spectator"`
+	}
+	call(t, s, "POST", "/api/v1/spectate", url.Values{"g": {g.ID}}, &joined)
+	leaks("/api/v1/spectate", url.Values{"g": {g.ID}, "s": {joined.Spectator}}, "")
+}
@@ -0,0 +1,51 @@
+package werks
+
+import (
+	"net/http"
+	"user"
+)
+
+// login logs in the user with the given nickname and password, recording
+// the attempt in the audit log.  It refuses to try if the request's IP
+// address or the nickname is over its Limits.
+func login(r *http.Request, nickname, password string) (*user.User, error) {
+	if err := checkLogin(r, nickname); err != nil {
+		return nil, err
+	}
+	u, err := users.Login(nickname, password)
+	recordLogin(r, nickname, err == nil)
+	if err != nil {
+		countLoginFailure()
+		audit(r, AuditEvent{Kind: AuditLoginFailed, Nickname: nickname, Detail: err.Error()})
+		return nil, err
+	}
+	audit(r, AuditEvent{Kind: AuditLogin, UserID: u.Id, Nickname: u.Nickname})
+	return u, nil
+}
+
+// register registers a new user and saves the users file, recording the
+// attempt in the audit log.  It refuses to try if the request's IP address
+// is over its Limits.
+func register(r *http.Request, nickname, password string) (*user.User, error) {
+	if err := checkRegistration(r); err != nil {
+		return nil, err
+	}
+	u, err := users.Register(nickname, password)
+	if err == nil {
+		err = users.SaveUsers()
+	}
+	if err != nil {
+		audit(r, AuditEvent{Kind: AuditRegisterFailed, Nickname: nickname, Detail: err.Error()})
+		return nil, err
+	}
+	audit(r, AuditEvent{Kind: AuditRegister, UserID: u.Id, Nickname: u.Nickname})
+	return u, nil
+}
+
+// userFromRequest returns the user whose token is the u form value, or nil.
+func userFromRequest(r *http.Request) *user.User {
+	if users == nil {
+		return nil
+	}
+	return users.LookupByToken(r.FormValue("u"))
+}
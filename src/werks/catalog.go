@@ -0,0 +1,293 @@
+package werks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MaxDice is the most dice that can be placed in a row on a Loco's card.
+const MaxDice = 5
+
+// DefaultKinds maps each kind of Loco to the prefix of its keys, for
+// catalogs that don't define their own kinds.
+var DefaultKinds = map[string]string{
+	"passenger": "p",
+	"fast":      "a",
+	"freight":   "g",
+	"special":   "s",
+}
+
+// Catalog is a set of Locos that a game can be played with.  Kinds maps
+// each kind of Loco to the prefix of its keys.
+//
+// A catalog file is either a JSON array of Locos, which uses DefaultKinds,
+// or a JSON object with "kinds" and "locos" members, which lets variants
+// and expansions introduce kinds of their own.
+type Catalog struct {
+	Kinds map[string]string
+	Locos []Loco
+}
+
+// CatalogError describes a problem with a catalog file.  Line is the line of
+// the file on which the problem was found, and Entry is the index of the
+// Loco it concerns, or -1 if it doesn't concern a Loco.
+type CatalogError struct {
+	Path  string
+	Line  int
+	Entry int
+	Msg   string
+}
+
+func (e *CatalogError) Error() string {
+	if e.Entry < 0 {
+		return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: loco %d: %s", e.Path, e.Line, e.Entry, e.Msg)
+}
+
+// CatalogErrors is every problem found with a catalog file.
+type CatalogErrors []*CatalogError
+
+func (errs CatalogErrors) Error() string {
+	s := make([]string, len(errs))
+	for i, e := range errs {
+		s[i] = e.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// catalogParser keeps track of where it is in a catalog file, so that it
+// can report errors with their positions.
+type catalogParser struct {
+	path  string
+	b     []byte
+	dec   *json.Decoder
+	lines []int // the line on which each Loco starts
+	errs  CatalogErrors
+}
+
+// lineAt returns the line of the first value at or after offset.
+func (p *catalogParser) lineAt(offset int64) int {
+	i := int(offset)
+	for i < len(p.b) && strings.ContainsRune(" \t\r\n,:", rune(p.b[i])) {
+		i++
+	}
+	return bytes.Count(p.b[:i], []byte("\n")) + 1
+}
+
+func (p *catalogParser) errorf(line int, entry int, format string, args ...interface{}) {
+	p.errs = append(p.errs, &CatalogError{
+		Path:  p.path,
+		Line:  line,
+		Entry: entry,
+		Msg:   fmt.Sprintf(format, args...)})
+}
+
+// syntaxError records an error that stops the parser.
+func (p *catalogParser) syntaxError(err error) {
+	line := p.lineAt(p.dec.InputOffset())
+	if se, ok := err.(*json.SyntaxError); ok && se.Offset > 0 {
+		// the offending character is the last one read.
+		line = bytes.Count(p.b[:se.Offset-1], []byte("\n")) + 1
+	}
+	p.errorf(line, -1, "%s", err)
+}
+
+// expect reads the next token, which should be delim.
+func (p *catalogParser) expect(delim json.Delim) bool {
+	tok, err := p.dec.Token()
+	if err != nil {
+		p.syntaxError(err)
+		return false
+	}
+	if tok != delim {
+		p.errorf(p.lineAt(p.dec.InputOffset()), -1, "expected %s, found %v", delim, tok)
+		return false
+	}
+	return true
+}
+
+// parseLocos reads an array of Locos.
+func (p *catalogParser) parseLocos() []Loco {
+	locos := make([]Loco, 0)
+	if !p.expect('[') {
+		return locos
+	}
+	for p.dec.More() {
+		line := p.lineAt(p.dec.InputOffset())
+		var loco Loco
+		err := p.dec.Decode(&loco)
+		if _, ok := err.(*json.SyntaxError); ok {
+			p.syntaxError(err)
+			return locos
+		}
+		if err != nil {
+			p.errorf(line, len(locos), "%s", err)
+		}
+		locos = append(locos, loco)
+		p.lines = append(p.lines, line)
+	}
+	p.expect(']')
+	return locos
+}
+
+// parse reads the catalog file.
+func (p *catalogParser) parse() *Catalog {
+	c := &Catalog{Kinds: DefaultKinds}
+	p.dec = json.NewDecoder(bytes.NewReader(p.b))
+	p.dec.DisallowUnknownFields()
+
+	if i := bytes.IndexAny(p.b, "[{"); i < 0 || p.b[i] == '[' {
+		c.Locos = p.parseLocos()
+		return c
+	}
+
+	if !p.expect('{') {
+		return c
+	}
+	for p.dec.More() {
+		line := p.lineAt(p.dec.InputOffset())
+		tok, err := p.dec.Token()
+		if err != nil {
+			p.syntaxError(err)
+			return c
+		}
+		switch tok {
+		case "kinds":
+			if err = p.dec.Decode(&c.Kinds); err != nil {
+				p.syntaxError(err)
+				return c
+			}
+		case "locos":
+			c.Locos = p.parseLocos()
+		default:
+			p.errorf(line, -1, "unknown member %v", tok)
+			var skip interface{}
+			if err = p.dec.Decode(&skip); err != nil {
+				p.syntaxError(err)
+				return c
+			}
+		}
+	}
+	p.expect('}')
+	return c
+}
+
+// validate checks the Locos in the catalog:  every kind must be known, no
+// two Locos may have the same kind and generation, the generations of each
+// kind must run from 1 without gaps, costs must be positive and no row of
+// dice may be longer than MaxDice.  There must be at least one Loco.
+func (p *catalogParser) validate(c *Catalog) {
+	if len(c.Locos) == 0 && len(p.errs) == 0 {
+		p.errorf(1, -1, "a catalog needs at least 1 loco, found 0")
+	}
+
+	type kindGen struct {
+		kind string
+		gen  int
+	}
+	seen := make(map[kindGen]int)
+	for i, loco := range c.Locos {
+		if _, ok := seen[kindGen{loco.Kind, loco.Generation}]; !ok {
+			seen[kindGen{loco.Kind, loco.Generation}] = i
+		}
+	}
+
+	for i, loco := range c.Locos {
+		line := p.lines[i]
+		if _, ok := c.Kinds[loco.Kind]; !ok {
+			p.errorf(line, i, "unknown kind %q", loco.Kind)
+		}
+		if loco.Generation < 1 {
+			p.errorf(line, i, "generation must be at least 1, is %d", loco.Generation)
+		}
+		if first := seen[kindGen{loco.Kind, loco.Generation}]; first != i {
+			p.errorf(line, i, "duplicate %s generation %d (first at line %d)",
+				loco.Kind, loco.Generation, p.lines[first])
+		} else if _, ok := seen[kindGen{loco.Kind, loco.Generation - 1}]; !ok && loco.Generation > 1 {
+			p.errorf(line, i, "%s generation %d has no generation %d",
+				loco.Kind, loco.Generation, loco.Generation-1)
+		}
+		if loco.DevelopmentCost <= 0 {
+			p.errorf(line, i, "developmentCost must be positive, is %d", loco.DevelopmentCost)
+		}
+		if loco.ProductionCost <= 0 {
+			p.errorf(line, i, "productionCost must be positive, is %d", loco.ProductionCost)
+		}
+		if loco.MaxExistingOrders < 0 || loco.MaxExistingOrders > MaxDice {
+			p.errorf(line, i, "maxExistingOrders must be between 0 and %d, is %d",
+				MaxDice, loco.MaxExistingOrders)
+		}
+		if loco.MaxCustomerBase < 0 || loco.MaxCustomerBase > MaxDice {
+			p.errorf(line, i, "maxCustomerBase must be between 0 and %d, is %d",
+				MaxDice, loco.MaxCustomerBase)
+		}
+	}
+}
+
+// LoadCatalog reads and validates the catalog file at path, and assigns each
+// Loco its key.  If the file has any problems, the error is a CatalogErrors
+// listing all of them.
+func LoadCatalog(path string) (*Catalog, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &catalogParser{path: path, b: b}
+	c := p.parse()
+	p.validate(c)
+	if len(p.errs) > 0 {
+		return nil, p.errs
+	}
+	for i, loco := range c.Locos {
+		c.Locos[i].Key = fmt.Sprintf("%s%d", c.Kinds[loco.Kind], loco.Generation)
+	}
+	return c, nil
+}
+
+var catalogNameRe = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+// DefaultCatalog is the name of the catalog games use unless they're told
+// otherwise.
+const DefaultCatalog = "locos"
+
+// catalogPath returns the path of the named catalog, which lives alongside
+// the default catalog at LocosJsonPath.
+func catalogPath(name string) (string, error) {
+	if name == "" || name == DefaultCatalog {
+		return filepath.Join(rootPath, LocosJsonPath), nil
+	}
+	if !catalogNameRe.MatchString(name) {
+		return "", errors.New("Invalid catalog name: " + name)
+	}
+	dir := filepath.Dir(filepath.Join(rootPath, LocosJsonPath))
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// catalogNames returns the names of the catalogs that games can choose.
+func catalogNames() ([]string, error) {
+	path, err := catalogPath(DefaultCatalog)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), ".json")
+		if f.Mode().IsRegular() && name != f.Name() && catalogNameRe.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
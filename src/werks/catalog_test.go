@@ -0,0 +1,173 @@
+package werks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCatalog writes a catalog file into a temporary directory, and returns
+// its path.
+func writeCatalog(t *testing.T, dir string, name string, contents string) string {
+	path := filepath.Join(dir, name+".json")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return path
+}
+
+// loco returns the JSON for a valid Loco, with the given fields changed.
+func loco(kind string, generation string, changes ...string) string {
+	fields := map[string]string{
+		"kind":              `"` + kind + `"`,
+		"generation":        generation,
+		"name":              `"Test"`,
+		"developmentCost":   "4",
+		"productionCost":    "2",
+		"maxExistingOrders": "3",
+		"maxCustomerBase":   "3",
+	}
+	for i := 0; i+1 < len(changes); i += 2 {
+		fields[changes[i]] = changes[i+1]
+	}
+	s := make([]string, 0, len(fields))
+	for _, k := range []string{"kind", "generation", "name", "developmentCost",
+		"productionCost", "maxExistingOrders", "maxCustomerBase", "typo"} {
+		if v, ok := fields[k]; ok {
+			s = append(s, `"`+k+`": `+v)
+		}
+	}
+	return "{" + strings.Join(s, ", ") + "}"
+}
+
+func TestDefaultCatalogIsValid(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	path, err := catalogPath("")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	c, err := LoadCatalog(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if c.Locos[0].Key != "p1" || c.Locos[1].Key != "a1" {
+		t.Errorf("Unexpected keys %s, %s", c.Locos[0].Key, c.Locos[1].Key)
+	}
+}
+
+func TestCatalogValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	entries := []string{
+		loco("passenger", "1"),
+		loco("passenger", "1"),
+		loco("fast", "2"),
+		loco("freight", "1", "developmentCost", "0", "productionCost", "-1"),
+		loco("special", "1", "maxExistingOrders", "6", "maxCustomerBase", "-1"),
+		loco("steam", "1"),
+		loco("freight", "2", "typo", "1"),
+	}
+	path := writeCatalog(t, dir, "bad", "[\n"+strings.Join(entries, ",\n")+"\n]\n")
+
+	_, err = LoadCatalog(path)
+	errs, ok := err.(CatalogErrors)
+	if !ok {
+		t.Fatalf("Expected CatalogErrors, got %v", err)
+	}
+
+	expected := []struct {
+		line  int
+		entry int
+		msg   string
+	}{
+		{8, 6, "unknown field"},
+		{3, 1, "duplicate passenger generation 1 (first at line 2)"},
+		{4, 2, "fast generation 2 has no generation 1"},
+		{5, 3, "developmentCost must be positive"},
+		{5, 3, "productionCost must be positive"},
+		{6, 4, "maxExistingOrders must be between 0 and 5"},
+		{6, 4, "maxCustomerBase must be between 0 and 5"},
+		{7, 5, `unknown kind "steam"`},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d:\n%s", len(expected), len(errs), errs)
+	}
+	for i, e := range expected {
+		actual := errs[i]
+		if actual.Line != e.line || actual.Entry != e.entry || !strings.Contains(actual.Msg, e.msg) {
+			t.Errorf("Expected line %d, loco %d: %s; got %s", e.line, e.entry, e.msg, actual)
+		}
+	}
+}
+
+func TestCatalogSyntaxError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeCatalog(t, dir, "broken", "[\n"+loco("passenger", "1")+",\n{\"kind\": }\n]")
+	_, err = LoadCatalog(path)
+	errs, ok := err.(CatalogErrors)
+	if !ok || len(errs) == 0 {
+		t.Fatalf("Expected CatalogErrors, got %v", err)
+	}
+	if errs[0].Line != 3 {
+		t.Errorf("Expected the syntax error on line 3, got %s", errs[0])
+	}
+}
+
+func TestAlternativeCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldPath := LocosJsonPath
+	LocosJsonPath = filepath.Join(dir, "locos.json")
+	defer func() { LocosJsonPath = oldPath }()
+
+	writeCatalog(t, dir, "locos", "["+loco("passenger", "1")+","+loco("fast", "1")+"]")
+	writeCatalog(t, dir, "electric", `{
+	"kinds": {"tram": "t", "mainline": "e"},
+	"locos": [`+loco("tram", "1")+","+loco("mainline", "1")+","+loco("tram", "2")+`]
+}`)
+
+	names, err := catalogNames()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if strings.Join(names, ",") != "electric,locos" {
+		t.Errorf("Unexpected catalogs: %v", names)
+	}
+
+	config := DefaultGameConfig()
+	config.Catalog = "electric"
+	config.StartingFactories = []string{"t1"}
+	config.InitialOrders = []string{"e1"}
+	config.ExistingOrders = map[string]int{"t1": 3}
+	g, err := makeNewGame("electric", []string{"Abel", "Baker"}, config)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if g.Config.Catalog != "electric" || g.LocoMap["t2"] == nil || g.LocoMap["t1"].UpgradeTo != "t2" {
+		t.Errorf("The game should use the electric catalog.")
+	}
+
+	config.Catalog = "../locos"
+	if _, err = makeNewGame("bad", []string{"Abel", "Baker"}, config); err == nil {
+		t.Errorf("Catalog names shouldn't be able to escape the catalog directory.")
+	}
+	config.Catalog = "missing"
+	if _, err = makeNewGame("missing", []string{"Abel", "Baker"}, config); err == nil {
+		t.Errorf("A missing catalog should be an error, not a panic.")
+	}
+}
@@ -0,0 +1,322 @@
+package werks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxChatLength is the longest chat message, in characters, that's accepted.
+var MaxChatLength = 500
+
+// Each sender can send at most ChatBurst messages in any ChatWindow.
+var ChatBurst = 5
+var ChatWindow = 10 * time.Second
+
+// ChatDir is the directory chat histories are kept in, one JSON object per
+// line.  If it's empty, they're only kept in memory.
+var ChatDir = ""
+
+// The kinds of ChatMessage.
+const (
+	ChatSay     = "say"
+	ChatWhisper = "whisper"
+	ChatEmote   = "emote"
+	ChatRoll    = "roll"
+	ChatSystem  = "system"
+)
+
+// ChatMessage is one message in a chat history.  ID counts up from 1 within
+// the history.  from is the ID of the player who sent it, and is empty for
+// messages in the lobby.  A message is private if to is set, in which case
+// only the sender and the player with that ID can see it.  Player IDs are
+// secret, so they're only kept in the history (see chatRecord); clients see
+// names.
+type ChatMessage struct {
+	ID     int       `json:"id"`
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	UserID string    `json:"userId"`
+	Who    string    `json:"who"`
+	ToName string    `json:"toName,omitempty"`
+	Text   string    `json:"text"`
+	from   string
+	to     string
+}
+
+// chatRecord is a ChatMessage as it's kept in ChatDir.
+type chatRecord struct {
+	ChatMessage
+	From string `json:"playerId,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// visibleTo returns whether the player with the given ID can see the
+// message; spectators and the lobby have an empty ID, and only see public
+// messages.
+func (m *ChatMessage) visibleTo(playerID string) bool {
+	return m.to == "" || (playerID != "" && (m.to == playerID || m.from == playerID))
+}
+
+// ChatLog is a chat history, for a game or the lobby.  The lobby's history
+// is shared by every request, so mutex guards the rest of the fields.
+type ChatLog struct {
+	Name     string
+	Messages []ChatMessage
+	loaded   bool
+	sent     map[string][]time.Time // recent messages by sender, for flood control
+	mutex    sync.Mutex
+}
+
+var lobbyChat = &ChatLog{Name: "lobby"}
+
+// chat returns the game's chat history.
+func (g *Game) chat() *ChatLog {
+	if g.Chat == nil {
+		g.Chat = &ChatLog{Name: "game-" + g.ID}
+	}
+	return g.Chat
+}
+
+func (c *ChatLog) path() string {
+	return filepath.Join(ChatDir, c.Name+".jsonl")
+}
+
+// load reads the history from ChatDir, the first time it's needed.  The
+// caller must hold c.mutex.
+func (c *ChatLog) load() error {
+	if c.loaded || ChatDir == "" {
+		return nil
+	}
+	c.loaded = true
+	f, err := os.Open(c.path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r chatRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return err
+		}
+		m := r.ChatMessage
+		m.from, m.to = r.From, r.To
+		c.Messages = append(c.Messages, m)
+	}
+	return scanner.Err()
+}
+
+// add appends m to the history, unless its sender is flooding the chat.
+func (c *ChatLog) add(m ChatMessage, sender string) (*ChatMessage, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	now := clock.Now()
+	if c.sent == nil {
+		c.sent = make(map[string][]time.Time)
+	}
+	recent := make([]time.Time, 0, ChatBurst)
+	for _, t := range c.sent[sender] {
+		if now.Sub(t) < ChatWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= ChatBurst {
+		return nil, apiError(http.StatusTooManyRequests, "rate_limited",
+			"You're sending messages too quickly.")
+	}
+	c.sent[sender] = append(recent, now)
+
+	m.ID = len(c.Messages) + 1
+	m.Time = now
+	c.Messages = append(c.Messages, m)
+	if ChatDir == "" {
+		return &m, nil
+	}
+	b, err := json.Marshal(chatRecord{ChatMessage: m, From: m.from, To: m.to})
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(c.path(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s\n", b)
+	return &m, err
+}
+
+// since returns the messages after the one with the given ID that the
+// player with the given ID can see.
+func (c *ChatLog) since(id int, playerID string) ([]ChatMessage, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	result := make([]ChatMessage, 0)
+	for _, m := range c.Messages {
+		if m.ID > id && m.visibleTo(playerID) {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
+
+// count returns the number of messages in the history.
+func (c *ChatLog) count() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.Messages)
+}
+
+// chatError returns an error for a chat message that can't be sent.
+func chatError(format string, args ...interface{}) error {
+	return apiError(http.StatusBadRequest, "invalid_chat", format, args...)
+}
+
+// parseChat fills in m from the text that was sent, which may be one of
+// these commands:
+//
+//	/me <action>           an emote
+//	/roll [<n>d<sides>]    roll dice (1d6 by default)
+//	/w <player> <message>  whisper to a player (also /whisper)
+//	/status                get the state of the game, privately
+//
+// g is nil in the lobby, where only /me and /roll are available.
+func parseChat(m *ChatMessage, text string, g *Game) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return chatError("Chat messages can't be empty.")
+	}
+	if utf8.RuneCountInString(text) > MaxChatLength {
+		return chatError("Chat messages can't be longer than %d characters.", MaxChatLength)
+	}
+	if !strings.HasPrefix(text, "/") {
+		m.Text = text
+		return nil
+	}
+
+	fields := strings.SplitN(text, " ", 2)
+	cmd, rest := fields[0], ""
+	if len(fields) > 1 {
+		rest = strings.TrimSpace(fields[1])
+	}
+	switch {
+	case cmd == "/me" && rest != "":
+		m.Kind = ChatEmote
+		m.Text = rest
+	case cmd == "/roll":
+		s, err := rollChatDice(rest)
+		if err != nil {
+			return err
+		}
+		m.Kind = ChatRoll
+		m.Text = s
+	case (cmd == "/w" || cmd == "/whisper") && g != nil:
+		fields = strings.SplitN(rest, " ", 2)
+		if len(fields) < 2 {
+			return chatError("Usage: /w <player> <message>")
+		}
+		to := g.getPlayerByName(fields[0])
+		if to == nil {
+			return chatError("There's no player called %s.", fields[0])
+		}
+		m.Kind = ChatWhisper
+		m.to = to.ID
+		m.ToName = to.Name
+		m.Text = strings.TrimSpace(fields[1])
+	case cmd == "/status" && g != nil:
+		m.Kind = ChatSystem
+		m.to = m.from
+		m.ToName = m.Who
+		m.Text = g.status()
+	default:
+		return chatError("Unknown command: %s", cmd)
+	}
+	return nil
+}
+
+// rollChatDice rolls dice described like "2d6", and describes the result.
+// The game's own random number generator isn't used, so that chat can't
+// change how the game plays out.
+func rollChatDice(s string) (string, error) {
+	n, sides := 1, 6
+	if s != "" {
+		fields := strings.SplitN(strings.ToLower(s), "d", 2)
+		var err1, err2 error
+		n, err1 = strconv.Atoi(fields[0])
+		if len(fields) == 2 {
+			sides, err2 = strconv.Atoi(fields[1])
+		}
+		if err1 != nil || err2 != nil || n < 1 || n > 10 || sides < 2 || sides > 100 {
+			return "", chatError("Usage: /roll [<n>d<sides>], with up to 10 dice of up to 100 sides.")
+		}
+	}
+	rolls := make([]string, n)
+	total := 0
+	for i := range rolls {
+		r := rand.Intn(sides) + 1
+		rolls[i] = strconv.Itoa(r)
+		total += r
+	}
+	return fmt.Sprintf("rolled %dd%d: %s (%d)", n, sides, strings.Join(rolls, ", "), total), nil
+}
+
+// status describes the state of the game.
+func (g *Game) status() string {
+	money := make([]string, len(g.Players))
+	for i, p := range g.Players {
+		money[i] = fmt.Sprintf("%s %d", p.Name, p.Money)
+	}
+	state := fmt.Sprintf("%s to play", g.getCurrentPlayer().Name)
+	if g.Over {
+		state = "game over"
+	}
+	return fmt.Sprintf("Turn %d, %s, %s. Money: %s.",
+		g.Turn, Phases[g.Phase-1], state, strings.Join(money, ", "))
+}
+
+// sendChat sends text from player p to the game's chat.  If to is set, it's
+// the name of the player to whisper to.
+func (g *Game) sendChat(p *Player, text string, to string) (*ChatMessage, error) {
+	m := ChatMessage{Kind: ChatSay, UserID: p.UserID, Who: p.Name, from: p.ID}
+	if err := parseChat(&m, text, g); err != nil {
+		return nil, err
+	}
+	if to != "" && m.Kind == ChatSay {
+		target := g.getPlayerByName(to)
+		if target == nil {
+			return nil, chatError("There's no player called %s.", to)
+		}
+		m.Kind = ChatWhisper
+		m.to = target.ID
+		m.ToName = target.Name
+	}
+	return g.chat().add(m, p.ID)
+}
+
+// sendLobbyChat sends text from the user with the given ID and nickname to
+// the lobby's chat.
+func sendLobbyChat(userID string, name string, text string) (*ChatMessage, error) {
+	m := ChatMessage{Kind: ChatSay, UserID: userID, Who: name}
+	if err := parseChat(&m, text, nil); err != nil {
+		return nil, err
+	}
+	return lobbyChat.add(m, userID)
+}
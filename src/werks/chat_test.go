@@ -0,0 +1,254 @@
+package werks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"user"
+)
+
+// chatSince returns the chat messages player p can see after the given ID.
+func chatSince(t *testing.T, g *Game, p *Player, since int) []ChatMessage {
+	messages, err := g.chat().since(since, p.ID)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return messages
+}
+
+func TestChatHistory(t *testing.T) {
+	_, restore := useFakeClock()
+	defer restore()
+	g := newGame()
+	abel, baker := g.Players[0], g.Players[1]
+
+	for _, text := range []string{"one", "two", "three"} {
+		if _, err := g.sendChat(abel, text, ""); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	messages := chatSince(t, g, baker, 0)
+	if len(messages) != 3 || messages[0].Who != "Abel" || messages[0].from != abel.ID {
+		t.Fatalf("Unexpected history: %+v", messages)
+	}
+	if messages[0].Time.IsZero() {
+		t.Errorf("Chat messages should be timestamped.")
+	}
+	messages = chatSince(t, g, baker, 2)
+	if len(messages) != 1 || messages[0].Text != "three" {
+		t.Errorf("Expected only the messages after 2, got %+v", messages)
+	}
+}
+
+func TestWhispers(t *testing.T) {
+	g := newGame()
+	abel, baker, charlie := g.Players[0], g.Players[1], g.Players[2]
+
+	if _, err := g.sendChat(abel, "psst", baker.Name); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := g.sendChat(abel, "psst", baker.ID); err == nil {
+		t.Errorf("Whispers should be addressed by name.")
+	}
+	if _, err := g.sendChat(abel, "/w charlie hello there", ""); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := g.sendChat(abel, "/w nobody hello", ""); err == nil {
+		t.Errorf("Whispering to an unknown player should be an error.")
+	}
+
+	tests := []struct {
+		p        *Player
+		expected string
+	}{
+		{abel, "psst,hello there"},
+		{baker, "psst"},
+		{charlie, "hello there"},
+	}
+	for _, test := range tests {
+		messages := chatSince(t, g, test.p, 0)
+		texts := make([]string, len(messages))
+		for i, m := range messages {
+			texts[i] = m.Text
+		}
+		if strings.Join(texts, ",") != test.expected {
+			t.Errorf("%s should see %s, got %v", test.p.Name, test.expected, texts)
+		}
+	}
+}
+
+func TestChatCommands(t *testing.T) {
+	g := newGame()
+	abel, baker := g.Players[0], g.Players[1]
+
+	m, err := g.sendChat(abel, "/me waves", "")
+	if err != nil || m.Kind != ChatEmote || m.Text != "waves" {
+		t.Errorf("Unexpected emote: %+v, %v", m, err)
+	}
+	m, err = g.sendChat(abel, "/roll 3d6", "")
+	if err != nil || m.Kind != ChatRoll || !strings.HasPrefix(m.Text, "rolled 3d6: ") {
+		t.Errorf("Unexpected roll: %+v, %v", m, err)
+	}
+	m, err = g.sendChat(abel, "/status", "")
+	if err != nil || m.Kind != ChatSystem || m.to != abel.ID ||
+		!strings.Contains(m.Text, "Turn 1, Locomotive Development, Abel to play") {
+		t.Errorf("Unexpected status: %+v, %v", m, err)
+	}
+	if messages := chatSince(t, g, baker, 0); len(messages) != 2 {
+		t.Errorf("The status should be private, but Baker sees %+v", messages)
+	}
+
+	for _, bad := range []string{"/roll 99d6", "/roll lots", "/dance", "/me", "   "} {
+		if _, err := g.sendChat(abel, bad, ""); err == nil {
+			t.Errorf("%q should be rejected.", bad)
+		}
+	}
+}
+
+func TestChatLimits(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+	g := newGame()
+	abel, baker := g.Players[0], g.Players[1]
+
+	if _, err := g.sendChat(abel, strings.Repeat("x", MaxChatLength+1), ""); err == nil {
+		t.Errorf("Overlong messages should be rejected.")
+	}
+	for i := 0; i < ChatBurst; i++ {
+		if _, err := g.sendChat(abel, "spam", ""); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if _, err := g.sendChat(abel, "spam", ""); err == nil {
+		t.Errorf("Flooding should be rejected.")
+	}
+	if _, err := g.sendChat(baker, "hi", ""); err != nil {
+		t.Errorf("Other players shouldn't be limited: %s", err)
+	}
+	c.Advance(ChatWindow)
+	if _, err := g.sendChat(abel, "spam", ""); err != nil {
+		t.Errorf("The limit should lift after %s: %s", ChatWindow, err)
+	}
+}
+
+func TestChatPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chat")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	ChatDir = dir
+	defer func() { ChatDir = "" }()
+
+	g := newGame()
+	if _, err := g.sendChat(g.Players[0], "remember me", ""); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := g.sendChat(g.Players[0], "just us", g.Players[2].Name); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// a fresh ChatLog, as after a restart, reads the history back.
+	g.Chat = nil
+	messages := chatSince(t, g, g.Players[1], 0)
+	if len(messages) != 1 || messages[0].Text != "remember me" {
+		t.Errorf("The chat history, but not the whisper, should survive a restart: %+v", messages)
+	}
+	if messages = chatSince(t, g, g.Players[2], 0); len(messages) != 2 {
+		t.Errorf("The whisper should survive a restart: %+v", messages)
+	}
+	if m, err := g.sendChat(g.Players[1], "me too", ""); err != nil || m.ID != 3 {
+		t.Errorf("New messages should follow the history: %+v, %v", m, err)
+	}
+}
+
+func TestChatApi(t *testing.T) {
+	g := newGame()
+	p := g.Players[0]
+	base := "/api/chat?g=" + g.ID + "&p=" + p.ID
+
+	w := serve(t, apiChatHandler, "POST", base+"&text=hello")
+	var m ChatMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	serve(t, apiChatHandler, "POST", base+"&text=again")
+
+	w = serve(t, apiChatHandler, "GET", base+"&since=0")
+	var messages []ChatMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &messages); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	if len(messages) != 2 || messages[0].ID != m.ID {
+		t.Errorf("GET should return every new message: %s", w.Body.String())
+	}
+
+	w = serve(t, apiChatHandler, "POST", base+"&text=/dance")
+	if w.Code != 500 {
+		t.Errorf("Unknown commands should be an error, got %d", w.Code)
+	}
+}
+
+func TestLobbyChat(t *testing.T) {
+	users = user.Init("salt", "")
+	u, err := users.Register("lobbyist", "open sesame")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	lobbyChat = &ChatLog{Name: "lobby"}
+
+	w := serve(t, apiLobbyChatHandler, "POST", "/api/lobbyChat?u=bad&text=hi")
+	if w.Code != 500 {
+		t.Errorf("Lobby chat needs a user token, got %d", w.Code)
+	}
+	serve(t, apiLobbyChatHandler, "POST", "/api/lobbyChat?u="+u.Token+"&text=hi+all")
+	w = serve(t, apiLobbyChatHandler, "POST", "/api/lobbyChat?u="+u.Token+"&text=/status")
+	if w.Code != 500 {
+		t.Errorf("/status isn't available in the lobby, got %d", w.Code)
+	}
+
+	w = serve(t, apiLobbyChatHandler, "GET", "/api/lobbyChat")
+	var messages []ChatMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &messages); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	if len(messages) != 1 || messages[0].Who != "lobbyist" || messages[0].UserID != u.Id {
+		t.Errorf("Unexpected lobby chat: %s", w.Body.String())
+	}
+}
+
+func TestConcurrentLobbyChat(t *testing.T) {
+	lobbyChat = &ChatLog{Name: "lobby"}
+	const senders = 10
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			userID := fmt.Sprintf("user%d", i)
+			if _, err := sendLobbyChat(userID, userID, "hi"); err != nil {
+				t.Errorf("%s", err)
+			}
+			if _, err := lobbyChat.since(0, ""); err != nil {
+				t.Errorf("%s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	messages, err := lobbyChat.since(0, "")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for i, m := range messages {
+		if m.ID != i+1 {
+			t.Fatalf("Every message should get its own ID, got %+v", messages)
+		}
+	}
+	if len(messages) != senders {
+		t.Errorf("Expected %d messages, got %d", senders, len(messages))
+	}
+}
@@ -0,0 +1,177 @@
+package werks
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Clock tells the time.  The server keeps time with one so that tests can
+// substitute a clock they control.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock that reads the system time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var clock Clock = systemClock{}
+
+// TimeControl limits how long players may take to make decisions.  With
+// PerDecision set, each decision must be made within that many seconds.
+// With Bank set, each player has that many seconds for the whole game, and
+// the time spent on each decision is deducted from it, as with a chess
+// clock.  When a player runs out of time, the Agent named by OnTimeout
+// makes the decision on the player's behalf.
+type TimeControl struct {
+	PerDecision int    `json:"perDecision"`
+	Bank        int    `json:"bank"`
+	OnTimeout   string `json:"onTimeout"`
+}
+
+// Agent chooses an action on behalf of a player, returning its abbreviation.
+type Agent func(g *Game, p *Player) string
+
+// Agents contains the agents that can be named in TimeControl.OnTimeout.
+var Agents = map[string]Agent{
+	"pass": passAgent,
+	"auto": autoAgent,
+}
+
+// passAgent always passes.
+func passAgent(g *Game, p *Player) string {
+	return "P"
+}
+
+// autoAgent develops the cheapest locomotive the player can afford, and
+// passes if there's nothing affordable to develop.
+func autoAgent(g *Game, p *Player) string {
+	abbr, cost := "P", 0
+	for _, a := range g.getActions().Actions {
+		if a.Verb != "Develop" || a.Cost > p.Money {
+			continue
+		}
+		if abbr == "P" || a.Cost < cost {
+			abbr, cost = a.Abbr, a.Cost
+		}
+	}
+	return abbr
+}
+
+// validate checks that the TimeControl is usable.
+func (tc *TimeControl) validate() error {
+	if tc.PerDecision < 0 || tc.Bank < 0 {
+		return errors.New("Time limits can't be negative.")
+	}
+	if tc.PerDecision > 0 && tc.Bank > 0 {
+		return errors.New("Use either a time per decision or a time bank, not both.")
+	}
+	if tc.OnTimeout == "" {
+		tc.OnTimeout = "pass"
+	}
+	if _, ok := Agents[tc.OnTimeout]; !ok {
+		return fmt.Errorf("Unknown timeout agent: %s", tc.OnTimeout)
+	}
+	return nil
+}
+
+// setTimeControl puts the game under the given TimeControl (or none, if tc
+// is nil), filling each player's time bank and starting the clock on the
+// current decision.
+func (g *Game) setTimeControl(tc *TimeControl) error {
+	if tc != nil {
+		if err := tc.validate(); err != nil {
+			return err
+		}
+	}
+	g.TimeControl = tc
+	for _, p := range g.Players {
+		p.TimeBank = 0
+		if tc != nil {
+			p.TimeBank = time.Duration(tc.Bank) * time.Second
+		}
+	}
+	g.startDecision()
+	return nil
+}
+
+// startDecision starts the clock on the current player's decision.
+func (g *Game) startDecision() {
+	g.DecisionStarted = clock.Now()
+	g.updateTimeRemaining()
+}
+
+// timeRemaining returns how long player p has left to make a decision.
+// The clock only runs for the current player.
+func (g *Game) timeRemaining(p *Player) time.Duration {
+	var elapsed time.Duration
+	if p.IsCurrent {
+		elapsed = clock.Now().Sub(g.DecisionStarted)
+	}
+	if g.TimeControl.Bank > 0 {
+		return p.TimeBank - elapsed
+	}
+	return time.Duration(g.TimeControl.PerDecision)*time.Second - elapsed
+}
+
+// isTimed indicates whether the game's decisions are timed.
+func (g *Game) isTimed() bool {
+	tc := g.TimeControl
+	return tc != nil && (tc.PerDecision > 0 || tc.Bank > 0)
+}
+
+// chargeClock deducts the time that player p spent on the current decision
+// from that player's time bank.  It's called when p makes a decision.
+func (g *Game) chargeClock(p *Player) {
+	if !g.isTimed() || g.TimeControl.Bank == 0 {
+		return
+	}
+	p.TimeBank -= clock.Now().Sub(g.DecisionStarted)
+	if p.TimeBank < 0 {
+		p.TimeBank = 0
+	}
+}
+
+// updateTimeRemaining sets each player's TimeRemaining, so that it can be
+// sent to the client.
+func (g *Game) updateTimeRemaining() {
+	for _, p := range g.Players {
+		p.TimeRemaining = 0
+		if g.isTimed() {
+			r := g.timeRemaining(p)
+			if r < 0 {
+				r = 0
+			}
+			p.TimeRemaining = int(r / time.Second)
+		}
+	}
+}
+
+// checkClock makes the decision for any player who has run out of time,
+// using the TimeControl's agent.  Since there's no point in making a player
+// who has exhausted a time bank wait for the next request, it keeps going
+// until the current player has time left, but it'll only time out each
+// player once per call, so that a phase that doesn't advance can't loop.
+func (g *Game) checkClock() {
+	if !g.isTimed() || g.Over || shuttingDown.Load() {
+		return
+	}
+	timedOut := make(map[*Player]bool)
+	for {
+		p := g.getCurrentPlayer()
+		if timedOut[p] || g.timeRemaining(p) > 0 {
+			break
+		}
+		timedOut[p] = true
+		abbr := Agents[g.TimeControl.OnTimeout](g, p)
+		g.addMessage(fmt.Sprintf("%s ran out of time.", p.Name))
+		if err := g.playAction(p, abbr); err != nil {
+			break
+		}
+	}
+	g.updateTimeRemaining()
+}
@@ -0,0 +1,164 @@
+package werks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when it's told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// useFakeClock substitutes a fakeClock for the system clock until the
+// returned function is called.
+func useFakeClock() (*fakeClock, func()) {
+	c := &fakeClock{now: time.Unix(1000000, 0)}
+	clock = c
+	return c, func() { clock = systemClock{} }
+}
+
+// getGameViaApi fetches a game through apiGameHandler, the way a client would.
+func getGameViaApi(t *testing.T, g *Game) *Game {
+	r, err := http.NewRequest("GET", "/api/game?g="+g.ID, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	w := httptest.NewRecorder()
+	apiGameHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status %d: %s", w.Code, w.Body.String())
+	}
+	var result Game
+	if err = json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return &result
+}
+
+func TestTimeControlValidation(t *testing.T) {
+	tests := []struct {
+		tc    TimeControl
+		valid bool
+	}{
+		{TimeControl{PerDecision: 30}, true},
+		{TimeControl{Bank: 600, OnTimeout: "auto"}, true},
+		{TimeControl{PerDecision: 30, Bank: 600}, false},
+		{TimeControl{PerDecision: -1}, false},
+		{TimeControl{PerDecision: 30, OnTimeout: "resign"}, false},
+	}
+	for i, test := range tests {
+		err := test.tc.validate()
+		if (err == nil) != test.valid {
+			t.Errorf("Test %d: validate() returned %v", i, err)
+		}
+	}
+}
+
+func TestAutoAgent(t *testing.T) {
+	g := newGame()
+	p := g.getCurrentPlayer()
+	cheapest := ""
+	cost := 0
+	for _, a := range g.getActions().Actions {
+		if a.Verb == "Develop" && (cheapest == "" || a.Cost < cost) {
+			cheapest, cost = a.Abbr, a.Cost
+		}
+	}
+	if cheapest == "" {
+		t.Fatalf("Expected something to develop.")
+	}
+	p.Money = cost
+	if abbr := autoAgent(g, p); abbr != cheapest {
+		t.Errorf("Expected the cheapest locomotive, %s, got %s", cheapest, abbr)
+	}
+	p.Money = cost - 1
+	if abbr := autoAgent(g, p); abbr != "P" {
+		t.Errorf("Expected a pass when nothing is affordable, got %s", abbr)
+	}
+}
+
+func TestPerDecisionTimeout(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+
+	g := newGame()
+	if err := g.setTimeControl(&TimeControl{PerDecision: 30}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	first := g.getCurrentPlayer()
+
+	c.Advance(10 * time.Second)
+	result := getGameViaApi(t, g)
+	if result.Players[g.ActivePlayer].TimeRemaining != 20 {
+		t.Errorf("Expected 20 seconds remaining, got %d",
+			result.Players[g.ActivePlayer].TimeRemaining)
+	}
+	if result.TimeControl == nil || result.TimeControl.OnTimeout != "pass" {
+		t.Errorf("The game JSON should include the time control.")
+	}
+
+	c.Advance(25 * time.Second)
+	result = getGameViaApi(t, g)
+	if result.Players[g.ActivePlayer].TimeRemaining != 30 {
+		t.Errorf("The next decision should get a fresh 30 seconds, got %d",
+			result.Players[g.ActivePlayer].TimeRemaining)
+	}
+	if len(first.Factories) != 1 {
+		t.Errorf("The timed-out player should have passed.")
+	}
+	announced := false
+	for m := g.getMessage(); m != ""; m = g.getMessage() {
+		announced = announced || m == first.Name+" ran out of time."
+	}
+	if !announced {
+		t.Errorf("The timeout should have been announced.")
+	}
+}
+
+func TestTimeBank(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+
+	g := newGame()
+	if err := g.setTimeControl(&TimeControl{Bank: 60}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	p := g.getCurrentPlayer()
+
+	c.Advance(15 * time.Second)
+	if err := g.performAction(p, "P"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if p.TimeBank != 45*time.Second {
+		t.Errorf("Expected 45 seconds left in the bank, got %s", p.TimeBank)
+	}
+
+	// time only runs on the current player's clock.
+	q := g.getCurrentPlayer()
+	var idle *Player
+	for _, p0 := range g.Players {
+		if p0 != p && p0 != q {
+			idle = p0
+		}
+	}
+	c.Advance(70 * time.Second)
+	g.checkClock()
+	if q.TimeBank != 0 {
+		t.Errorf("Expected %s's bank to be exhausted, got %s", q.Name, q.TimeBank)
+	}
+	if idle.TimeBank != 60*time.Second {
+		t.Errorf("%s's bank shouldn't have run, got %s", idle.Name, idle.TimeBank)
+	}
+}
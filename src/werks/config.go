@@ -0,0 +1,303 @@
+package werks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"turnorder"
+)
+
+// GameConfig contains the options a game is set up with.  It's stored with
+// the game, so that replaying the game sets it up the same way.
+//
+// StartingMoney is the money each player starts with, and StartingFactories
+// contains the keys of the Locos each player starts out with a factory for.
+// If either is unset (nil, or null in JSON), it's what the PlayerCountRule
+// for the number of players calls for; an empty list of factories means
+// the players start without any.
+// InitialOrders contains the keys of the Locos that start the game with an
+// Initial Orders die, and ExistingOrders maps the keys of Locos to the number
+// of Existing Orders dice they start with.
+// If Seed is 0, a random seed is chosen when the game is created; it's left
+// out of the config's JSON, so it's only saved with the game itself.  TurnOrder
+// names one of the TurnOrderPolicies, and Insolvency decides what happens to
+// players who run out of money.
+type GameConfig struct {
+	StartingMoney     *int           `json:"startingMoney"`
+	StartingFactories []string       `json:"startingFactories"`
+	Catalog           string         `json:"catalog"`
+	MinPlayers        int            `json:"minPlayers"`
+	MaxPlayers        int            `json:"maxPlayers"`
+	EndCondition      EndCondition   `json:"endCondition"`
+	Seed              int            `json:"-"`
+	QueueCapacity     int            `json:"queueCapacity"`
+	InitialOrders     []string       `json:"initialOrders"`
+	ExistingOrders    map[string]int `json:"existingOrders"`
+	TurnOrder         string         `json:"turnOrder"`
+	Insolvency        InsolvencyRule `json:"insolvency"`
+}
+
+// EndCondition determines when the game ends.  If Kind is "turns", the game
+// ends once Value turns have been played; if it's "money", the game ends
+// once a player has at least Value money.  If Kind is empty, the game
+// doesn't end by itself.
+type EndCondition struct {
+	Kind  string `json:"kind"`
+	Value int    `json:"value"`
+}
+
+// TurnOrderPolicy decides the order players take their turns in.  ByMoney
+// is set if the order depends on the players' money.
+type TurnOrderPolicy struct {
+	New     func(g *Game) turnorder.Policy
+	ByMoney bool
+}
+
+// TurnOrderPolicies contains the policies a game can use.  Under the
+// standard "money" policy the richest player goes first; "catch-up" puts the
+// poorest first instead.
+var TurnOrderPolicies = map[string]TurnOrderPolicy{
+	"money": {
+		New:     func(g *Game) turnorder.Policy { return turnorder.ByScore{Score: g.money} },
+		ByMoney: true},
+	"catch-up": {
+		New: func(g *Game) turnorder.Policy {
+			return turnorder.Reverse{Policy: turnorder.ByScore{Score: g.money}}
+		},
+		ByMoney: true},
+	"clockwise": {
+		New: func(g *Game) turnorder.Policy { return turnorder.Clockwise{} }},
+	"rotate": {
+		New: func(g *Game) turnorder.Policy { return turnorder.RotateStart{} }},
+}
+
+// PlayerCountRule describes how the game scales with the number of players.
+// CountAdjustment is added to each Loco's Count (the number of factories
+// that can be built for it), though never below 1, and DicePerSlot limits
+// the number of dice in each of a Loco's rows.
+type PlayerCountRule struct {
+	StartingMoney     int      `json:"startingMoney"`
+	StartingFactories []string `json:"startingFactories"`
+	CountAdjustment   int      `json:"countAdjustment"`
+	DicePerSlot       int      `json:"dicePerSlot"`
+}
+
+// PlayerCountRules contains the rule for each supported number of players.
+var PlayerCountRules = map[int]PlayerCountRule{
+	2: {StartingMoney: 12, StartingFactories: []string{"p1"}, CountAdjustment: -2, DicePerSlot: 3},
+	3: {StartingMoney: 12, StartingFactories: []string{"p1"}, CountAdjustment: -1, DicePerSlot: 4},
+	4: {StartingMoney: 12, StartingFactories: []string{"p1"}, CountAdjustment: 0, DicePerSlot: 5},
+	5: {StartingMoney: 12, StartingFactories: []string{"p1"}, CountAdjustment: 0, DicePerSlot: 5},
+	6: {StartingMoney: 10, StartingFactories: []string{"p1"}, CountAdjustment: 1, DicePerSlot: 5},
+}
+
+// DefaultGameConfig returns the standard setup for a game.
+func DefaultGameConfig() *GameConfig {
+	return &GameConfig{
+		Catalog:        DefaultCatalog,
+		MinPlayers:     2,
+		MaxPlayers:     6,
+		QueueCapacity:  500,
+		InitialOrders:  []string{"a1"},
+		ExistingOrders: map[string]int{"p1": 3},
+		TurnOrder:      "money"}
+}
+
+// parseGameConfig returns the DefaultGameConfig, overridden by whichever
+// options are set in the JSON object s.
+func parseGameConfig(s string) (*GameConfig, error) {
+	c := DefaultGameConfig()
+	if s == "" {
+		return c, nil
+	}
+	if err := c.merge([]byte(s)); err != nil {
+		return nil, fmt.Errorf("Invalid game configuration: %s", err)
+	}
+	return c, nil
+}
+
+// merge overrides the options set in the JSON object b.  Unlike
+// json.Unmarshal, it replaces ExistingOrders rather than adding to it.
+func (c *GameConfig) merge(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if _, ok := fields["existingOrders"]; ok {
+		c.ExistingOrders = nil
+	}
+	return json.Unmarshal(b, c)
+}
+
+// validate checks the options that don't depend on the catalog.
+func (c *GameConfig) validate() error {
+	if c.StartingMoney != nil && *c.StartingMoney < 0 {
+		return errors.New("startingMoney can't be negative.")
+	}
+	if _, ok := PlayerCountRules[c.MinPlayers]; !ok {
+		return fmt.Errorf("%d players aren't supported.", c.MinPlayers)
+	}
+	if _, ok := PlayerCountRules[c.MaxPlayers]; !ok {
+		return fmt.Errorf("%d players aren't supported.", c.MaxPlayers)
+	}
+	if c.MaxPlayers < c.MinPlayers {
+		return errors.New("maxPlayers can't be less than minPlayers.")
+	}
+	if c.QueueCapacity < 1 {
+		return errors.New("queueCapacity must be at least 1.")
+	}
+	if err := c.Insolvency.validate(); err != nil {
+		return err
+	}
+	if _, ok := TurnOrderPolicies[c.TurnOrder]; !ok {
+		return fmt.Errorf("Unknown turnOrder: %s", c.TurnOrder)
+	}
+	switch c.EndCondition.Kind {
+	case "":
+	case "turns", "money":
+		if c.EndCondition.Value < 1 {
+			return fmt.Errorf("endCondition %s must have a positive value.", c.EndCondition.Kind)
+		}
+	default:
+		return fmt.Errorf("Unknown endCondition: %s", c.EndCondition.Kind)
+	}
+	return nil
+}
+
+// checkPlayerCount checks that a game can be played with n players.
+func (c *GameConfig) checkPlayerCount(n int) error {
+	if n < c.MinPlayers || n > c.MaxPlayers {
+		return fmt.Errorf("The game needs between %d and %d players, not %d.",
+			c.MinPlayers, c.MaxPlayers, n)
+	}
+	return nil
+}
+
+// startingMoney returns the money each of n players starts with.
+func (c *GameConfig) startingMoney(n int) int {
+	if c.StartingMoney != nil {
+		return *c.StartingMoney
+	}
+	return PlayerCountRules[n].StartingMoney
+}
+
+// startingFactories returns the keys of the Locos each of n players starts
+// out with a factory for.
+func (c *GameConfig) startingFactories(n int) []string {
+	if c.StartingFactories != nil {
+		return c.StartingFactories
+	}
+	return PlayerCountRules[n].StartingFactories
+}
+
+// applyPlayerCountRule adjusts the Locos for a game with n players.  A Count
+// of 0 means there's no limit to the factories for a Loco, so it's left
+// alone.
+func (g *Game) applyPlayerCountRule(n int) {
+	rule := PlayerCountRules[n]
+	for _, loco := range g.Locos {
+		if loco.Count > 0 {
+			loco.Count += rule.CountAdjustment
+			if loco.Count < 1 {
+				loco.Count = 1
+			}
+		}
+		if loco.MaxExistingOrders > rule.DicePerSlot {
+			loco.MaxExistingOrders = rule.DicePerSlot
+		}
+		if loco.MaxCustomerBase > rule.DicePerSlot {
+			loco.MaxCustomerBase = rule.DicePerSlot
+		}
+	}
+}
+
+// factoryCount returns the number of factories the players have built for
+// the Loco with the given key.
+func (g *Game) factoryCount(key string) int {
+	n := 0
+	for _, p := range g.Players {
+		for _, f := range p.Factories {
+			if f.Key == key {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// validateLocos checks the options that refer to the Locos in the catalog.
+func (c *GameConfig) validateLocos(locos map[string]*Loco) error {
+	for n := c.MinPlayers; n <= c.MaxPlayers; n++ {
+		for _, key := range c.startingFactories(n) {
+			if locos[key] == nil {
+				return fmt.Errorf("startingFactories: no loco %s in the catalog.", key)
+			}
+		}
+	}
+	for _, key := range c.InitialOrders {
+		if locos[key] == nil {
+			return fmt.Errorf("initialOrders: no loco %s in the catalog.", key)
+		}
+	}
+	for key, n := range c.ExistingOrders {
+		loco := locos[key]
+		if loco == nil {
+			return fmt.Errorf("existingOrders: no loco %s in the catalog.", key)
+		}
+		if n < 0 || n > loco.MaxExistingOrders {
+			return fmt.Errorf("existingOrders: %s can have between 0 and %d dice.",
+				key, loco.MaxExistingOrders)
+		}
+	}
+	return nil
+}
+
+// existingOrdersKeys returns the keys of ExistingOrders in order, so that
+// the dice are always rolled in the same order.
+func (c *GameConfig) existingOrdersKeys() []string {
+	keys := make([]string, 0, len(c.ExistingOrders))
+	for key := range c.ExistingOrders {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkEndCondition ends the game if its EndCondition has been met, or if
+// every player but one has been eliminated, and announces the final scores.
+func (g *Game) checkEndCondition() {
+	if g.Over {
+		return
+	}
+	ec := g.Config.EndCondition
+	switch ec.Kind {
+	case "turns":
+		g.Over = g.Turn > ec.Value
+	case "money":
+		for _, p := range g.activePlayers() {
+			g.Over = g.Over || p.Money >= ec.Value
+		}
+	}
+	if active := g.activePlayers(); len(active) < len(g.Players) && len(active) <= 1 {
+		g.Over = true
+		if len(active) == 1 {
+			g.addMessage(fmt.Sprintf("%s is the last player standing.", active[0].Name))
+		}
+	}
+	if g.Over {
+		g.addMessage("Game over.")
+		g.addMessage(g.scoreMessage())
+	}
+}
+
+// Configure sets the GameConfig that Start will set the game up with; it
+// implements gamework.Configurable.
+func (g *Game) Configure(config []byte) error {
+	c := DefaultGameConfig()
+	if err := c.merge(config); err != nil {
+		return err
+	}
+	g.Config = c
+	return nil
+}
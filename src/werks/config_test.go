@@ -0,0 +1,319 @@
+package werks
+
+import (
+	"encoding/json"
+	"fmt"
+	"gamework"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGameConfigValidation(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	names := []string{"Abel", "Baker"}
+	tests := []struct {
+		config string
+		ok     bool
+	}{
+		{``, true},
+		{`{"startingMoney": 20, "startingFactories": ["p1", "a1"]}`, true},
+		{`{"endCondition": {"kind": "turns", "value": 3}}`, true},
+		{`{"startingMoney": -1}`, false},
+		{`{"minPlayers": 3}`, false},
+		{`{"minPlayers": 1}`, false},
+		{`{"maxPlayers": 1}`, false},
+		{`{"maxPlayers": 7}`, false},
+		{`{"queueCapacity": 0}`, false},
+		{`{"endCondition": {"kind": "turns"}}`, false},
+		{`{"endCondition": {"kind": "forever", "value": 1}}`, false},
+		{`{"startingFactories": ["x9"]}`, false},
+		{`{"initialOrders": ["x9"]}`, false},
+		{`{"existingOrders": {"p1": 9}}`, false},
+		{`{"startingMoney": "lots"}`, false},
+		{`{"turnOrder": "rotate"}`, true},
+		{`{"turnOrder": "random"}`, false},
+		{`{"insolvency": {"kind": "loans", "loanAmount": 10, "maxLoans": 2}}`, true},
+		{`{"insolvency": {"kind": "loans", "loanAmount": 10}}`, false},
+		{`{"insolvency": {"kind": "elimination"}}`, false},
+		{`{"insolvency": {"kind": "bailout"}}`, false},
+	}
+	for _, test := range tests {
+		config, err := parseGameConfig(test.config)
+		if err == nil {
+			_, err = makeNewGame("config", names, config)
+		}
+		if test.ok && err != nil {
+			t.Errorf("%s should be valid: %s", test.config, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s should be invalid.", test.config)
+		}
+	}
+}
+
+// postNewGame sends the form to apiNewGameHandler.
+func postNewGame(t *testing.T, form url.Values) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("POST", "/api/newGame", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	apiNewGameHandler(w, r)
+	return w
+}
+
+func TestNewGameWithConfig(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	config := `{"startingMoney": 20, "startingFactories": ["p1", "a1"],
+		"existingOrders": {"a1": 2}, "initialOrders": ["p1"], "queueCapacity": 10}`
+	form := url.Values{
+		"name":        {"configured"},
+		"playerCount": {"2"},
+		"player0":     {"Abel"},
+		"player1":     {"Baker"},
+		"config":      {config}}
+	w := postNewGame(t, form)
+
+	var g Game
+	if err := json.Unmarshal(w.Body.Bytes(), &g); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	p := g.Players[0]
+	if p.Money != 20 || len(p.Factories) != 2 || p.Factories[1].Key != "a1" {
+		t.Errorf("Unexpected starting position: %s", w.Body.String())
+	}
+	if g.Config == nil || g.Config.QueueCapacity != 10 {
+		t.Errorf("The game should store its config.")
+	}
+	if stored := Games[g.ID]; stored == nil || stored.Seed == 0 || stored.Config.Seed == 0 {
+		t.Errorf("The game should be given a seed.")
+	}
+	if strings.Contains(w.Body.String(), "seed") {
+		t.Errorf("The seed shouldn't be public: %s", w.Body.String())
+	}
+	for _, loco := range g.Locos {
+		if loco.Key == "p1" && (!loco.InitialOrders.Render || loco.InitialOrders.Pips == 0) {
+			t.Errorf("p1 should have an Initial Orders die.")
+		}
+		if loco.Key == "a1" && loco.ExistingOrders[1].Pips == 0 {
+			t.Errorf("a1 should have 2 Existing Orders dice.")
+		}
+	}
+}
+
+func TestReplayUsesConfig(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	config := DefaultGameConfig()
+	money := 30
+	config.StartingMoney = &money
+	config.InitialOrders = []string{"p1", "a1"}
+	g, err := makeNewGame("replay", []string{"Abel", "Baker"}, config)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	playFewActions(t, g)
+
+	s, err := gamework.WriteToString(g.gameworkGame())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var gg gamework.Game
+	if err := gamework.ReadFromString(s, &gg); err != nil {
+		t.Fatalf("%s", err)
+	}
+	e := new(Game)
+	gg.Engine = e
+	if err := gamework.Replay(gg); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if *e.Config.StartingMoney != 30 || !g.Equals(e) {
+		t.Errorf("Replayed game differs.\n%s\n%s", g.Debug(), e.Debug())
+	}
+}
+
+func TestEndCondition(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	config := DefaultGameConfig()
+	config.EndCondition = EndCondition{Kind: "money", Value: 12}
+	g, err := makeNewGame("short", []string{"Abel", "Baker"}, config)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	p := g.getCurrentPlayer()
+	if err := g.performAction(p, "P"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !g.Over {
+		t.Fatalf("The game should be over once a player has 12 money.")
+	}
+	if len(g.getActions().Actions) != 0 {
+		t.Errorf("There should be no actions once the game is over.")
+	}
+	if err := g.performAction(g.getCurrentPlayer(), "P"); err == nil {
+		t.Errorf("Actions should be rejected once the game is over.")
+	}
+}
+
+func TestPlayerCountRules(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	c, err := LoadCatalog(LocosJsonPath)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for n := 2; n <= 6; n++ {
+		rule, ok := PlayerCountRules[n]
+		if !ok {
+			t.Fatalf("There's no rule for %d players.", n)
+		}
+		names := []string{"Abel", "Baker", "Charlie", "Dog", "Easy", "Fox"}[:n]
+		g, err := makeNewGame("count", names, nil)
+		if err != nil {
+			t.Fatalf("%d players: %s", n, err)
+		}
+		for _, p := range g.Players {
+			if p.Money != rule.StartingMoney {
+				t.Errorf("%d players: %s has %d money, expected %d.",
+					n, p.Name, p.Money, rule.StartingMoney)
+			}
+			if len(p.Factories) != len(rule.StartingFactories) {
+				t.Errorf("%d players: %s has %d factories, expected %d.",
+					n, p.Name, len(p.Factories), len(rule.StartingFactories))
+			}
+		}
+		for i, loco := range g.Locos {
+			count := c.Locos[i].Count + rule.CountAdjustment
+			if count < 1 {
+				count = 1
+			}
+			if loco.Count != count {
+				t.Errorf("%d players: %s has count %d, expected %d.",
+					n, loco.Key, loco.Count, count)
+			}
+			rendered := 0
+			for _, d := range loco.CustomerBase {
+				if d.Render {
+					rendered++
+				}
+			}
+			if rendered > rule.DicePerSlot || loco.MaxExistingOrders > rule.DicePerSlot {
+				t.Errorf("%d players: %s should have at most %d dice per slot.",
+					n, loco.Key, rule.DicePerSlot)
+			}
+		}
+	}
+}
+
+func TestStartingMoneyAndFactories(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	tests := []struct {
+		config    string
+		money     int
+		factories int
+	}{
+		{``, 10, 1},
+		{`{"startingMoney": null, "startingFactories": null}`, 10, 1},
+		{`{"startingMoney": 0, "startingFactories": []}`, 0, 0},
+		{`{"startingMoney": 25, "startingFactories": ["p1", "a1"]}`, 25, 2},
+	}
+	names := []string{"Abel", "Baker", "Charlie", "Dog", "Easy", "Fox"}
+	for _, test := range tests {
+		config, err := parseGameConfig(test.config)
+		if err != nil {
+			t.Fatalf("%s: %s", test.config, err)
+		}
+		g, err := makeNewGame("setup", names, config)
+		if err != nil {
+			t.Fatalf("%s: %s", test.config, err)
+		}
+		p := g.Players[0]
+		if p.Money != test.money || len(p.Factories) != test.factories {
+			t.Errorf("%s: expected %d money and %d factories, got %d and %d",
+				test.config, test.money, test.factories, p.Money, len(p.Factories))
+		}
+	}
+}
+
+func TestLocoCountLimitsFactories(t *testing.T) {
+	g := newGame()
+	loco := g.LocoMap["a1"]
+	loco.Count = 1
+	if !g.isLocoAvailableForDevelopment(loco) {
+		t.Fatalf("a1 should be available.")
+	}
+	g.Players[1].Factories = append(g.Players[1].Factories, Factory{Key: "a1", Capacity: 1})
+	if g.isLocoAvailableForDevelopment(loco) {
+		t.Errorf("a1 shouldn't be available once all its factories are built.")
+	}
+}
+
+func TestNewGamePlayerCount(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	for _, count := range []string{"0", "1", "7", "-1", "two"} {
+		w := postNewGame(t, url.Values{"name": {"bad"}, "playerCount": {count}})
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("A game with %s players should be rejected, got %d.", count, w.Code)
+		}
+	}
+	for n := 2; n <= 6; n++ {
+		form := url.Values{"name": {"good"}, "playerCount": {strconv.Itoa(n)}}
+		for i := 0; i < n; i++ {
+			form.Set(fmt.Sprintf("player%d", i), fmt.Sprintf("Player %d", i))
+		}
+		w := postNewGame(t, form)
+		var g Game
+		if err := json.Unmarshal(w.Body.Bytes(), &g); err != nil {
+			t.Fatalf("%d players: %s: %s", n, err, w.Body.String())
+		}
+		if len(g.Players) != n {
+			t.Errorf("Expected %d players, got %d.", n, len(g.Players))
+		}
+	}
+}
+
+func TestStartWithBadPlayerCount(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	g := new(Game)
+	state := g.Start("id", "name", []gamework.Player{{Id: "A", Name: "Alone"}}, 1)
+	if state.Outcome.Abbr != "error" || len(state.AvailableOptions) != 0 {
+		t.Errorf("A game with one player shouldn't start, got %v", state)
+	}
+}
+
+func TestTurnOrderPolicy(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	tests := []struct {
+		policy   string
+		expected string
+	}{
+		{"money", "Turn order: Baker (12), Charlie (12), Abel (4)."},
+		{"catch-up", "Turn order: Charlie (4), Abel (12), Baker (12)."},
+		{"clockwise", "Turn order: Abel (4), Baker (12), Charlie (12)."},
+		{"rotate", "Turn order: Baker (12), Charlie (12), Abel (4)."},
+	}
+	// the start player develops the a1 in the first turn.
+	for _, test := range tests {
+		config := DefaultGameConfig()
+		config.TurnOrder = test.policy
+		g, err := makeNewGame("policy", []string{"Abel", "Baker", "Charlie"}, config)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		for i := 0; i < 3*len(g.Players); i++ {
+			abbr := "P"
+			if i == 0 {
+				abbr = "D:a1"
+			}
+			if err := g.performAction(g.getCurrentPlayer(), abbr); err != nil {
+				t.Fatalf("%s: %s", test.policy, err)
+			}
+		}
+		if !strings.Contains(strings.Join(g.Log, "\n"), test.expected) {
+			t.Errorf("%s: the log should contain %q", test.policy, test.expected)
+		}
+	}
+}
@@ -1,22 +1,26 @@
 package werks
 
 import (
-	"container/heap"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"gamework"
-	"io/ioutil"
 	"log"
 	"math/rand"
-	"os"
-	"path/filepath"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
+	"turnorder"
+	"user"
 	"uuid"
 )
 
 var LocosJsonPath = "./json/locos.json"
 
+// ErrGameOver is returned for actions taken after the game is over.
+var ErrGameOver = errors.New("The game is over.")
+
 // Game represents a single game.
 type Game struct {
 	ID           string           `json:"id"`
@@ -27,21 +31,61 @@ type Game struct {
 	StartPlayer  int              `json:"startPlayer"`
 	ActivePlayer int              `json:"currentPlayer"`
 	Phase        Phase            `json:"phase"`
+	TimeControl  *TimeControl     `json:"timeControl"`
+	Async        bool             `json:"async"`
+	Config       *GameConfig      `json:"config"`
+	Over         bool             `json:"over"`
+	Log          []string         `json:"-"`
 	Messages     Queue            `json:"-"`
 	LocoMap      map[string]*Loco `json:"-"`
-	TurnOrder    PlayerQueue      `json:"-"`
-	PhaseOrder   PlayerQueue      `json:"-"`
-}
-
-// Player represents one of the players in the game.
+	TurnOrder    []int            `json:"-"`
+	PhaseOrder   turnorder.Queue  `json:"-"`
+
+	// DecisionStarted is when the current player's clock started, and
+	// RemindedAt is when the current player was last reminded about an
+	// asynchronous game.
+	DecisionStarted time.Time `json:"-"`
+	RemindedAt      time.Time `json:"-"`
+
+	// Spectators are watching the game; if SpectatorChat is set, they
+	// can read the players' chat.
+	Spectators    map[string]*Spectator `json:"-"`
+	SpectatorChat bool                  `json:"spectatorChat"`
+
+	// Chat is the players' chat history.
+	Chat *ChatLog `json:"-"`
+
+	// History contains every action performed in the game, in order, so
+	// that it can be replayed; rng is seeded with Seed.  Seed is kept out
+	// of the game's JSON, since anyone who knew it could predict the dice.
+	History []gamework.Action `json:"-"`
+	Seed    int               `json:"-"`
+	rng     *rand.Rand
+}
+
+// Player represents one of the players in the game.  UserID identifies the
+// user playing, if the player is linked to one.  If the game is timed,
+// TimeRemaining is the number of seconds the player has left to decide.
+// Money always matches the total of the player's Ledger.  LastSeen is when
+// the player last made a request for the game.  Profile is the linked
+// user's profile.  The ID is all a client needs to act for the player, so
+// it's only ever returned to the player themselves (see redactedFor), and
+// to whoever creates the game, to hand out.
 type Player struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	Money        int       `json:"money"`
-	Factories    []Factory `json:"factories"`
-	IsCurrent    bool      `json:"isCurrent"`
-	TurnOrder    int       `json:"turnOrder"`
-	ChatMessages Queue     `json:"-"`
+	ID            string        `json:"id,omitempty"`
+	Name          string        `json:"name"`
+	UserID        string        `json:"userId"`
+	Money         int           `json:"money"`
+	Factories     []Factory     `json:"factories"`
+	IsCurrent     bool          `json:"isCurrent"`
+	TurnOrder     int           `json:"turnOrder"`
+	TimeRemaining int           `json:"timeRemaining"`
+	TimeBank      time.Duration `json:"-"`
+	Ledger        []Transaction `json:"-"`
+	Loans         int           `json:"loans"`
+	Eliminated    bool          `json:"eliminated"`
+	LastSeen      time.Time     `json:"-"`
+	Profile       *user.Profile `json:"profile,omitempty"`
 }
 
 // Factory represents a factory owned by a player.
@@ -129,19 +173,52 @@ func (g *Game) findAction(abbr string) *Action {
 }
 
 // performAction finds the Action for the given abbreviation and routes
-// control to the handler appropriate for the phase.
-func (g *Game) performAction(abbr string) {
+// control to the handler appropriate for the phase.  It returns an error,
+// and does nothing, if the server is shutting down, the game is over, it
+// isn't the player's turn or the abbreviation isn't one of the available
+// actions.
+func (g *Game) performAction(p *Player, abbr string) error {
 	m := make(map[Phase]func(*Action))
 	m[Development] = func(a *Action) { g.performDevelopmentAction(a) }
 	m[Capacity] = func(a *Action) { g.performCapacityAction(a) }
 	m[Production] = func(a *Action) { g.performProductionAction(a) }
 
+	if g.Over {
+		return ErrGameOver
+	}
+	if shuttingDown.Load() {
+		return ErrShuttingDown
+	}
+	if !p.IsCurrent {
+		return &gamework.NotYourTurnError{PlayerId: p.ID}
+	}
 	a := g.findAction(abbr)
 	if a == nil {
-		log.Printf("Couldn't find action for abbreviation %s", abbr)
+		return &gamework.UnknownOptionError{PlayerId: p.ID, Abbr: abbr}
 	}
 
-	m[g.Phase](a)
+	g.chargeClock(p)
+	g.History = append(g.History, gamework.Action{Abbr: abbr, PlayerId: p.ID})
+	if a.Abbr == "L" {
+		// borrowing doesn't use up the player's decision.
+		g.borrow(p)
+	} else {
+		m[g.Phase](a)
+	}
+	g.checkEndCondition()
+	g.startDecision()
+	return nil
+}
+
+// playAction performs an action a player, or their Agent, chose, as opposed
+// to one being replayed, and counts it in the metrics.
+func (g *Game) playAction(p *Player, abbr string) error {
+	phase := g.Phase
+	if err := g.performAction(p, abbr); err != nil {
+		return err
+	}
+	countAction(phase)
+	return nil
 }
 
 func (g *Game) performDevelopmentAction(a *Action) {
@@ -150,32 +227,75 @@ func (g *Game) performDevelopmentAction(a *Action) {
 	//   D = develop
 	if strings.HasPrefix(a.Abbr, "D") {
 		p := g.getCurrentPlayer()
-		f := Factory{Key: a.Loco.Key, Capacity: 1}
-		p.Factories = append(p.Factories, f)
-		p.Money -= a.Loco.DevelopmentCost
-	}
-	p := g.getNextPlayer(false)
-	if p == nil {
-		g.Phase = Capacity
-		g.setCurrentPlayer(g.getStartPlayer())
+		if g.charge(p, TxDevelopment, a.Loco.DevelopmentCost, a.Loco.Name) {
+			f := Factory{Key: a.Loco.Key, Capacity: 1}
+			p.Factories = append(p.Factories, f)
+			g.addMessage(fmt.Sprintf("%s developed the %s.", p.Name, a.Loco.Name))
+		} else if !p.Eliminated {
+			g.addMessage(fmt.Sprintf("%s can't afford the %s.", p.Name, a.Loco.Name))
+		}
 	} else {
-		g.setCurrentPlayer(p)
+		g.addMessage(fmt.Sprintf("%s passed.", g.getCurrentPlayer().Name))
 	}
+	g.endDecision()
 }
 
 func (g *Game) performCapacityAction(a *Action) {
 	log.Printf("Capacity action: %s", a.Abbr)
+	g.addMessage(fmt.Sprintf("%s passed.", g.getCurrentPlayer().Name))
+	g.endDecision()
 }
 
 func (g *Game) performProductionAction(a *Action) {
 	log.Printf("Production action: %s", a.Abbr)
+	g.addMessage(fmt.Sprintf("%s passed.", g.getCurrentPlayer().Name))
+	g.endDecision()
+}
+
+// endDecision moves on to the next player in the phase.  Once every player
+// has had their turn in the phase, it starts the next phase, or, after the
+// last phase, the next turn.
+func (g *Game) endDecision() {
+	if p := g.getNextPlayer(false); p != nil {
+		g.setCurrentPlayer(p)
+		return
+	}
+	if int(g.Phase) == len(Phases) {
+		g.startTurn()
+		return
+	}
+	g.Phase++
+	g.startPhase()
+}
+
+// startTurn starts a new turn, in a new turn order.
+func (g *Game) startTurn() {
+	g.Turn++
+	g.addMessage(fmt.Sprintf("Starting turn %d...", g.Turn))
+	g.chargeInterest()
+	g.determineTurnOrder()
+	g.StartPlayer = g.indexOf(g.getStartPlayer())
+	g.Phase = Development
+	g.startPhase()
+}
+
+// startPhase gives the first decision of the phase to the start player.
+func (g *Game) startPhase() {
+	g.PhaseOrder = turnorder.NewQueue(g.TurnOrder)
+	if p := g.getNextPlayer(false); p != nil {
+		g.setCurrentPlayer(p)
+	}
 }
 
 // getActions returns the actions that are available to the current
-// player right now.
+// player right now; there are none once the game is over.
 func (g *Game) getActions() *Actions {
 
 	actions := make([]Action, 0)
+	phase := Phases[g.Phase-1]
+	if g.Over {
+		return &Actions{Phase: phase, Actions: actions}
+	}
 
 	if g.Phase == Development {
 		for _, loco := range g.Locos {
@@ -193,13 +313,20 @@ func (g *Game) getActions() *Actions {
 
 	// I think you can always pass.
 	actions = append(actions, Action{Abbr: "P", Verb: "Pass"})
-	phase := Phases[g.Phase-1]
+
+	if g.canBorrow(g.getCurrentPlayer()) {
+		actions = append(actions, Action{
+			Abbr: "L",
+			Verb: "Borrow",
+			Noun: fmt.Sprintf("%d", g.Config.Insolvency.LoanAmount)})
+	}
 	return &Actions{Phase: phase, Actions: actions}
 }
 
 // isLocoAvailableForDevelopment indicates if a given Loco is
 // obsolete, has an Initial Orders die, or has at least one
-// Existing Orders die.
+// Existing Orders die, and whether all of its factories have been
+// built.
 func (g *Game) isLocoAvailableForDevelopment(loco *Loco) bool {
 	if loco.Obsolete {
 		return false
@@ -213,9 +340,12 @@ func (g *Game) isLocoAvailableForDevelopment(loco *Loco) bool {
 	if !hasPips {
 		return false
 	}
+	if loco.Count > 0 && g.factoryCount(loco.Key) >= loco.Count {
+		return false
+	}
 	// from here on, we can develop the loco unless the player can't.
 	p := g.getCurrentPlayer()
-	if loco.DevelopmentCost > p.Money {
+	if loco.DevelopmentCost > g.purchasingPower(p) {
 		return false
 	}
 	for _, f := range p.Factories {
@@ -226,25 +356,61 @@ func (g *Game) isLocoAvailableForDevelopment(loco *Loco) bool {
 	return true
 }
 
-// makeNewGame creates a new game with the provided player names.
-func makeNewGame(name string, playerNames []string) *Game {
+// makeNewGame creates a new game with the provided player names, set up
+// according to config (or the DefaultGameConfig, if config is nil).
+func makeNewGame(name string, playerNames []string, config *GameConfig) (*Game, error) {
+	if config == nil {
+		config = DefaultGameConfig()
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.checkPlayerCount(len(playerNames)); err != nil {
+		return nil, err
+	}
+	if config.Seed == 0 {
+		config.Seed = int(time.Now().UnixNano())
+	}
+
 	var g = new(Game)
 	id, err := uuid.GenUUID()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	g.Config = config
+	if err = g.setup(id, name, playerNames); err != nil {
+		return nil, err
+	}
+	Games[g.ID] = g
+
+	return g, nil
+}
+
+// setup initializes a new game according to g.Config.  Its Seed initializes
+// the game's random number generator, so that a game set up with the same
+// configuration and given the same actions will always end up in the same
+// state.
+func (g *Game) setup(id string, name string, playerNames []string) error {
 	g.ID = id
 	g.Name = name
-	g.Messages.Capacity = 500
+	g.Seed = g.Config.Seed
+	g.rng = rand.New(rand.NewSource(int64(g.Seed)))
+	g.History = make([]gamework.Action, 0)
+	g.Messages.Capacity = g.Config.QueueCapacity
 	g.Phase = Development
-	Games[g.ID] = g
 	g.addMessage(fmt.Sprintf("Created game %s...", g.Name))
-	g.loadLocos()
+	if err := g.loadLocos(); err != nil {
+		return err
+	}
+	g.applyPlayerCountRule(len(playerNames))
+	if err := g.Config.validateLocos(g.LocoMap); err != nil {
+		return err
+	}
 	g.prepareLocos()
 	g.initPlayers(playerNames)
-	g.determineTurnOrder()
-
-	return g
+	g.startTurn()
+	g.startDecision()
+	return nil
 }
 
 // getNextPlayer returns the next player.  If wrap is false, each player
@@ -252,40 +418,69 @@ func makeNewGame(name string, playerNames []string) *Game {
 // If wrap is true, this will keep returning the next player in order
 // indefinitely.
 func (g *Game) getNextPlayer(wrap bool) *Player {
-	if len(g.PhaseOrder) == 0 {
-		if !wrap {
-			return nil
+	if len(g.activePlayers()) == 0 {
+		return nil
+	}
+	for {
+		if g.PhaseOrder.Len() == 0 {
+			if !wrap {
+				return nil
+			}
+			g.PhaseOrder = turnorder.NewQueue(g.TurnOrder)
+		}
+		seat, _ := g.PhaseOrder.Next()
+		if !g.Players[seat].Eliminated {
+			return g.Players[seat]
 		}
-		g.PhaseOrder = make(PlayerQueue, len(g.Players))
-		copy(g.PhaseOrder, g.TurnOrder)
 	}
-	pi := heap.Pop(&g.PhaseOrder).(*PlayerInfo)
-	return pi.player
 }
 
-// determineTurnOrder initializes the TurnOrder priority
-// queue, ordering players by money and previous turn
-// order.  It also initializes the PhaseOrder queue.
+// determineTurnOrder sets TurnOrder, the seats of the players
+// in the order they'll play this turn, using the game's
+// TurnOrderPolicy, and announces the new order.  If the order
+// depends on money, ties are broken by the previous turn order,
+// and each tie-break is announced too.
 func (g *Game) determineTurnOrder() {
-	g.TurnOrder = make(PlayerQueue, 0, len(g.Players))
-	for i, _ := range g.Players {
-		pi := &PlayerInfo{player: g.Players[i], turnOrder: i}
-		heap.Push(&g.TurnOrder, pi)
+	prev := make([]int, len(g.Players))
+	for i, p := range g.Players {
+		prev[p.TurnOrder] = i
+	}
+	policy := TurnOrderPolicies[g.Config.TurnOrder]
+	g.TurnOrder = policy.New(g).Order(prev, g.Turn)
+
+	// eliminated players keep their place, but don't take turns.
+	active := make([]int, 0, len(g.TurnOrder))
+	names := make([]string, 0, len(g.TurnOrder))
+	for _, seat := range g.TurnOrder {
+		if p := g.Players[seat]; !p.Eliminated {
+			active = append(active, seat)
+			names = append(names, fmt.Sprintf("%s (%d)", p.Name, p.Money))
+		}
+	}
+	g.addMessage(fmt.Sprintf("Turn order: %s.", strings.Join(names, ", ")))
+	if policy.ByMoney {
+		for _, tie := range turnorder.Ties(active, g.money) {
+			p0, p1 := g.Players[tie[0]], g.Players[tie[1]]
+			was := "ahead of"
+			if p0.TurnOrder > p1.TurnOrder {
+				was = "behind"
+			}
+			g.addMessage(fmt.Sprintf(
+				"%s goes before %s: both have %d, and %s was %s %s in the last turn order.",
+				p0.Name, p1.Name, p0.Money, p0.Name, was, p1.Name))
+		}
 	}
-	g.PhaseOrder = make(PlayerQueue, len(g.Players))
-	copy(g.PhaseOrder, g.TurnOrder)
-
-	// we have to copy the queue and pop everything out of
-	// it to assign turn order to the players.
-	var to = make(PlayerQueue, len(g.Players))
-	copy(to, g.TurnOrder)
 
-	for i, _ := range g.Players {
-		pi := heap.Pop(&to).(*PlayerInfo)
-		pi.player.TurnOrder = i
+	for i, seat := range g.TurnOrder {
+		g.Players[seat].TurnOrder = i
 	}
 }
 
+// money returns the money of the player in the given seat.
+func (g *Game) money(seat int) int {
+	return g.Players[seat].Money
+}
+
 // initPlayers sets up the Players array with initial values.
 func (g *Game) initPlayers(names []string) {
 	g.addMessage("Initializing players...")
@@ -297,21 +492,41 @@ func (g *Game) initPlayers(names []string) {
 		if err != nil {
 			panic(err)
 		}
+		factories := g.Config.startingFactories(len(names))
 		p := &Player{
-			ID:           id,
-			Name:         name,
-			Factories:    make([]Factory, 1),
-			Money:        12,
-			ChatMessages: Queue{Capacity: 500},
-			TurnOrder:    i}
+			ID:        id,
+			Name:      name,
+			Factories: make([]Factory, len(factories)),
+			TurnOrder: i}
 
-		p.Factories[0] = Factory{Key: "p1", Capacity: 1}
+		for j, key := range factories {
+			p.Factories[j] = Factory{Key: key, Capacity: 1}
+		}
 
 		g.Players[i] = p
+		g.post(p, TxStarting, g.Config.startingMoney(len(names)), "")
 	}
+}
 
-	p := g.Players[0]
-	p.IsCurrent = true
+// indexOf returns the index of player p in g.Players.
+func (g *Game) indexOf(p *Player) int {
+	for i, p0 := range g.Players {
+		if p0 == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// purchasingPower returns the most player p could pay, including what they
+// could borrow.
+func (g *Game) purchasingPower(p *Player) int {
+	power := p.Money
+	if g.canBorrow(p) {
+		r := g.Config.Insolvency
+		power += (r.MaxLoans - p.Loans) * r.LoanAmount
+	}
+	return power
 }
 
 // getCurrentPlayer returns the current player.
@@ -334,7 +549,8 @@ func (g *Game) getStartPlayer() *Player {
 	panic("No start player!")
 }
 
-// setCurrentPlayer makes player p the active player.
+// setCurrentPlayer makes player p the active player, and notifies p if the
+// game is being played asynchronously.
 func (g *Game) setCurrentPlayer(p *Player) {
 	for i, p0 := range g.Players {
 		if p0 == p {
@@ -344,69 +560,63 @@ func (g *Game) setCurrentPlayer(p *Player) {
 			p0.IsCurrent = false
 		}
 	}
+	g.notifyTurn(p)
 }
 
 // rollDie rolls a Die and makes it visible.
-func rollDie() Die {
-	return Die{Pips: rand.Intn(6) + 1, Render: true}
+func (g *Game) rollDie() Die {
+	return Die{Pips: g.rng.Intn(6) + 1, Render: true}
 }
 
-// loadLocos loads (and unmarshals) Locos from their JSON representation.
-func (g *Game) loadLocos() {
-	path := filepath.Join(rootPath, LocosJsonPath)
-	result, err := ioutil.ReadFile(path)
+// loadLocos loads the Locos from the game's catalog.
+func (g *Game) loadLocos() error {
+	path, err := catalogPath(g.Config.Catalog)
 	if err != nil {
-		var pwd string
-		pwd, err = os.Getwd()
-		msg := fmt.Sprintf("reading file (pwd: %s, path: %s) failed", pwd, path)
-		panic(msg)
+		return err
+	}
+	c, err := LoadCatalog(path)
+	if err != nil {
+		return err
 	}
 
-	var locos []Loco
-	err = json.Unmarshal(result, &locos)
-	g.Locos = make([]*Loco, len(locos))
+	g.Locos = make([]*Loco, len(c.Locos))
 	g.LocoMap = make(map[string]*Loco)
-	for i, _ := range locos {
-		g.Locos[i] = &locos[i]
+	for i, _ := range c.Locos {
+		g.Locos[i] = &c.Locos[i]
 		g.LocoMap[g.Locos[i].Key] = g.Locos[i]
 	}
-	if err != nil {
-		panic(err)
-	}
+	return nil
 }
 
 // prepareLocos assigns some values that are implicit in the data but it's
-// useful to precompute:  key, upgradeTo, upgradeCost.
+// useful to precompute:  upgradeTo, upgradeCost.  It also sets up the
+// rows of dice, and rolls the dice that g.Config calls for.
 func (g *Game) prepareLocos() {
 	g.addMessage("Preparing locomotives...")
-	prefixes := make(map[string]string, 5)
-	prefixes["passenger"] = "p"
-	prefixes["fast"] = "a"
-	prefixes["freight"] = "g"
-	prefixes["special"] = "s"
 
 	for i, loco := range g.Locos {
-		key := fmt.Sprintf("%s%d", prefixes[loco.Kind], loco.Generation)
-		g.Locos[i].Key = key
 		g.Locos[i].InitialOrders.Render = true
-		g.Locos[i].ExistingOrders = make([]Die, 5)
-		g.Locos[i].CustomerBase = make([]Die, 5)
-		for j := 0; j < 5; j++ {
+		g.Locos[i].ExistingOrders = make([]Die, MaxDice)
+		g.Locos[i].CustomerBase = make([]Die, MaxDice)
+		for j := 0; j < MaxDice; j++ {
 			g.Locos[i].ExistingOrders[j] = Die{Render: j < loco.MaxExistingOrders}
 			g.Locos[i].CustomerBase[j] = Die{Render: j < loco.MaxCustomerBase}
 		}
 		nextLoco := g.findUpgrade(loco)
 		if nextLoco != nil {
-			key = fmt.Sprintf("%s%d", prefixes[loco.Kind], loco.Generation+1)
-			g.Locos[i].UpgradeTo = key
+			g.Locos[i].UpgradeTo = nextLoco.Key
 			g.Locos[i].UpgradeCost = nextLoco.ProductionCost - loco.ProductionCost
 		}
 	}
-	g.Locos[0].ExistingOrders[0] = rollDie()
-	g.Locos[0].ExistingOrders[1] = rollDie()
-	g.Locos[0].ExistingOrders[2] = rollDie()
-
-	g.Locos[1].InitialOrders = rollDie()
+	for _, key := range g.Config.existingOrdersKeys() {
+		loco := g.LocoMap[key]
+		for j := 0; j < g.Config.ExistingOrders[key]; j++ {
+			loco.ExistingOrders[j] = g.rollDie()
+		}
+	}
+	for _, key := range g.Config.InitialOrders {
+		g.LocoMap[key].InitialOrders = g.rollDie()
+	}
 }
 
 // findUpgrade finds the Loco (if any) to upgrade oldLoco to.
@@ -431,6 +641,7 @@ func (g *Game) getActionsJson() []byte {
 
 // getGameJson marshals the Game into a JSON byte slice.
 func (g *Game) getGameJson() []byte {
+	g.updateTimeRemaining()
 	b, err := json.Marshal(g)
 	if err != nil {
 		panic(err)
@@ -439,8 +650,35 @@ func (g *Game) getGameJson() []byte {
 	return b
 }
 
-func (g *Game) getGameStateJson() []byte {
-	s := &GameState{Game: g, Actions: g.getActions()}
+// redactedFor returns a copy of the game in which every player but the one
+// with the given ID has lost their ID.  The copy shares everything but the
+// players with the game, so it's only good for returning to a client.
+func (g *Game) redactedFor(playerID string) *Game {
+	g.updateTimeRemaining()
+	v := *g
+	v.Players = make([]*Player, len(g.Players))
+	for i, p := range g.Players {
+		c := *p
+		if c.ID != playerID {
+			c.ID = ""
+		}
+		v.Players[i] = &c
+	}
+	return &v
+}
+
+// getGameJsonFor marshals the Game, as the player with the given ID may see
+// it, into a JSON byte slice.
+func (g *Game) getGameJsonFor(playerID string) []byte {
+	b, err := json.Marshal(g.redactedFor(playerID))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func (g *Game) getGameStateJson(playerID string) []byte {
+	s := &GameState{Game: g.redactedFor(playerID), Actions: g.getActions()}
 	b, err := json.Marshal(s)
 	if err != nil {
 		panic(err)
@@ -458,9 +696,10 @@ func (g *Game) getLocoJson() []byte {
 	return b
 }
 
-// getPlayersJson marshals the Players into a JSON byte slice.
-func (g *Game) getPlayersJson() []byte {
-	b, err := json.Marshal(g.Players)
+// getPlayersJson marshals the Players, as the player with the given ID may
+// see them, into a JSON byte slice.
+func (g *Game) getPlayersJson(playerID string) []byte {
+	b, err := json.Marshal(g.redactedFor(playerID).Players)
 	if err != nil {
 		panic(err)
 	}
@@ -468,9 +707,15 @@ func (g *Game) getPlayersJson() []byte {
 	return b
 }
 
-// getPlayersJson marshals the next Message into a JSON byte slice
+// getMessageJson marshals the next Message into a JSON byte slice
 func (g *Game) getMessageJson() []byte {
-	e := g.Messages.Pop()
+	return popMessageJson(&g.Messages)
+}
+
+// popMessageJson pops the next Message from a queue and marshals it into a
+// JSON byte slice.
+func popMessageJson(q *Queue) []byte {
+	e := q.Pop()
 	if e == nil {
 		return nil
 	}
@@ -483,16 +728,13 @@ func (g *Game) getMessageJson() []byte {
 	return b
 }
 
-// addMessage adds a new message to the game's queue.
+// addMessage adds a new message to the game's queue, its log and each
+// spectator's queue.
 func (g *Game) addMessage(text string) {
 	g.Messages.PushMessage(text)
-}
-
-// addChatMessage adds a chat message to each player's queue.
-func (g *Game) addChatMessage(player *Player, text string) {
-	c := ChatMessage{Player: player, Text: text}
-	for _, p := range g.Players {
-		p.ChatMessages.Push(c)
+	g.Log = append(g.Log, text)
+	for _, s := range g.Spectators {
+		s.Messages.PushMessage(text)
 	}
 }
 
@@ -504,22 +746,15 @@ func (g *Game) getMessage() string {
 	return g.Messages.PopMessage()
 }
 
-// getChatMessage gets the next chat message from the player's queue, or nil.
-func (g *Game) getChatMessage(player *Player) *ChatMessage {
-	e := player.ChatMessages.Pop()
-	if e == nil {
-		return nil
-	}
-	c := e.Value.(ChatMessage)
-	return &c
-}
-
-// pushChatMessage pushes a message from a player into all players' queues.
-func (g *Game) pushChatMessage(player *Player, text string) {
-	m := ChatMessage{Player: player, Text: text}
+// getPlayerByName returns the player with the given name, ignoring case,
+// or nil if there isn't one.
+func (g *Game) getPlayerByName(name string) *Player {
 	for _, p := range g.Players {
-		p.ChatMessages.Push(m)
+		if strings.EqualFold(p.Name, name) {
+			return p
+		}
 	}
+	return nil
 }
 
 // getPlayer returns the player with the specified ID
@@ -529,43 +764,157 @@ func (g *Game) getPlayer(id string) (*Player, error) {
 			return p, nil
 		}
 	}
-	return nil, errors.New(
-		fmt.Sprintf("Unknown player ID: %s", id))
+	return nil, apiError(http.StatusNotFound, "player_not_found", "Unknown player ID: %s", id)
 }
 
 var Games = make(map[string]*Game)
 
-	// Start is used to initialize an instance of the engine for a new game.
-	// It is expected (though not required) that the engine will maintain its
-	// own copies of the game's ID, Name, and Players.  The seed is used to
-	// initialize the random number generator.
+// gamesMutex guards Games and the state of every game in it.  Requests that
+// may touch a game hold it throughout (see lockGames), as do the reminders,
+// the metrics and the shutdown, which run alongside them.
+var gamesMutex sync.Mutex
+
+// lockGames returns a handler that holds gamesMutex while h runs.
+func lockGames(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gamesMutex.Lock()
+		defer gamesMutex.Unlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// newEngine returns a new Game to use as a gamework.GameEngine.
+func newEngine() gamework.GameEngine {
+	return new(Game)
+}
+
+// gameworkGame returns the gamework representation of the game, which can
+// be replayed with a new engine.
+func (g *Game) gameworkGame() gamework.Game {
+	players := make([]gamework.Player, len(g.Players))
+	for i, p := range g.Players {
+		players[i] = gamework.Player{Id: p.ID, Name: p.Name, UserId: p.UserID}
+	}
+	config, err := json.Marshal(g.Config)
+	if err != nil {
+		panic(err)
+	}
+	return gamework.Game{
+		Id:      g.ID,
+		Name:    g.Name,
+		Players: players,
+		Seed:    g.Seed,
+		Config:  &config,
+		Actions: g.History}
+}
+
+// gameworkState returns the game's current gamework.GameState.
+func (g *Game) gameworkState(outcome gamework.Event) gamework.GameState {
+	p := g.getCurrentPlayer()
+	actions := g.getActions().Actions
+	options := make([]gamework.Option, len(actions))
+	for i, a := range actions {
+		options[i] = gamework.Option{
+			Abbr: a.Abbr,
+			Text: strings.TrimSpace(a.Verb + " " + a.Noun)}
+	}
+	return gamework.GameState{
+		Outcome:          outcome,
+		ActingPlayer:     gamework.Player{Id: p.ID, Name: p.Name, UserId: p.UserID},
+		AvailableOptions: options}
+}
+
+// CheckPlayers returns an error if the game's configuration doesn't allow
+// that many players.
+func (g *Game) CheckPlayers(players []gamework.Player) error {
+	config := g.Config
+	if config == nil {
+		config = DefaultGameConfig()
+	}
+	return config.checkPlayerCount(len(players))
+}
+
+// Start is used to initialize an instance of the engine for a new game.
+// It is expected (though not required) that the engine will maintain its
+// own copies of the game's ID, Name, and Players.  The seed is used to
+// initialize the random number generator.  If the game can't be set up,
+// the state has no options, and its Outcome is the error.
 func (g *Game) Start(
-		id string, name string, players []gamework.Player, seed int) gamework.GameState {
+	id string, name string, players []gamework.Player, seed int) gamework.GameState {
 
+	if err := g.start(id, name, players, seed); err != nil {
+		return gamework.GameState{Outcome: gamework.Event{Abbr: "error", Text: err.Error()}}
+	}
+	return g.gameworkState(gamework.Event{Abbr: "start", Text: g.Log[0]})
+}
+
+// start sets up the game for Start, returning an error if the game's
+// configuration doesn't allow it to be played by the players.
+func (g *Game) start(id string, name string, players []gamework.Player, seed int) error {
+	if err := g.CheckPlayers(players); err != nil {
+		return err
+	}
+	names := make([]string, len(players))
+	for i, p := range players {
+		names[i] = p.Name
+	}
+	if g.Config == nil {
+		g.Config = DefaultGameConfig()
+	}
+	g.Config.Seed = seed
+	if err := g.setup(id, name, names); err != nil {
+		return err
+	}
+	for i, p := range players {
+		g.Players[i].ID = p.Id
+		g.Players[i].UserID = p.UserId
+		if users != nil {
+			if u := users.LookupById(p.UserId); u != nil {
+				g.Players[i].linkProfile(u)
+			}
+		}
+	}
+	return nil
 }
 
 // HandleAction is used to handle an action taken by the active player;
-// it returns the game's new state.
+// it returns the game's new state.  The Outcome's text is made up of the
+// messages that the action added to the game.
 func (g *Game) HandleAction(action gamework.Action) gamework.GameState {
-
+	p := g.getCurrentPlayer()
+	if action.PlayerId != "" {
+		var err error
+		if p, err = g.getPlayer(action.PlayerId); err != nil {
+			return g.gameworkState(gamework.Event{Abbr: "error", Text: err.Error()})
+		}
+	}
+	before := len(g.Log)
+	if err := g.performAction(p, action.Abbr); err != nil {
+		return g.gameworkState(gamework.Event{Abbr: "error", Text: err.Error()})
+	}
+	text := strings.Join(g.Log[before:], " ")
+	return g.gameworkState(gamework.Event{Abbr: action.Abbr, Text: text})
 }
 
 // RefreshClient is used to refresh the client with the game, typically
 // when the user hits F5 or reconnects to the server.  The string it returns
 // is a JSON payload that is sent to the client.
 func (g *Game) RefreshClient(playerId string) string {
-	return "RefreshClient: not implemented."
+	return string(g.getGameJsonFor(playerId))
 }
 
 // Debug is another out-of-band interaction, used to dump debug information
 // to the console or browser.
 func (g *Game) Debug() string {
-	return "Debug:  not implemented."
+	return string(g.getGameJson())
 }
 
 // Equals is used primarily in testing:  it should return false if the two
 // engines aren't the same type, or if their internal states are unequal.
 func (g *Game) Equals(e gamework.GameEngine) bool {
-		g1 := e.(*Game)
-		return true
+	g1, ok := e.(*Game)
+	if !ok {
+		return false
+	}
+	return g.Seed == g1.Seed && string(g.getGameJson()) == string(g1.getGameJson())
 }
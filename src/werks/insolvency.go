@@ -0,0 +1,127 @@
+package werks
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// InsolvencyRule decides what happens when a player can't pay for
+// something.  If Kind is "loans", players can borrow LoanAmount at a time, up
+// to MaxLoans loans, paying InterestPercent of what they owe at the start of
+// each turn; a player who has to pay more than they have borrows what they
+// need, and is eliminated if they can't borrow enough.  If Kind is empty,
+// there are no loans, and a charge a player can't pay is refused.
+//
+// An eliminated player's factories go back to the market, and they take no
+// more turns.
+type InsolvencyRule struct {
+	Kind            string `json:"kind"`
+	LoanAmount      int    `json:"loanAmount"`
+	InterestPercent int    `json:"interestPercent"`
+	MaxLoans        int    `json:"maxLoans"`
+}
+
+// validate checks that the rule is usable.
+func (r *InsolvencyRule) validate() error {
+	switch r.Kind {
+	case "":
+	case "loans":
+		if r.LoanAmount < 1 || r.MaxLoans < 1 {
+			return errors.New("Loans need a positive loanAmount and maxLoans.")
+		}
+		if r.InterestPercent < 0 {
+			return errors.New("interestPercent can't be negative.")
+		}
+	default:
+		return fmt.Errorf("Unknown insolvency rule: %s", r.Kind)
+	}
+	return nil
+}
+
+// canBorrow returns whether player p can take out another loan.
+func (g *Game) canBorrow(p *Player) bool {
+	r := g.Config.Insolvency
+	return r.Kind == "loans" && p.Loans < r.MaxLoans
+}
+
+// borrow gives player p a loan.
+func (g *Game) borrow(p *Player) {
+	p.Loans++
+	g.post(p, TxLoan, g.Config.Insolvency.LoanAmount, "")
+	g.addMessage(fmt.Sprintf("%s borrowed %d.", p.Name, g.Config.Insolvency.LoanAmount))
+}
+
+// debt returns what player p owes.
+func (g *Game) debt(p *Player) int {
+	return p.Loans * g.Config.Insolvency.LoanAmount
+}
+
+// charge makes player p pay amount, applying the game's InsolvencyRule if
+// p can't afford it.  It returns false if p didn't pay.  The actions of each
+// phase make their payments through here, so that no player's Money can go
+// negative.
+func (g *Game) charge(p *Player, kind TransactionKind, amount int, note string) bool {
+	for p.Money < amount && g.canBorrow(p) {
+		g.borrow(p)
+	}
+	if p.Money < amount {
+		if g.Config.Insolvency.Kind != "" {
+			g.eliminate(p)
+		}
+		return false
+	}
+	g.post(p, kind, -amount, note)
+	return true
+}
+
+// chargeInterest makes each player pay the interest on their loans.
+func (g *Game) chargeInterest() {
+	for _, p := range g.Players {
+		if p.Eliminated || p.Loans == 0 {
+			continue
+		}
+		interest := (g.debt(p)*g.Config.Insolvency.InterestPercent + 99) / 100
+		if interest > 0 && g.charge(p, TxInterest, interest, "") {
+			g.addMessage(fmt.Sprintf("%s paid %d interest.", p.Name, interest))
+		}
+	}
+}
+
+// eliminate takes player p out of the game, returning their factories to
+// the market.
+func (g *Game) eliminate(p *Player) {
+	p.Eliminated = true
+	p.Factories = make([]Factory, 0)
+	g.addMessage(fmt.Sprintf("%s is bankrupt, and has been eliminated.", p.Name))
+}
+
+// activePlayers returns the players who haven't been eliminated.
+func (g *Game) activePlayers() []*Player {
+	active := make([]*Player, 0, len(g.Players))
+	for _, p := range g.Players {
+		if !p.Eliminated {
+			active = append(active, p)
+		}
+	}
+	return active
+}
+
+// score returns player p's score at the end of the game:  their money, less
+// what they owe.
+func (g *Game) score(p *Player) int {
+	return p.Money - g.debt(p)
+}
+
+// scoreMessage describes the final scores.
+func (g *Game) scoreMessage() string {
+	scores := make([]string, len(g.Players))
+	for i, p := range g.Players {
+		if p.Eliminated {
+			scores[i] = fmt.Sprintf("%s eliminated", p.Name)
+		} else {
+			scores[i] = fmt.Sprintf("%s %d", p.Name, g.score(p))
+		}
+	}
+	return fmt.Sprintf("Final scores: %s.", strings.Join(scores, ", "))
+}
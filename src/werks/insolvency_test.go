@@ -0,0 +1,122 @@
+package werks
+
+import (
+	"strings"
+	"testing"
+)
+
+// newInsolvencyGame returns a two-player game using the given insolvency
+// rule, in which each player starts with 4 money.
+func newInsolvencyGame(t *testing.T, rule InsolvencyRule) *Game {
+	LocosJsonPath = "../../json/locos.json"
+	config := DefaultGameConfig()
+	money := 4
+	config.StartingMoney = &money
+	config.Insolvency = rule
+	g, err := makeNewGame("insolvency", []string{"Abel", "Baker"}, config)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return g
+}
+
+var loans = InsolvencyRule{Kind: "loans", LoanAmount: 10, InterestPercent: 10, MaxLoans: 2}
+
+func TestBorrowing(t *testing.T) {
+	g := newInsolvencyGame(t, loans)
+	p := g.getCurrentPlayer()
+	if g.findAction("L") == nil || g.findAction("D:a1") == nil {
+		t.Fatalf("Abel should be able to borrow, and so develop the a1.")
+	}
+	if err := g.performAction(p, "L"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if p.Money != 14 || p.Loans != 1 || !p.IsCurrent {
+		t.Errorf("Borrowing should add money and keep the decision: %d, %d", p.Money, p.Loans)
+	}
+
+	// the a1 costs 8, which Abel can now afford without borrowing.
+	if err := g.performAction(p, "D:a1"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if p.Money != 6 || p.Loans != 1 || len(p.Factories) != 2 {
+		t.Errorf("Unexpected state after developing: %d money, %d loans", p.Money, p.Loans)
+	}
+	if err := p.checkLedger(); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestBorrowingToPay(t *testing.T) {
+	g := newInsolvencyGame(t, loans)
+	p := g.getCurrentPlayer()
+	if err := g.performAction(p, "D:a1"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if p.Money != 6 || p.Loans != 1 {
+		t.Errorf("Abel should have borrowed to develop the a1: %d money, %d loans", p.Money, p.Loans)
+	}
+
+	p.Loans = loans.MaxLoans
+	if g.canBorrow(p) {
+		t.Errorf("Abel shouldn't be able to borrow more than %d times.", loans.MaxLoans)
+	}
+}
+
+func TestInterestAndElimination(t *testing.T) {
+	g := newInsolvencyGame(t, loans)
+	abel, baker := g.Players[0], g.Players[1]
+	abel.Loans = 2
+	g.post(abel, TxDevelopment, -4, "")
+
+	// Abel owes 20, so pays 2 interest, and can't borrow any more.
+	for i := 0; i < 3*len(g.Players); i++ {
+		if err := g.performAction(g.getCurrentPlayer(), "P"); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if !abel.Eliminated || len(abel.Factories) != 0 {
+		t.Errorf("Abel should have been eliminated.")
+	}
+	if !g.Over || !baker.IsCurrent {
+		t.Fatalf("The game should be over, with Baker the last player standing.")
+	}
+	log := strings.Join(g.Log, "\n")
+	for _, expected := range []string{
+		"Abel is bankrupt, and has been eliminated.",
+		"Baker is the last player standing.",
+		"Final scores: Abel eliminated, Baker 4.",
+	} {
+		if !strings.Contains(log, expected) {
+			t.Errorf("The log should contain %q:\n%s", expected, log)
+		}
+	}
+}
+
+func TestInterest(t *testing.T) {
+	g := newInsolvencyGame(t, loans)
+	abel := g.Players[0]
+	g.borrow(abel)
+	for i := 0; i < 3*len(g.Players); i++ {
+		if err := g.performAction(g.getCurrentPlayer(), "P"); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if abel.Money != 13 || abel.Eliminated {
+		t.Errorf("Abel should have paid 1 interest, and has %d money.", abel.Money)
+	}
+	if g.score(abel) != 3 {
+		t.Errorf("Abel's score should count the debt, got %d", g.score(abel))
+	}
+}
+
+func TestCharge(t *testing.T) {
+	g := newInsolvencyGame(t, InsolvencyRule{})
+	p := g.Players[0]
+	if g.charge(p, TxProduction, 5, "") || p.Money != 4 || p.Eliminated {
+		t.Errorf("A charge the player can't afford should be refused.")
+	}
+	if g.findAction("L") != nil {
+		t.Errorf("There are no loans without the loans rule.")
+	}
+}
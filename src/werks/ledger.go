@@ -0,0 +1,139 @@
+package werks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// TransactionKind identifies what a Transaction was for.
+type TransactionKind string
+
+const (
+	TxStarting    TransactionKind = "starting"
+	TxDevelopment TransactionKind = "development"
+	TxCapacity    TransactionKind = "capacity"
+	TxProduction  TransactionKind = "production"
+	TxSales       TransactionKind = "sales"
+	TxUpgrade     TransactionKind = "upgrade"
+	TxLoan        TransactionKind = "loan"
+	TxInterest    TransactionKind = "interest"
+)
+
+// Transaction is one entry in a player's ledger.  Amount is positive for
+// income and negative for expenses, and Balance is the player's money after
+// the transaction.
+type Transaction struct {
+	Kind    TransactionKind `json:"kind"`
+	Amount  int             `json:"amount"`
+	Balance int             `json:"balance"`
+	Turn    int             `json:"turn"`
+	Phase   string          `json:"phase"`
+	Note    string          `json:"note"`
+}
+
+// TurnSummary totals a player's transactions in one turn, e.g. for charting
+// their income over the game.
+type TurnSummary struct {
+	Turn     int `json:"turn"`
+	Income   int `json:"income"`
+	Expenses int `json:"expenses"`
+	Balance  int `json:"balance"`
+}
+
+// PlayerLedger is a player's ledger, as returned by /api/ledger.  Balanced
+// is set if the player's Money matches their ledger.
+type PlayerLedger struct {
+	Name         string        `json:"name"`
+	Money        int           `json:"money"`
+	Balanced     bool          `json:"balanced"`
+	Transactions []Transaction `json:"transactions"`
+	Turns        []TurnSummary `json:"turns"`
+}
+
+// post records a transaction for player p, and changes p's Money by amount.
+// All changes to a player's Money go through here.
+func (g *Game) post(p *Player, kind TransactionKind, amount int, note string) {
+	p.Money += amount
+	p.Ledger = append(p.Ledger, Transaction{
+		Kind:    kind,
+		Amount:  amount,
+		Balance: p.Money,
+		Turn:    g.Turn,
+		Phase:   Phases[g.Phase-1],
+		Note:    note})
+}
+
+// checkLedger returns an error if p's Money doesn't match the total of
+// their ledger.
+func (p *Player) checkLedger() error {
+	total := 0
+	for _, t := range p.Ledger {
+		total += t.Amount
+	}
+	if total != p.Money {
+		return fmt.Errorf("%s has %d money, but their ledger totals %d.",
+			p.Name, p.Money, total)
+	}
+	return nil
+}
+
+// turnSummaries totals p's ledger by turn.
+func (p *Player) turnSummaries() []TurnSummary {
+	turns := make([]TurnSummary, 0)
+	for _, t := range p.Ledger {
+		if len(turns) == 0 || turns[len(turns)-1].Turn != t.Turn {
+			turns = append(turns, TurnSummary{Turn: t.Turn})
+		}
+		s := &turns[len(turns)-1]
+		if t.Amount > 0 {
+			s.Income += t.Amount
+		} else {
+			s.Expenses -= t.Amount
+		}
+		s.Balance = t.Balance
+	}
+	return turns
+}
+
+// getLedger returns p's ledger.
+func (p *Player) getLedger() PlayerLedger {
+	return PlayerLedger{
+		Name:         p.Name,
+		Money:        p.Money,
+		Balanced:     p.checkLedger() == nil,
+		Transactions: p.Ledger,
+		Turns:        p.turnSummaries()}
+}
+
+// apiLedgerHandler returns the ledgers of the players in a game, or, if a
+// player ID is given, just that player's ledger.  Money is public, so anyone
+// can audit anyone's balance.
+func apiLedgerHandler(w http.ResponseWriter, r *http.Request) {
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	var result interface{}
+	if id := r.FormValue("p"); id != "" {
+		p, err := g.getPlayer(id)
+		if err != nil {
+			serveError(w, err)
+			return
+		}
+		result = p.getLedger()
+	} else {
+		ledgers := make([]PlayerLedger, len(g.Players))
+		for i, p := range g.Players {
+			ledgers[i] = p.getLedger()
+		}
+		result = ledgers
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Add("content-type", "application/json")
+	fmt.Fprintf(w, "%s", b)
+}
@@ -0,0 +1,72 @@
+package werks
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLedger(t *testing.T) {
+	g := newGame()
+	p := g.getCurrentPlayer()
+	if err := g.performAction(p, "D:a1"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(p.Ledger) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(p.Ledger))
+	}
+	tx := p.Ledger[1]
+	if tx.Kind != TxDevelopment || tx.Amount != -8 || tx.Balance != 4 ||
+		tx.Turn != 1 || tx.Phase != "Locomotive Development" {
+		t.Errorf("Unexpected transaction: %+v", tx)
+	}
+	for _, p := range g.Players {
+		if err := p.checkLedger(); err != nil {
+			t.Errorf("%s", err)
+		}
+	}
+
+	p.Money += 5
+	if err := p.checkLedger(); err == nil {
+		t.Errorf("Money that bypasses the ledger should be caught.")
+	}
+	p.Money -= 5
+
+	// the starting money is paid out during setup, before turn 1.
+	turns := p.turnSummaries()
+	if len(turns) != 2 || turns[0].Turn != 0 || turns[0].Income != 12 ||
+		turns[1].Turn != 1 || turns[1].Expenses != 8 || turns[1].Balance != 4 {
+		t.Errorf("Unexpected turn summaries: %+v", turns)
+	}
+}
+
+func TestLedgerApi(t *testing.T) {
+	g := newGame()
+	p := g.getCurrentPlayer()
+	if err := g.performAction(p, "D:a1"); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	w := serve(t, apiLedgerHandler, "GET", "/api/ledger?g="+g.ID)
+	var ledgers []PlayerLedger
+	if err := json.Unmarshal(w.Body.Bytes(), &ledgers); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	if len(ledgers) != len(g.Players) {
+		t.Fatalf("Expected %d ledgers, got %d", len(g.Players), len(ledgers))
+	}
+
+	w = serve(t, apiLedgerHandler, "GET", "/api/ledger?g="+g.ID+"&p="+p.ID)
+	var ledger PlayerLedger
+	if err := json.Unmarshal(w.Body.Bytes(), &ledger); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	if ledger.Name != p.Name || !ledger.Balanced || ledger.Money != 4 ||
+		len(ledger.Transactions) != 2 || len(ledger.Turns) != 2 {
+		t.Errorf("Unexpected ledger: %s", w.Body.String())
+	}
+
+	w = serve(t, apiLedgerHandler, "GET", "/api/ledger?g="+g.ID+"&p=nobody")
+	if w.Code != 500 {
+		t.Errorf("An unknown player should be an error, got %d", w.Code)
+	}
+}
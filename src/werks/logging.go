@@ -0,0 +1,186 @@
+package werks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"uuid"
+)
+
+// RequestLog is where requests are logged, one JSON object per line.
+var RequestLog io.Writer = os.Stderr
+
+// AuditLog is where security-relevant events are recorded, one JSON object
+// per line.  The server appends to a file in its data directory.
+var AuditLog io.Writer = io.Discard
+
+// logMutex keeps lines written to the logs from interleaving.
+var logMutex sync.Mutex
+
+// RequestRecord is the line logged for each request.  UserID and GameID
+// are set if the request identified a user, by token, or a game.  Latency
+// is in milliseconds.
+type RequestRecord struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"requestId"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	RemoteAddr string    `json:"remoteAddr"`
+	UserID     string    `json:"userId,omitempty"`
+	GameID     string    `json:"gameId,omitempty"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	Latency    float64   `json:"latencyMs"`
+}
+
+// The kinds of AuditEvent.
+const (
+	AuditLogin          = "login"
+	AuditLoginFailed    = "login_failed"
+	AuditRegister       = "register"
+	AuditRegisterFailed = "register_failed"
+	AuditGameCreated    = "game_created"
+	AuditAdmin          = "admin"
+	AuditLockout        = "lockout"
+	AuditRateLimited    = "rate_limited"
+	AuditRecovered      = "users_recovered"
+)
+
+// AuditEvent is a line in the audit log.  Nickname is the one the user
+// gave, which for a failed login may not belong to any user.
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"`
+	RequestID  string    `json:"requestId,omitempty"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+	UserID     string    `json:"userId,omitempty"`
+	Nickname   string    `json:"nickname,omitempty"`
+	GameID     string    `json:"gameId,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+// writeLogLine writes v to w as a line of JSON.
+func writeLogLine(w io.Writer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	w.Write(append(b, '\n'))
+}
+
+// audit records an event in the audit log.  r is the request that caused
+// it, if there was one.
+func audit(r *http.Request, e AuditEvent) {
+	e.Time = clock.Now()
+	if r != nil {
+		e.RequestID = requestID(r)
+		e.RemoteAddr = remoteIP(r)
+	}
+	writeLogLine(AuditLog, &e)
+}
+
+type requestIDKey struct{}
+
+// requestID returns the ID Log gave the request, or "" if it didn't go
+// through Log.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// remoteIP returns the address the request came from, without the port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusRecorder remembers the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Log gives each request an ID, returned in the X-Request-ID header, and
+// logs a RequestRecord for it to RequestLog.  Unless verbose is set, it
+// leaves out the requests clients poll with.
+func Log(handler http.Handler, verbose bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := clock.Now()
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id, _ = uuid.GenUUID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+		rec := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(rec, r)
+		observeLatency(r.URL.Path, clock.Now().Sub(start))
+
+		if !verbose && !logUrl(r.URL.Path) {
+			return
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		// only look at the form if the handler parsed it, so that
+		// logging doesn't consume a request body.
+		form := r.Form
+		if form == nil {
+			form = r.URL.Query()
+		}
+		record := RequestRecord{
+			Time:       start,
+			RequestID:  id,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RemoteAddr: remoteIP(r),
+			GameID:     form.Get("g"),
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			Latency:    float64(clock.Now().Sub(start)) / float64(time.Millisecond)}
+		if users != nil {
+			if u := users.LookupByToken(form.Get("u")); u != nil {
+				record.UserID = u.Id
+			}
+		}
+		writeLogLine(RequestLog, &record)
+	})
+}
+
+// logUrl returns whether requests for the path should be logged; the ones
+// clients poll with aren't.
+func logUrl(path string) bool {
+	for _, prefix := range []string{"/api/message", "/api/chat", "/api/lobbyChat",
+		"/api/v1/messages", "/api/v1/chat", "/api/v1/lobby/chat"} {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return true
+}
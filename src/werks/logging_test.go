@@ -0,0 +1,144 @@
+package werks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"user"
+)
+
+// useLogBuffers substitutes buffers for RequestLog and AuditLog until the
+// returned function is called.
+func useLogBuffers() (*bytes.Buffer, *bytes.Buffer, func()) {
+	requests, events := new(bytes.Buffer), new(bytes.Buffer)
+	oldRequests, oldEvents := RequestLog, AuditLog
+	RequestLog, AuditLog = requests, events
+	return requests, events, func() { RequestLog, AuditLog = oldRequests, oldEvents }
+}
+
+// readLogLines decodes each line of the log into a new value from newValue.
+func readLogLines(t *testing.T, b *bytes.Buffer, newValue func() interface{}) {
+	scanner := bufio.NewScanner(b)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), newValue()); err != nil {
+			t.Fatalf("%s: %s", scanner.Text(), err)
+		}
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	c, restoreClock := useFakeClock()
+	defer restoreClock()
+	requests, _, restoreLogs := useLogBuffers()
+	defer restoreLogs()
+	users = user.Init("salt", "")
+	u, err := users.Register("logger", "open sesame")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	handler := Log(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Advance(25 * time.Millisecond)
+		if requestID(r) == "" {
+			t.Errorf("The request should have an ID.")
+		}
+		http.Error(w, "Not here.", http.StatusNotFound)
+	}), false)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/game?g=g1&u="+u.Token, nil))
+	r := httptest.NewRequest("GET", "/api/locos", nil)
+	r.Header.Set("X-Request-ID", "given")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/messages?g=g1", nil))
+
+	records := make([]*RequestRecord, 0)
+	readLogLines(t, requests, func() interface{} {
+		records = append(records, new(RequestRecord))
+		return records[len(records)-1]
+	})
+	if len(records) != 2 {
+		t.Fatalf("Polling shouldn't be logged; expected 2 records, got %d", len(records))
+	}
+	first := records[0]
+	if first.RequestID == "" || first.RequestID != w.Header().Get("X-Request-ID") {
+		t.Errorf("The request ID should be returned, got %q and %q", first.RequestID, w.Header().Get("X-Request-ID"))
+	}
+	if first.Method != "GET" || first.Path != "/api/game" || first.GameID != "g1" || first.UserID != u.Id ||
+		first.Status != http.StatusNotFound || first.Latency != 25 || first.Bytes == 0 {
+		t.Errorf("Unexpected record: %+v", first)
+	}
+	if strings.Contains(requests.String(), u.Token) {
+		t.Errorf("The user's token shouldn't be logged.")
+	}
+	if records[1].RequestID != "given" {
+		t.Errorf("The client's request ID should be used, got %q", records[1].RequestID)
+	}
+
+	verbose := Log(http.NotFoundHandler(), true)
+	verbose.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/messages?g=g1", nil))
+	if !strings.Contains(requests.String(), "/api/v1/messages") {
+		t.Errorf("Polling should be logged when verbose.")
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	_, restoreClock := useFakeClock()
+	defer restoreClock()
+	_, events, restoreLogs := useLogBuffers()
+	defer restoreLogs()
+	dir, err := ioutil.TempDir("", "werks")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	users = user.Init("salt", filepath.Join(dir, "users.json"))
+	defer useLimits(DefaultRateLimits())()
+	s := apiServer()
+	defer s.Close()
+
+	form := url.Values{"u": {"auditor"}, "p": {"open sesame"}}
+	var token TokenResponse
+	call(t, s, "POST", "/api/v1/register", form, &token)
+	call(t, s, "POST", "/api/v1/register", form, nil)
+	call(t, s, "POST", "/api/v1/login", form, nil)
+	call(t, s, "POST", "/api/v1/login", url.Values{"u": {"auditor"}, "p": {"wrong"}}, nil)
+	var g Game
+	call(t, s, "POST", "/api/v1/games", url.Values{
+		"name": {"Audited"}, "playerCount": {"2"}, "player0": {"Abel"}, "player1": {"Baker"},
+		"u": {token.Token}}, &g)
+
+	logged := make([]*AuditEvent, 0)
+	readLogLines(t, events, func() interface{} {
+		logged = append(logged, new(AuditEvent))
+		return logged[len(logged)-1]
+	})
+	kinds := []string{AuditRegister, AuditRegisterFailed, AuditLogin, AuditLoginFailed, AuditGameCreated}
+	if len(logged) != len(kinds) {
+		t.Fatalf("Expected %d events, got %d: %s", len(kinds), len(logged), events.String())
+	}
+	for i, e := range logged {
+		if e.Kind != kinds[i] || e.Nickname != "auditor" || e.RemoteAddr != "127.0.0.1" || e.Time.IsZero() {
+			t.Errorf("Expected %s by auditor, got %+v", kinds[i], e)
+		}
+	}
+	if logged[0].UserID == "" || logged[3].UserID != "" {
+		t.Errorf("Only successful events should have a user ID.")
+	}
+	if logged[4].GameID != g.ID || logged[4].Detail != "Audited" {
+		t.Errorf("Expected the game's creation, got %+v", logged[4])
+	}
+	if strings.Contains(events.String(), "open sesame") {
+		t.Errorf("Passwords shouldn't be logged.")
+	}
+}
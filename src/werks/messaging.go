@@ -5,13 +5,6 @@ type TextMessage struct {
 	Text string `json:"text"`
 }
 
-// ChatMessage represents a chat message from a player.  It's not
-// JSON serializable.
-type ChatMessage struct {
-	Player *Player
-	Text   string
-}
-
 // Element is an element in a queue.
 type Element struct {
 	Prev  *Element
@@ -0,0 +1,189 @@
+package werks
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConnectedTimeout is how recently a player must have made a request for
+// their game to count as connected.
+var ConnectedTimeout = 30 * time.Second
+
+// LatencyBuckets are the upper bounds, in seconds, of the buckets of the
+// handler latency histograms.
+var LatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram counts observations in LatencyBuckets.  The last count is for
+// the observations above every bound.
+type histogram struct {
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(LatencyBuckets)+1)
+	}
+	i := sort.SearchFloat64s(LatencyBuckets, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// metrics are the counters the server keeps for /metrics.  Gauges are read
+// from the games when the metrics are requested.
+var metrics = struct {
+	sync.Mutex
+	actions       map[string]int64 // by phase
+	latency       map[string]*histogram
+	loginFailures int64
+}{
+	actions: make(map[string]int64),
+	latency: make(map[string]*histogram)}
+
+// countAction counts an action taken in the phase.
+func countAction(phase Phase) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.actions[Phases[phase-1]]++
+}
+
+// countLoginFailure counts a failed login.
+func countLoginFailure() {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.loginFailures++
+}
+
+// observeLatency records how long a request for the path took.
+func observeLatency(path string, d time.Duration) {
+	handler := handlerLabel(path)
+	metrics.Lock()
+	defer metrics.Unlock()
+	h, ok := metrics.latency[handler]
+	if !ok {
+		h = new(histogram)
+		metrics.latency[handler] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// handlerLabel returns the label for the handler that serves the path:  the
+// path itself for the API and /metrics, and "other" for everything else, so
+// that there's a fixed number of histograms.
+func handlerLabel(path string) string {
+	if _, ok := apiHandlers[path]; ok || path == "/metrics" {
+		return path
+	}
+	if strings.HasPrefix(path, "/api/users/") {
+		return "/api/users/"
+	}
+	if _, ok := apiV1[strings.TrimPrefix(path, "/api/v1/")]; ok && strings.HasPrefix(path, "/api/v1/") {
+		return path
+	}
+	return "other"
+}
+
+// metricsHandler returns the server's metrics in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/plain; version=0.0.4")
+	gamesMutex.Lock()
+	defer gamesMutex.Unlock()
+	writeMetrics(w)
+}
+
+// writeMetrics writes the server's metrics in the Prometheus text format.
+func writeMetrics(w io.Writer) {
+	active, over, connected := 0, 0, 0
+	var queues, chats gaugeSummary
+	now := clock.Now()
+	for _, g := range Games {
+		queues.add(g.Messages.Count)
+		if g.Chat != nil {
+			chats.add(g.Chat.count())
+		}
+		if g.Over {
+			over++
+			continue
+		}
+		active++
+		for _, p := range g.Players {
+			if !p.LastSeen.IsZero() && now.Sub(p.LastSeen) <= ConnectedTimeout {
+				connected++
+			}
+		}
+	}
+
+	writeHeader(w, "werks_games", "gauge", "Games on the server, by whether they're over.")
+	fmt.Fprintf(w, "werks_games{state=\"active\"} %d\n", active)
+	fmt.Fprintf(w, "werks_games{state=\"over\"} %d\n", over)
+	writeHeader(w, "werks_connected_players", "gauge",
+		"Players in active games who have made a request within the connection timeout.")
+	fmt.Fprintf(w, "werks_connected_players %d\n", connected)
+
+	queues.write(w, "werks_message_queue_depth", "Messages waiting in the games' queues")
+	chats.write(w, "werks_chat_messages", "Messages in the games' chat histories")
+
+	metrics.Lock()
+	defer metrics.Unlock()
+	writeHeader(w, "werks_actions_total", "counter", "Actions taken, by phase.")
+	for _, phase := range Phases {
+		fmt.Fprintf(w, "werks_actions_total{phase=%q} %d\n", phase, metrics.actions[phase])
+	}
+	writeHeader(w, "werks_login_failures_total", "counter", "Failed logins.")
+	fmt.Fprintf(w, "werks_login_failures_total %d\n", metrics.loginFailures)
+
+	writeHeader(w, "werks_request_duration_seconds", "histogram", "How long requests took, by handler.")
+	handlers := make([]string, 0, len(metrics.latency))
+	for handler := range metrics.latency {
+		handlers = append(handlers, handler)
+	}
+	sort.Strings(handlers)
+	for _, handler := range handlers {
+		h := metrics.latency[handler]
+		var cumulative int64
+		for i, bound := range LatencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "werks_request_duration_seconds_bucket{handler=%q,le=\"%g\"} %d\n",
+				handler, bound, cumulative)
+		}
+		fmt.Fprintf(w, "werks_request_duration_seconds_bucket{handler=%q,le=\"+Inf\"} %d\n", handler, h.count)
+		fmt.Fprintf(w, "werks_request_duration_seconds_sum{handler=%q} %g\n", handler, h.sum)
+		fmt.Fprintf(w, "werks_request_duration_seconds_count{handler=%q} %d\n", handler, h.count)
+	}
+}
+
+// gaugeSummary aggregates a gauge over the games, so that the metrics don't
+// need a label for each game.
+type gaugeSummary struct {
+	count, sum, max int
+}
+
+func (s *gaugeSummary) add(v int) {
+	s.count++
+	s.sum += v
+	if v > s.max {
+		s.max = v
+	}
+}
+
+// write writes the summary as the name's _count, _sum and _max gauges.
+func (s *gaugeSummary) write(w io.Writer, name, help string) {
+	writeHeader(w, name+"_count", "gauge", help+": the number of games counted.")
+	fmt.Fprintf(w, "%s_count %d\n", name, s.count)
+	writeHeader(w, name+"_sum", "gauge", help+": the total over the games.")
+	fmt.Fprintf(w, "%s_sum %d\n", name, s.sum)
+	writeHeader(w, name+"_max", "gauge", help+": the most in any one game.")
+	fmt.Fprintf(w, "%s_max %d\n", name, s.max)
+}
+
+// writeHeader writes the HELP and TYPE lines for a metric.
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
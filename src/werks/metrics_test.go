@@ -0,0 +1,161 @@
+package werks
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"user"
+)
+
+// metricValue returns the value of the sample with the given name and
+// labels, as written by writeMetrics, or -1 if there isn't one.
+func metricValue(t *testing.T, text, sample string) float64 {
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, sample+" ") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(line, sample+" "), 64)
+			if err != nil {
+				t.Fatalf("%s: %s", line, err)
+			}
+			return v
+		}
+	}
+	return -1
+}
+
+func readMetrics() string {
+	b := new(bytes.Buffer)
+	writeMetrics(b)
+	return b.String()
+}
+
+func TestMetricsGauges(t *testing.T) {
+	c, restoreClock := useFakeClock()
+	defer restoreClock()
+	defer useGamesDir(t)()
+	g := newGame()
+	over := newGame()
+	over.Over = true
+	g.addMessage("Hello")
+	if _, err := g.chat().add(ChatMessage{Text: "Hi"}, g.Players[0].ID); err != nil {
+		t.Fatalf("%s", err)
+	}
+	g.Players[0].LastSeen = c.Now()
+	g.Players[1].LastSeen = c.Now().Add(-2 * ConnectedTimeout)
+	over.Players[0].LastSeen = c.Now()
+	over.addMessage("Bye")
+	over.addMessage("Bye again")
+
+	text := readMetrics()
+	expected := map[string]float64{
+		`werks_games{state="active"}`:                         1,
+		`werks_games{state="over"}`:                           1,
+		`werks_connected_players`:                             1,
+		`werks_message_queue_depth_count`:                     2,
+		`werks_message_queue_depth_sum`:                       float64(g.Messages.Count + over.Messages.Count),
+		`werks_message_queue_depth_max`:                       float64(over.Messages.Count),
+		`werks_chat_messages_count`:                           1,
+		`werks_chat_messages_sum`:                             1,
+		`werks_chat_messages_max`:                             1,
+		`werks_actions_total{phase="Locomotive Development"}`: metricValue(t, text, `werks_actions_total{phase="Locomotive Development"}`),
+	}
+	for sample, v := range expected {
+		if got := metricValue(t, text, sample); got != v || got < 0 {
+			t.Errorf("%s: expected %g, got %g", sample, v, got)
+		}
+	}
+	if g.Messages.Count == 0 || over.Messages.Count <= g.Messages.Count {
+		t.Errorf("The games should have different numbers of messages queued.")
+	}
+	if strings.Contains(text, "game=") {
+		t.Errorf("The metrics shouldn't have a label for each game.")
+	}
+	for _, name := range []string{"werks_games", "werks_actions_total", "werks_request_duration_seconds"} {
+		if !strings.Contains(text, "# TYPE "+name+" ") {
+			t.Errorf("%s should have a TYPE line.", name)
+		}
+	}
+}
+
+func TestReadingAGameCountsAsConnected(t *testing.T) {
+	_, restoreClock := useFakeClock()
+	defer restoreClock()
+	defer useGamesDir(t)()
+	g := newGame()
+
+	serve(t, apiGameHandler, "GET", "/api/game?g="+g.ID+"&p="+g.Players[1].ID)
+	if g.Players[1].LastSeen.IsZero() || !g.Players[0].LastSeen.IsZero() {
+		t.Errorf("Only the player who read the game should be seen.")
+	}
+	if got := metricValue(t, readMetrics(), "werks_connected_players"); got != 1 {
+		t.Errorf("Expected 1 connected player, got %g", got)
+	}
+}
+
+func TestMetricsCounters(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	_, restoreClock := useFakeClock()
+	defer restoreClock()
+	defer useGamesDir(t)()
+	users = user.Init("salt", "")
+	defer useLimits(DefaultRateLimits())()
+	_, _, restoreLogs := useLogBuffers()
+	defer restoreLogs()
+	const development = `werks_actions_total{phase="Locomotive Development"}`
+	const failures = `werks_login_failures_total`
+	before := readMetrics()
+
+	g := newGame()
+	for i := 0; i < 2; i++ {
+		if err := g.playAction(g.getCurrentPlayer(), "P"); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	// replaying isn't counted.
+	if err := g.performAction(g.getCurrentPlayer(), "P"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	mux := http.NewServeMux()
+	registerHandlers(mux, nil)
+	s := httptest.NewServer(Log(mux, false))
+	defer s.Close()
+	call(t, s, "POST", "/api/v1/login", url.Values{"u": {"nobody"}, "p": {"wrong"}}, nil)
+	call(t, s, "GET", "/nonsense", url.Values{}, nil)
+	resp := call(t, s, "GET", "/metrics", url.Values{}, nil)
+	if ct := resp.Header.Get("content-type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected the text format, got %s", ct)
+	}
+	observeLatency("/api/v1/game", 30*time.Millisecond)
+	observeLatency("/api/v1/game", 20*time.Second)
+
+	after := readMetrics()
+	if d := metricValue(t, after, development) - metricValue(t, before, development); d != 2 {
+		t.Errorf("Expected 2 development actions, got %g", d)
+	}
+	if d := metricValue(t, after, failures) - metricValue(t, before, failures); d != 1 {
+		t.Errorf("Expected a failed login, got %g", d)
+	}
+	bucket := func(text, handler, le string) float64 {
+		v := metricValue(t, text, `werks_request_duration_seconds_bucket{handler="`+handler+`",le="`+le+`"}`)
+		if v < 0 {
+			return 0
+		}
+		return v
+	}
+	if bucket(after, "/api/v1/login", "+Inf") < 1 || bucket(after, "other", "+Inf") < 1 {
+		t.Errorf("Requests should be observed by handler:\n%s", after)
+	}
+	if d := bucket(after, "/api/v1/game", "0.025") - bucket(before, "/api/v1/game", "0.025"); d != 0 {
+		t.Errorf("30ms shouldn't be in the 25ms bucket, got %g", d)
+	}
+	if d := bucket(after, "/api/v1/game", "0.05") - bucket(before, "/api/v1/game", "0.05"); d != 1 {
+		t.Errorf("30ms should be in the 50ms bucket, got %g", d)
+	}
+	if d := bucket(after, "/api/v1/game", "+Inf") - bucket(before, "/api/v1/game", "+Inf"); d != 2 {
+		t.Errorf("Both requests should be counted, got %g", d)
+	}
+}
@@ -0,0 +1,168 @@
+package werks
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Notification is a message sent to a user outside of the game, e.g. to tell
+// them that it's their move in an asynchronous game.
+type Notification struct {
+	UserID  string    `json:"userId"`
+	Name    string    `json:"name"`
+	Subject string    `json:"subject"`
+	Text    string    `json:"text"`
+	Time    time.Time `json:"time"`
+}
+
+// Notifier delivers Notifications.  Implementations might send email or push
+// messages; the ones here are local stand-ins.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// LogNotifier writes notifications to the log.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(n Notification) error {
+	log.Printf("Notify %s (%s): %s: %s", n.Name, n.UserID, n.Subject, n.Text)
+	return nil
+}
+
+// OutboxNotifier appends notifications, one JSON object per line, to the
+// file at Path.
+type OutboxNotifier struct {
+	Path string
+}
+
+func (o OutboxNotifier) Notify(n Notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(o.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s\n", b)
+	return err
+}
+
+var notifier Notifier = LogNotifier{}
+
+// StallThreshold is how long an asynchronous game can wait on a player before
+// the player is reminded of it.  Players are reminded at most once per
+// StallThreshold.
+var StallThreshold = 24 * time.Hour
+
+// GameAwaitingMove describes an asynchronous game that's waiting on a user.
+type GameAwaitingMove struct {
+	GameID   string    `json:"gameId"`
+	GameName string    `json:"gameName"`
+	PlayerID string    `json:"playerId"`
+	Phase    string    `json:"phase"`
+	Since    time.Time `json:"since"`
+}
+
+// notifyTurn tells player p that it's their move, if the game is being
+// played asynchronously and p belongs to a user.
+func (g *Game) notifyTurn(p *Player) {
+	if !g.Async || p.UserID == "" {
+		return
+	}
+	n := Notification{
+		UserID:  p.UserID,
+		Name:    p.Name,
+		Subject: fmt.Sprintf("Your move in %s", g.Name),
+		Text:    fmt.Sprintf("It's your turn in %s (%s).", g.Name, Phases[g.Phase-1]),
+		Time:    clock.Now()}
+	if err := notifier.Notify(n); err != nil {
+		log.Printf("Couldn't notify %s: %s", p.Name, err)
+	}
+}
+
+// gamesAwaitingMove returns the unfinished asynchronous games waiting on the
+// user with the given ID, the longest-waiting first.
+func gamesAwaitingMove(userID string) []GameAwaitingMove {
+	result := make([]GameAwaitingMove, 0)
+	for _, g := range Games {
+		if !g.Async || g.Over {
+			continue
+		}
+		p := g.getCurrentPlayer()
+		if p.UserID != userID {
+			continue
+		}
+		result = append(result, GameAwaitingMove{
+			GameID:   g.ID,
+			GameName: g.Name,
+			PlayerID: p.ID,
+			Phase:    Phases[g.Phase-1],
+			Since:    g.DecisionStarted})
+	}
+	sort.Sort(bySince(result))
+	return result
+}
+
+type bySince []GameAwaitingMove
+
+func (s bySince) Len() int           { return len(s) }
+func (s bySince) Less(i, j int) bool { return s[i].Since.Before(s[j].Since) }
+func (s bySince) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// sendReminders sends each user one digest of the unfinished asynchronous
+// games that have been waiting on them for longer than StallThreshold, and
+// that they haven't been reminded of in the last StallThreshold.
+func sendReminders() {
+	now := clock.Now()
+	stalled := make(map[string][]*Game)
+	names := make(map[string]string)
+	for _, g := range Games {
+		if !g.Async || g.Over {
+			continue
+		}
+		p := g.getCurrentPlayer()
+		if p.UserID == "" || now.Sub(g.DecisionStarted) < StallThreshold {
+			continue
+		}
+		if now.Sub(g.RemindedAt) < StallThreshold {
+			continue
+		}
+		stalled[p.UserID] = append(stalled[p.UserID], g)
+		names[p.UserID] = p.Name
+	}
+
+	for userID, games := range stalled {
+		lines := make([]string, len(games))
+		for i, g := range games {
+			lines[i] = fmt.Sprintf("%s has been waiting since %s.",
+				g.Name, g.DecisionStarted.Format(time.RFC1123))
+			g.RemindedAt = now
+		}
+		sort.Strings(lines)
+		n := Notification{
+			UserID:  userID,
+			Name:    names[userID],
+			Subject: fmt.Sprintf("Games waiting for your move (%d)", len(games)),
+			Text:    strings.Join(lines, "\n"),
+			Time:    now}
+		if err := notifier.Notify(n); err != nil {
+			log.Printf("Couldn't send reminders to %s: %s", names[userID], err)
+		}
+	}
+}
+
+// remindPeriodically calls sendReminders every interval, forever.
+func remindPeriodically(interval time.Duration) {
+	for _ = range time.Tick(interval) {
+		gamesMutex.Lock()
+		sendReminders()
+		gamesMutex.Unlock()
+	}
+}
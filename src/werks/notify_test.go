@@ -0,0 +1,155 @@
+package werks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records the notifications it's asked to send.
+type fakeNotifier struct {
+	sent []Notification
+}
+
+func (f *fakeNotifier) Notify(n Notification) error {
+	f.sent = append(f.sent, n)
+	return nil
+}
+
+// useFakeNotifier substitutes a fakeNotifier for the notifier until the
+// returned function is called.
+func useFakeNotifier() (*fakeNotifier, func()) {
+	f := new(fakeNotifier)
+	old := notifier
+	notifier = f
+	return f, func() { notifier = old }
+}
+
+// newAsyncGame creates an asynchronous game whose players are all linked to
+// users whose IDs are their names.
+func newAsyncGame() *Game {
+	g := newGame()
+	g.Async = true
+	for _, p := range g.Players {
+		p.UserID = p.Name
+	}
+	return g
+}
+
+func TestNotifyOnTurn(t *testing.T) {
+	f, restore := useFakeNotifier()
+	defer restore()
+
+	g := newAsyncGame()
+	p := g.getCurrentPlayer()
+	if err := g.performAction(p, "P"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(f.sent) != 1 {
+		t.Fatalf("Expected one notification, got %d", len(f.sent))
+	}
+	if f.sent[0].UserID != g.getCurrentPlayer().UserID {
+		t.Errorf("Notified %s instead of %s", f.sent[0].UserID, g.getCurrentPlayer().UserID)
+	}
+
+	g.Async = false
+	g.performAction(g.getCurrentPlayer(), "P")
+	if len(f.sent) != 1 {
+		t.Errorf("Synchronous games shouldn't send notifications.")
+	}
+}
+
+func TestGamesAwaitingMove(t *testing.T) {
+	Games = make(map[string]*Game)
+	g := newAsyncGame()
+	p := g.getCurrentPlayer()
+
+	awaiting := gamesAwaitingMove(p.UserID)
+	if len(awaiting) != 1 || awaiting[0].GameID != g.ID || awaiting[0].PlayerID != p.ID {
+		t.Errorf("Expected %s to be awaiting %s's move, got %v", g.Name, p.Name, awaiting)
+	}
+	for _, p0 := range g.Players {
+		if p0 != p && len(gamesAwaitingMove(p0.UserID)) != 0 {
+			t.Errorf("%s isn't current, and shouldn't be awaited.", p0.Name)
+		}
+	}
+}
+
+func TestSendReminders(t *testing.T) {
+	c, restoreClock := useFakeClock()
+	defer restoreClock()
+	f, restore := useFakeNotifier()
+	defer restore()
+
+	Games = make(map[string]*Game)
+	g0 := newAsyncGame()
+	g1 := newAsyncGame()
+	g0.startDecision()
+	g1.startDecision()
+
+	c.Advance(StallThreshold / 2)
+	sendReminders()
+	if len(f.sent) != 0 {
+		t.Errorf("Games that haven't stalled shouldn't trigger reminders.")
+	}
+
+	c.Advance(StallThreshold)
+	sendReminders()
+	if len(f.sent) != 1 {
+		t.Fatalf("Expected one digest, got %d", len(f.sent))
+	}
+	if strings.Count(f.sent[0].Text, "\n") != 1 {
+		t.Errorf("The digest should list both games:\n%s", f.sent[0].Text)
+	}
+
+	c.Advance(time.Minute)
+	sendReminders()
+	if len(f.sent) != 1 {
+		t.Errorf("Players shouldn't be reminded again so soon.")
+	}
+}
+
+func TestFinishedGamesArentAwaited(t *testing.T) {
+	c, restoreClock := useFakeClock()
+	defer restoreClock()
+	f, restore := useFakeNotifier()
+	defer restore()
+
+	Games = make(map[string]*Game)
+	g := newAsyncGame()
+	g.startDecision()
+	g.Over = true
+	p := g.getCurrentPlayer()
+	if awaiting := gamesAwaitingMove(p.UserID); len(awaiting) != 0 {
+		t.Errorf("A finished game shouldn't be awaiting a move, got %v", awaiting)
+	}
+	c.Advance(2 * StallThreshold)
+	sendReminders()
+	if len(f.sent) != 0 {
+		t.Errorf("A finished game shouldn't trigger reminders, got %v", f.sent)
+	}
+}
+
+func TestOutboxNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	o := OutboxNotifier{Path: filepath.Join(dir, "outbox")}
+	o.Notify(Notification{Name: "Abel", Subject: "one"})
+	o.Notify(Notification{Name: "Baker", Subject: "two"})
+
+	b, err := ioutil.ReadFile(o.Path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"subject":"two"`) {
+		t.Errorf("Unexpected outbox contents:\n%s", b)
+	}
+}
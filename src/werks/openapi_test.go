@@ -0,0 +1,181 @@
+package werks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"user"
+	"werksclient"
+)
+
+// openAPIDoc is the part of the OpenAPI document the tests check.
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]json.RawMessage `json:"schemas"`
+	} `json:"components"`
+}
+
+func readOpenAPIDoc(t *testing.T) (*openAPIDoc, []byte) {
+	b, err := ioutil.ReadFile("../../doc/openapi.json")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var doc openAPIDoc
+	if err = json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return &doc, b
+}
+
+// TestOpenAPIPaths checks that the document describes exactly the API paths
+// that are registered, and, for /api/v1, exactly the methods each accepts.
+// A documented path with a parameter, such as /api/users/{id}, is served by
+// the handler registered for the path up to the parameter.
+func TestOpenAPIPaths(t *testing.T) {
+	doc, _ := readOpenAPIDoc(t)
+	mux := http.NewServeMux()
+	registerHandlers(mux, DefaultServerConfig().StaticDirs)
+
+	registered := make(map[string]bool)
+	for path := range apiHandlers {
+		registered[path] = true
+	}
+	for path := range apiV1 {
+		registered["/api/v1/"+path] = true
+	}
+	described := make(map[string]bool)
+	for path := range doc.Paths {
+		described[strings.Split(path, "{")[0]] = true
+	}
+	for path := range registered {
+		if !described[path] {
+			t.Errorf("%s isn't documented.", path)
+		}
+	}
+
+	for path, ops := range doc.Paths {
+		prefix := strings.Split(path, "{")[0]
+		r := httptest.NewRequest("GET", path, nil)
+		if _, pattern := mux.Handler(r); pattern != prefix || !registered[prefix] {
+			t.Errorf("%s is documented, but isn't registered.", path)
+			continue
+		}
+		if !strings.HasPrefix(path, "/api/v1/") {
+			continue
+		}
+		documented := make([]string, 0)
+		for method := range ops {
+			documented = append(documented, strings.ToUpper(method))
+		}
+		accepted := make([]string, 0)
+		for method := range apiV1[strings.TrimPrefix(path, "/api/v1/")] {
+			accepted = append(accepted, method)
+		}
+		sort.Strings(documented)
+		sort.Strings(accepted)
+		if strings.Join(documented, " ") != strings.Join(accepted, " ") {
+			t.Errorf("%s: documented %v, but accepts %v", path, documented, accepted)
+		}
+	}
+}
+
+// TestOpenAPIRefs checks that every schema the document refers to is
+// defined.
+func TestOpenAPIRefs(t *testing.T) {
+	doc, b := readOpenAPIDoc(t)
+	const prefix = `"#/components/schemas/`
+	for _, s := range strings.Split(string(b), prefix)[1:] {
+		name := s[:strings.Index(s, `"`)]
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("Schema %s isn't defined.", name)
+		}
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	oldRoot := rootPath
+	rootPath = "../.."
+	defer func() { rootPath = oldRoot }()
+
+	w := serve(t, apiOpenAPIHandler, "GET", "/api/openapi.json")
+	var doc openAPIDoc
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil || len(doc.Paths) == 0 {
+		t.Errorf("Expected the OpenAPI document, got %d %s", w.Code, err)
+	}
+	if ct := w.Header().Get("content-type"); ct != "application/json" {
+		t.Errorf("Expected JSON, got %s", ct)
+	}
+}
+
+// TestClient plays through the API with werksclient.
+func TestClient(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	_, restore := useFakeClock()
+	defer restore()
+	dir, err := ioutil.TempDir("", "werks")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	users = user.Init("salt", filepath.Join(dir, "users.json"))
+	defer useLimits(DefaultRateLimits())()
+	s := apiServer()
+	defer s.Close()
+	c := werksclient.New(s.URL)
+
+	if err = c.Register("bot", "open sesame"); err != nil || c.Token == "" {
+		t.Fatalf("Couldn't register: %v", err)
+	}
+	err = werksclient.New(s.URL).Register("Bot", "open sesame")
+	if e, ok := err.(*werksclient.Error); !ok || e.Code != "invalid_registration" ||
+		len(e.Fields) != 1 || e.Fields[0].Code != user.Taken {
+		t.Errorf("Expected the nickname to be taken, got %v", err)
+	}
+	if err = c.Login("bot", "wrong"); !werksclient.IsCode(err, "invalid_login") {
+		t.Errorf("Expected invalid_login, got %v", err)
+	}
+
+	g, err := c.CreateGame(werksclient.NewGame{Name: "Bots", Players: []string{"Abel", "Baker"}})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	abel := g.Current()
+	if g.Name != "Bots" || len(g.Players) != 2 || abel == nil {
+		t.Fatalf("Unexpected game: %+v", g)
+	}
+	actions, err := c.Actions(g.ID, abel.ID)
+	if err != nil || len(actions.Actions) == 0 {
+		t.Errorf("Abel should have actions, got %+v %v", actions, err)
+	}
+	state, err := c.Act(g.ID, abel.ID, "P")
+	if err != nil || state.Game.Current().Name != "Baker" {
+		t.Errorf("Abel's pass should make it Baker's turn, got %v", err)
+	}
+	if _, err = c.Act(g.ID, abel.ID, "P"); !werksclient.IsCode(err, "not_your_turn") {
+		t.Errorf("Expected not_your_turn, got %v", err)
+	}
+
+	if _, err = c.SendChat(g.ID, abel.ID, "Hello", ""); err != nil {
+		t.Errorf("%s", err)
+	}
+	messages, err := c.Chat(g.ID, g.Players[1].ID, 0)
+	if err != nil || len(messages) != 1 || messages[0].Who != "Abel" {
+		t.Errorf("Expected Abel's message, got %+v %v", messages, err)
+	}
+	if _, err = c.SendLobbyChat("Hello, lobby"); err != nil {
+		t.Errorf("%s", err)
+	}
+	if messages, err = c.LobbyChat(0); err != nil || len(messages) == 0 {
+		t.Errorf("Expected the lobby's messages, got %v", err)
+	}
+	if _, err = c.Game("nonsense"); !werksclient.IsCode(err, "game_not_found") {
+		t.Errorf("Expected game_not_found, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package werks
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"user"
+)
+
+// FavoriteLocoCount is how many loco kinds UserStats.FavoriteLocos lists.
+var FavoriteLocoCount = 3
+
+// UserStats are a user's lifetime statistics, from the games they've
+// finished in which every player was claimed by a user (see claimSeat).
+// AverageMoney is the average of the money they finished with, and
+// FavoriteLocos are the kinds of loco they've most often had factories for
+// at the end of a game, the most frequent first.
+type UserStats struct {
+	GamesPlayed   int      `json:"gamesPlayed"`
+	Wins          int      `json:"wins"`
+	AverageMoney  float64  `json:"averageMoney"`
+	FavoriteLocos []string `json:"favoriteLocos"`
+}
+
+// UserProfile is a user as other users see them.
+type UserProfile struct {
+	ID       string       `json:"id"`
+	Nickname string       `json:"nickname"`
+	Profile  user.Profile `json:"profile"`
+	Stats    UserStats    `json:"stats"`
+}
+
+// linkProfile links the player to the user's profile, so that the profile
+// is shown with the player.
+func (p *Player) linkProfile(u *user.User) {
+	p.UserID = u.Id
+	p.Profile = &u.Profile
+}
+
+// claimSeat links the player to the user, who has shown they're logged in
+// and, by knowing the player's ID, that they're playing the player.  A user
+// can only play one player in a game, and a player can only be claimed
+// once, before the game is over.
+func (g *Game) claimSeat(p *Player, u *user.User) error {
+	if p.UserID == u.Id {
+		return nil
+	}
+	if g.Over {
+		return apiError(http.StatusConflict, "game_over", "The game is over.")
+	}
+	if p.UserID != "" {
+		return apiError(http.StatusConflict, "seat_claimed", "%s is already claimed.", p.Name)
+	}
+	for _, other := range g.Players {
+		if other.UserID == u.Id {
+			return apiError(http.StatusConflict, "already_seated", "You're already playing %s.", other.Name)
+		}
+	}
+	p.linkProfile(u)
+	return nil
+}
+
+// allSeatsClaimed returns whether every player is linked to a user.  Only
+// those games count towards the users' stats, since a user could otherwise
+// play every seat of a game themselves.
+func (g *Game) allSeatsClaimed() bool {
+	for _, p := range g.Players {
+		if p.UserID == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// winners returns the players with the highest score among the ones who
+// weren't eliminated; more than one if they tied.
+func (g *Game) winners() []*Player {
+	winners := make([]*Player, 0)
+	for _, p := range g.activePlayers() {
+		if len(winners) > 0 && g.score(p) < g.score(winners[0]) {
+			continue
+		}
+		if len(winners) > 0 && g.score(p) > g.score(winners[0]) {
+			winners = winners[:0]
+		}
+		winners = append(winners, p)
+	}
+	return winners
+}
+
+// userStats computes the statistics of the user with the given ID from the
+// finished games in which every seat was claimed.
+func userStats(userID string) UserStats {
+	stats := UserStats{FavoriteLocos: make([]string, 0)}
+	money := 0
+	kinds := make(map[string]int)
+	for _, g := range Games {
+		if !g.Over || !g.allSeatsClaimed() {
+			continue
+		}
+		for _, p := range g.Players {
+			if p.UserID != userID {
+				continue
+			}
+			stats.GamesPlayed++
+			money += p.Money
+			for _, w := range g.winners() {
+				if w == p {
+					stats.Wins++
+				}
+			}
+			for _, f := range p.Factories {
+				if l, ok := g.LocoMap[f.Key]; ok {
+					kinds[l.Kind]++
+				}
+			}
+		}
+	}
+	if stats.GamesPlayed > 0 {
+		stats.AverageMoney = float64(money) / float64(stats.GamesPlayed)
+	}
+	for kind := range kinds {
+		stats.FavoriteLocos = append(stats.FavoriteLocos, kind)
+	}
+	sort.Slice(stats.FavoriteLocos, func(i, j int) bool {
+		a, b := stats.FavoriteLocos[i], stats.FavoriteLocos[j]
+		return kinds[a] > kinds[b] || (kinds[a] == kinds[b] && a < b)
+	})
+	if len(stats.FavoriteLocos) > FavoriteLocoCount {
+		stats.FavoriteLocos = stats.FavoriteLocos[:FavoriteLocoCount]
+	}
+	return stats
+}
+
+// apiUsersEndpoint serves /api/users/{id}.  GET returns the user's
+// UserProfile, and POST updates the user's profile, for the user whose
+// token is u, who must be the same user.
+var apiUsersEndpoint = apiEndpoint{"GET": apiGetUser, "POST": apiUpdateProfile}
+
+// getUserFromPath returns the user whose ID ends the request's path.
+func getUserFromPath(r *http.Request) (*user.User, error) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	if u := users.LookupById(id); u != nil {
+		return u, nil
+	}
+	return nil, apiError(http.StatusNotFound, "user_not_found", "Unknown user ID: %s", id)
+}
+
+// getUserProfile returns the user as other users see them.
+func getUserProfile(u *user.User) *UserProfile {
+	return &UserProfile{ID: u.Id, Nickname: u.Nickname, Profile: u.Profile, Stats: userStats(u.Id)}
+}
+
+func apiGetUser(r *http.Request) (int, interface{}, error) {
+	u, err := getUserFromPath(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, getUserProfile(u), nil
+}
+
+func apiUpdateProfile(r *http.Request) (int, interface{}, error) {
+	u, err := getUserFromPath(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if me := userFromRequest(r); me == nil {
+		return 0, nil, errUnknownToken
+	} else if me != u {
+		return 0, nil, apiError(http.StatusForbidden, "not_your_profile", "You can only change your own profile.")
+	}
+	p := user.Profile{
+		DisplayName: r.FormValue("displayName"),
+		AvatarURL:   r.FormValue("avatarUrl"),
+		Colors:      splitList(r.FormValue("colors")),
+		Bio:         r.FormValue("bio")}
+	if err = users.UpdateProfile(u, p); err != nil {
+		e := asAPIError(err)
+		if e.Fields != nil {
+			e.Code = "invalid_profile"
+		}
+		return 0, nil, e
+	}
+	if err = users.SaveUsers(); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, getUserProfile(u), nil
+}
@@ -0,0 +1,225 @@
+package werks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"user"
+)
+
+// registerPlayers registers users for Abel, Baker and Charlie.
+func registerPlayers(t *testing.T) []*user.User {
+	result := make([]*user.User, 0)
+	for _, name := range []string{"Abel", "Baker", "Charlie"} {
+		u, err := users.Register(name, "open sesame")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		result = append(result, u)
+	}
+	return result
+}
+
+// finishGame ends a game of Abel, Baker and Charlie with the given final
+// money, and gives each player a factory for the loco at the same index.
+// Each player is claimed by the user at the same index, unless it's nil.
+func finishGame(t *testing.T, money []int, locos []int, seated []*user.User) *Game {
+	g := newGame()
+	for i, u := range seated {
+		if u == nil {
+			continue
+		}
+		if err := g.claimSeat(g.Players[i], u); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	for i, p := range g.Players {
+		p.Money = money[i]
+		p.Factories = []Factory{{Key: g.Locos[locos[i]].Key, Capacity: 1}}
+	}
+	g.Over = true
+	return g
+}
+
+func TestUserStats(t *testing.T) {
+	_, restoreClock := useFakeClock()
+	defer restoreClock()
+	defer useGamesDir(t)()
+	users = user.Init("salt", "")
+	seated := registerPlayers(t)
+	abel := seated[0]
+
+	g1 := finishGame(t, []int{50, 30, 20}, []int{0, 1, 1}, seated)
+	finishGame(t, []int{10, 40, 20}, []int{0, 1, 1}, seated)
+	tied := finishGame(t, []int{40, 40, 20}, []int{1, 0, 0}, seated)
+	running := newGame()
+	running.claimSeat(running.Players[0], abel)
+	running.Players[0].Money = 1000
+	// a game whose other seats weren't claimed could have been played by
+	// Abel alone.
+	finishGame(t, []int{90, 0, 0}, []int{0, 1, 1}, []*user.User{abel})
+
+	stats := userStats(abel.Id)
+	if stats.GamesPlayed != 3 || stats.Wins != 2 || stats.AverageMoney != float64(50+10+40)/3 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	favorite, second := g1.Locos[0].Kind, tied.Locos[1].Kind
+	if favorite != second && (len(stats.FavoriteLocos) != 2 || stats.FavoriteLocos[0] != favorite ||
+		stats.FavoriteLocos[1] != second) {
+		t.Errorf("Expected %s then %s, got %v", favorite, second, stats.FavoriteLocos)
+	}
+	if stats = userStats("nobody"); stats.GamesPlayed != 0 || stats.AverageMoney != 0 ||
+		stats.FavoriteLocos == nil {
+		t.Errorf("Expected no stats, got %+v", stats)
+	}
+}
+
+func TestPlayerProfile(t *testing.T) {
+	defer useGamesDir(t)()
+	users = user.Init("salt", "")
+	abel, err := users.Register("Abel", "open sesame")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	g := newGame()
+	if err = g.claimSeat(g.Players[0], abel); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err = users.UpdateProfile(abel, user.Profile{DisplayName: "Able Abel"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	var decoded Game
+	if err = json.Unmarshal(g.getGameJson(), &decoded); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if p := decoded.Players[0].Profile; p == nil || p.DisplayName != "Able Abel" {
+		t.Errorf("Abel's profile should be shown, got %+v", p)
+	}
+	if decoded.Players[1].Profile != nil {
+		t.Errorf("Baker isn't a user, so has no profile.")
+	}
+
+	// the profile is linked again when the game is restored.
+	if err = g.save(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	delete(Games, g.ID)
+	if err = loadGames(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if p := Games[g.ID].Players[0].Profile; p != &abel.Profile {
+		t.Errorf("The restored game should show Abel's profile, got %+v", p)
+	}
+}
+
+func TestAPIUserProfile(t *testing.T) {
+	_, restoreClock := useFakeClock()
+	defer restoreClock()
+	defer useGamesDir(t)()
+	dir, err := ioutil.TempDir("", "werks")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	users = user.Init("salt", filepath.Join(dir, "users.json"))
+	seated := registerPlayers(t)
+	abel, baker := seated[0], seated[1]
+	finishGame(t, []int{50, 30, 20}, []int{0, 1, 1}, seated)
+	mux := http.NewServeMux()
+	registerHandlers(mux, nil)
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	path := "/api/users/" + abel.Id
+
+	var profile UserProfile
+	resp := call(t, s, "GET", path, url.Values{}, &profile)
+	if resp.StatusCode != http.StatusOK || profile.ID != abel.Id || profile.Nickname != "Abel" ||
+		profile.Stats.GamesPlayed != 1 || profile.Stats.Wins != 1 {
+		t.Errorf("Expected Abel's profile, got %d %+v", resp.StatusCode, profile)
+	}
+	if strings.Contains(resp.Header.Get("content-type"), "text") {
+		t.Errorf("Expected JSON, got %s", resp.Header.Get("content-type"))
+	}
+	expectError(t, s, "GET", "/api/users/nonsense", url.Values{}, http.StatusNotFound, "user_not_found")
+
+	form := url.Values{"displayName": {"Able Abel"}, "colors": {"#C0FFEE, #000000"}, "bio": {"Builds locos."}}
+	expectError(t, s, "POST", path, form, http.StatusUnauthorized, "unknown_token")
+	form.Set("u", baker.Token)
+	expectError(t, s, "POST", path, form, http.StatusForbidden, "not_your_profile")
+	form.Set("u", abel.Token)
+	form.Set("avatarUrl", "ftp://example.com/abel.png")
+	var body ErrorResponse
+	call(t, s, "POST", path, form, &body)
+	if body.Error == nil || body.Error.Code != "invalid_profile" || len(body.Error.Fields) != 1 ||
+		body.Error.Fields[0].Field != "avatarUrl" {
+		t.Errorf("Expected the avatar URL to be refused, got %+v", body.Error)
+	}
+
+	form.Set("avatarUrl", "https://example.com/abel.png")
+	resp = call(t, s, "POST", path, form, &profile)
+	if resp.StatusCode != http.StatusOK || profile.Profile.DisplayName != "Able Abel" ||
+		strings.Join(profile.Profile.Colors, " ") != "#c0ffee #000000" {
+		t.Errorf("Expected the new profile, got %d %+v", resp.StatusCode, profile.Profile)
+	}
+	saved := user.Init("salt", filepath.Join(dir, "users.json"))
+	if err = saved.LoadUsers(); err != nil || saved.LookupById(abel.Id).Profile.Bio != "Builds locos." {
+		t.Errorf("The profile should have been saved: %v", err)
+	}
+}
+
+func TestAPIClaimSeat(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	defer useGamesDir(t)()
+	users = user.Init("salt", "")
+	seated := registerPlayers(t)
+	abel, baker := seated[0], seated[1]
+	s := apiServer()
+	defer s.Close()
+
+	// the creator can claim a seat, but only while logged in.
+	form := url.Values{
+		"name":        {"Claimed"},
+		"playerCount": {"2"},
+		"player0":     {"Abel"},
+		"player1":     {"Baker"},
+		"seat":        {"0"}}
+	expectError(t, s, "POST", "/api/v1/games", form, http.StatusUnauthorized, "unknown_token")
+	form.Set("u", abel.Token)
+	form.Set("seat", "2")
+	expectError(t, s, "POST", "/api/v1/games", form, http.StatusBadRequest, "bad_request")
+	form.Set("seat", "0")
+	var g Game
+	call(t, s, "POST", "/api/v1/games", form, &g)
+	if len(g.Players) != 2 || g.Players[0].UserID != abel.Id || g.Players[1].UserID != "" {
+		t.Fatalf("Abel should have claimed the first seat, got %+v", g.Players)
+	}
+	if len(Games) != 1 {
+		t.Errorf("The games that failed to claim a seat shouldn't be kept, got %d games", len(Games))
+	}
+
+	// the other player claims their seat with its ID.
+	claim := url.Values{"g": {g.ID}, "p": {g.Players[1].ID}}
+	expectError(t, s, "POST", "/api/v1/seat", claim, http.StatusUnauthorized, "unknown_token")
+	claim.Set("u", abel.Token)
+	expectError(t, s, "POST", "/api/v1/seat", claim, http.StatusConflict, "already_seated")
+	claim.Set("u", baker.Token)
+	claim.Set("p", "nonsense")
+	expectError(t, s, "POST", "/api/v1/seat", claim, http.StatusNotFound, "player_not_found")
+	claim.Set("p", g.Players[1].ID)
+	var claimed Game
+	resp := call(t, s, "POST", "/api/v1/seat", claim, &claimed)
+	if resp.StatusCode != http.StatusOK || claimed.Players[1].UserID != baker.Id || claimed.Players[0].ID != "" {
+		t.Errorf("Baker should have claimed the second seat, got %d %+v", resp.StatusCode, claimed.Players)
+	}
+	claim.Set("u", seated[2].Token)
+	expectError(t, s, "POST", "/api/v1/seat", claim, http.StatusConflict, "seat_claimed")
+	if !Games[g.ID].allSeatsClaimed() {
+		t.Errorf("Every seat should be claimed.")
+	}
+}
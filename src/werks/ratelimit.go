@@ -0,0 +1,226 @@
+package werks
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimits limits how often clients may try to log in and register.  Each
+// IP address may make LoginsPerIP login attempts and RegistrationsPerIP
+// registrations every Window seconds.  After FreeFailures failed logins in a
+// row for a nickname, the nickname is locked out for Backoff seconds, and
+// the lockout doubles with each further failure, up to MaxBackoff.  A
+// successful login clears the failures.  A limit of 0 means there's no
+// limit.
+type RateLimits struct {
+	LoginsPerIP        int `json:"loginsPerIp"`
+	RegistrationsPerIP int `json:"registrationsPerIp"`
+	Window             int `json:"window"`
+	FreeFailures       int `json:"freeFailures"`
+	Backoff            int `json:"backoff"`
+	MaxBackoff         int `json:"maxBackoff"`
+}
+
+// DefaultRateLimits returns the limits the server uses unless it's told
+// otherwise.
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		LoginsPerIP:        10,
+		RegistrationsPerIP: 3,
+		Window:             60,
+		FreeFailures:       3,
+		Backoff:            2,
+		MaxBackoff:         15 * 60}
+}
+
+// Limits are the limits the server enforces.
+var Limits = DefaultRateLimits()
+
+// attempts are the recent attempts from an IP address.  reported is set
+// once the address has been reported for going over the limit, so that an
+// address hammering the server is only reported once per window.
+type attempts struct {
+	times    []time.Time
+	reported bool
+}
+
+// failures are the failed logins in a row for a nickname.  The nickname is
+// locked out until until.
+type failures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+// limiter keeps track of attempts to log in and register.
+var limiter = struct {
+	sync.Mutex
+	logins        map[string]*attempts
+	registrations map[string]*attempts
+	failures      map[string]*failures
+	swept         time.Time
+}{
+	logins:        make(map[string]*attempts),
+	registrations: make(map[string]*attempts),
+	failures:      make(map[string]*failures)}
+
+// window returns the length of the rate limiting window.
+func (l RateLimits) window() time.Duration {
+	return time.Duration(l.Window) * time.Second
+}
+
+// backoff returns how long a nickname is locked out for after the given
+// number of failed logins in a row.
+func (l RateLimits) backoff(count int) time.Duration {
+	if l.Backoff == 0 || count < l.FreeFailures {
+		return 0
+	}
+	d := time.Duration(l.Backoff) * time.Second
+	max := time.Duration(l.MaxBackoff) * time.Second
+	for i := l.FreeFailures; i < count && (max == 0 || d < max) && d < math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+// validate checks that the limits are usable.
+func (l RateLimits) validate() error {
+	if l.LoginsPerIP < 0 || l.RegistrationsPerIP < 0 || l.Window < 0 ||
+		l.FreeFailures < 0 || l.Backoff < 0 || l.MaxBackoff < 0 {
+		return errors.New("Rate limits can't be negative.")
+	}
+	if (l.LoginsPerIP > 0 || l.RegistrationsPerIP > 0) && l.Window == 0 {
+		return errors.New("Rate limits need a window.")
+	}
+	return nil
+}
+
+// errTooMany returns the error for a client that must wait before trying
+// again.
+func errTooMany(wait time.Duration) error {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	return apiError(http.StatusTooManyRequests, "rate_limited",
+		"Too many attempts; try again in %d seconds.", seconds)
+}
+
+// allow records an attempt by the request's IP address in m, returning an
+// error if the address has already made limit attempts within the window.
+// kind names the attempt in the audit log.
+func allow(r *http.Request, m map[string]*attempts, limit int, kind string) error {
+	if limit == 0 {
+		return nil
+	}
+	now := clock.Now()
+	ip := remoteIP(r)
+	a, ok := m[ip]
+	if !ok {
+		a = new(attempts)
+		m[ip] = a
+	}
+	recent := make([]time.Time, 0, limit)
+	for _, t := range a.times {
+		if now.Sub(t) < Limits.window() {
+			recent = append(recent, t)
+		}
+	}
+	a.times = recent
+	if len(recent) < limit {
+		a.reported = false
+		a.times = append(recent, now)
+		return nil
+	}
+	if !a.reported {
+		a.reported = true
+		audit(r, AuditEvent{Kind: AuditRateLimited, Detail: kind})
+	}
+	return errTooMany(recent[0].Add(Limits.window()).Sub(now))
+}
+
+// nicknameKey returns the key the failed logins for a nickname are kept
+// under:  the user's ID if there is such a user, so that the variations of a
+// nickname share a lockout.
+func nicknameKey(nickname string) string {
+	if u := users.LookupByNickname(nickname); u != nil {
+		return u.Id
+	}
+	return strings.ToLower(nickname)
+}
+
+// sweep forgets the attempts and failures that no longer matter, once per
+// window, so that the limiter doesn't grow without bound.
+func sweep(now time.Time) {
+	if now.Sub(limiter.swept) < Limits.window() {
+		return
+	}
+	limiter.swept = now
+	for _, m := range []map[string]*attempts{limiter.logins, limiter.registrations} {
+		for ip, a := range m {
+			if len(a.times) == 0 || now.Sub(a.times[len(a.times)-1]) >= Limits.window() {
+				delete(m, ip)
+			}
+		}
+	}
+	keep := Limits.window()
+	if max := time.Duration(Limits.MaxBackoff) * time.Second; max > keep {
+		keep = max
+	}
+	for key, f := range limiter.failures {
+		if now.After(f.until) && now.Sub(f.last) >= keep {
+			delete(limiter.failures, key)
+		}
+	}
+}
+
+// checkLogin returns an error if the request may not attempt to log in as
+// the nickname.
+func checkLogin(r *http.Request, nickname string) error {
+	limiter.Lock()
+	defer limiter.Unlock()
+	now := clock.Now()
+	sweep(now)
+	if f, ok := limiter.failures[nicknameKey(nickname)]; ok && now.Before(f.until) {
+		return errTooMany(f.until.Sub(now))
+	}
+	return allow(r, limiter.logins, Limits.LoginsPerIP, "login")
+}
+
+// recordLogin records whether a login as the nickname succeeded, locking
+// the nickname out if it has failed too many times in a row.
+func recordLogin(r *http.Request, nickname string, ok bool) {
+	limiter.Lock()
+	defer limiter.Unlock()
+	key := nicknameKey(nickname)
+	if ok {
+		delete(limiter.failures, key)
+		return
+	}
+	f, found := limiter.failures[key]
+	if !found {
+		f = new(failures)
+		limiter.failures[key] = f
+	}
+	f.count++
+	f.last = clock.Now()
+	if d := Limits.backoff(f.count); d > 0 {
+		f.until = f.last.Add(d)
+		audit(r, AuditEvent{Kind: AuditLockout, Nickname: nickname,
+			Detail: fmt.Sprintf("Locked out for %s after %d failed logins.", d, f.count)})
+	}
+}
+
+// checkRegistration returns an error if the request may not register a
+// user.
+func checkRegistration(r *http.Request) error {
+	limiter.Lock()
+	defer limiter.Unlock()
+	sweep(clock.Now())
+	return allow(r, limiter.registrations, Limits.RegistrationsPerIP, "register")
+}
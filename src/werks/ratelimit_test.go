@@ -0,0 +1,205 @@
+package werks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+	"user"
+)
+
+// useLimits substitutes the limits for Limits, and forgets every attempt,
+// until the returned function is called.
+func useLimits(l RateLimits) func() {
+	reset := func() {
+		limiter.Lock()
+		defer limiter.Unlock()
+		limiter.logins = make(map[string]*attempts)
+		limiter.registrations = make(map[string]*attempts)
+		limiter.failures = make(map[string]*failures)
+		limiter.swept = time.Time{}
+	}
+	old := Limits
+	Limits = l
+	reset()
+	return func() {
+		Limits = old
+		reset()
+	}
+}
+
+// requestFrom returns a request from the IP address.
+func requestFrom(ip string) *http.Request {
+	r := httptest.NewRequest("POST", "/api/v1/login", nil)
+	r.RemoteAddr = ip + ":1234"
+	return r
+}
+
+// expectLimited checks that err is a rate_limited error asking the client
+// to wait the given number of seconds.
+func expectLimited(t *testing.T, err error, seconds int) {
+	e, ok := err.(*APIError)
+	if !ok || e.Status != http.StatusTooManyRequests || e.Code != "rate_limited" {
+		t.Errorf("Expected to be rate limited, got %v", err)
+		return
+	}
+	if e.Message != errTooMany(time.Duration(seconds)*time.Second).Error() {
+		t.Errorf("Expected to wait %d seconds: %s", seconds, e.Message)
+	}
+}
+
+// auditKinds returns the kinds of the events in the audit log.
+func auditKinds(t *testing.T, b *bytes.Buffer) []string {
+	kinds := make([]string, 0)
+	scanner := bufio.NewScanner(b)
+	for scanner.Scan() {
+		var e AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("%s", err)
+		}
+		kinds = append(kinds, e.Kind)
+	}
+	return kinds
+}
+
+func TestRateLimitsBackoff(t *testing.T) {
+	l := RateLimits{FreeFailures: 2, Backoff: 1, MaxBackoff: 4}
+	expected := []time.Duration{0, 0, 1, 2, 4, 4, 4}
+	for count, d := range expected {
+		if got := l.backoff(count); got != d*time.Second {
+			t.Errorf("After %d failures, expected %s, got %s", count, d*time.Second, got)
+		}
+	}
+	if d := (RateLimits{Backoff: 1}).backoff(40); d <= 0 {
+		t.Errorf("Without a maximum, the backoff shouldn't overflow: %s", d)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	c, restoreClock := useFakeClock()
+	defer restoreClock()
+	_, events, restoreLogs := useLogBuffers()
+	defer restoreLogs()
+	defer useLimits(RateLimits{FreeFailures: 2, Backoff: 1, MaxBackoff: 4})()
+	users = user.Init("salt", "")
+	if _, err := users.Register("Victim", "open sesame"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	r := requestFrom("192.0.2.1")
+
+	for i := 0; i < 2; i++ {
+		if _, err := login(r, "victim", "wrong"); err == nil {
+			t.Fatalf("The login should have failed.")
+		}
+	}
+	// locked out, even with the right password.
+	_, err := login(r, "Victim", "open sesame")
+	expectLimited(t, err, 1)
+
+	c.Advance(time.Second)
+	if _, err = login(r, "victim", "wrong"); err == nil {
+		t.Fatalf("The login should have failed.")
+	}
+	_, err = login(requestFrom("192.0.2.2"), "victim", "open sesame")
+	expectLimited(t, err, 2)
+	c.Advance(2 * time.Second)
+	login(r, "victim", "wrong")
+	c.Advance(4 * time.Second)
+	login(r, "victim", "wrong")
+	_, err = login(r, "victim", "open sesame")
+	expectLimited(t, err, 4)
+
+	c.Advance(4 * time.Second)
+	if _, err = login(r, "victim", "open sesame"); err != nil {
+		t.Fatalf("The lockout should have expired: %s", err)
+	}
+	// succeeding clears the failures.
+	login(r, "victim", "wrong")
+	if _, err = login(r, "victim", "open sesame"); err != nil {
+		t.Errorf("One failure shouldn't lock the user out: %s", err)
+	}
+
+	lockouts := 0
+	for _, kind := range auditKinds(t, events) {
+		if kind == AuditLockout {
+			lockouts++
+		}
+	}
+	if lockouts != 4 {
+		t.Errorf("Expected 4 lockouts in the audit log, got %d", lockouts)
+	}
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	c, restoreClock := useFakeClock()
+	defer restoreClock()
+	_, events, restoreLogs := useLogBuffers()
+	defer restoreLogs()
+	defer useLimits(RateLimits{LoginsPerIP: 3, Window: 60})()
+	users = user.Init("salt", "")
+
+	r := requestFrom("192.0.2.1")
+	for _, nickname := range []string{"a", "b", "c"} {
+		if _, err := login(r, nickname, "wrong"); err == nil {
+			t.Errorf("%s shouldn't be able to log in.", nickname)
+		} else if _, limited := err.(*APIError); limited {
+			t.Errorf("%s shouldn't be rate limited yet.", nickname)
+		}
+	}
+	c.Advance(10 * time.Second)
+	_, err := login(r, "d", "wrong")
+	expectLimited(t, err, 50)
+	_, err = login(r, "e", "wrong")
+	expectLimited(t, err, 50)
+	if _, err = login(requestFrom("192.0.2.2"), "d", "wrong"); err == nil {
+		t.Errorf("d shouldn't be able to log in.")
+	} else if _, limited := err.(*APIError); limited {
+		t.Errorf("Other addresses shouldn't be rate limited.")
+	}
+
+	c.Advance(50 * time.Second)
+	if _, err = login(r, "d", "wrong"); err == nil {
+		t.Errorf("d shouldn't be able to log in.")
+	} else if _, limited := err.(*APIError); limited {
+		t.Errorf("The window should have passed: %s", err)
+	}
+
+	limited := 0
+	for _, kind := range auditKinds(t, events) {
+		if kind == AuditRateLimited {
+			limited++
+		}
+	}
+	if limited != 1 {
+		t.Errorf("The address should be reported once, got %d", limited)
+	}
+}
+
+func TestRegistrationThrottle(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	_, restoreClock := useFakeClock()
+	defer restoreClock()
+	_, _, restoreLogs := useLogBuffers()
+	defer restoreLogs()
+	defer useLimits(RateLimits{RegistrationsPerIP: 2, LoginsPerIP: 10, Window: 3600, FreeFailures: 1, Backoff: 60})()
+	users = user.Init("salt", "")
+	s := apiServer()
+	defer s.Close()
+
+	call(t, s, "POST", "/api/v1/register", url.Values{"u": {"first"}, "p": {"open sesame"}}, nil)
+	call(t, s, "POST", "/api/v1/register", url.Values{"u": {"second"}, "p": {"open sesame"}}, nil)
+	expectError(t, s, "POST", "/api/v1/register", url.Values{"u": {"third"}, "p": {"open sesame"}},
+		http.StatusTooManyRequests, "rate_limited")
+	if users.LookupByNickname("third") != nil {
+		t.Errorf("The third user shouldn't have been registered.")
+	}
+
+	expectError(t, s, "POST", "/api/v1/login", url.Values{"u": {"first"}, "p": {"wrong"}},
+		http.StatusUnauthorized, "invalid_login")
+	expectError(t, s, "POST", "/api/v1/login", url.Values{"u": {"first"}, "p": {"open sesame"}},
+		http.StatusTooManyRequests, "rate_limited")
+}
@@ -0,0 +1,173 @@
+package werks
+
+import (
+	"encoding/json"
+	"fmt"
+	"gamework"
+	"net/http"
+	"strconv"
+)
+
+// ReplayEngines contains the engines that can replay stored games, keyed by
+// the name a client uses to ask for them.
+var ReplayEngines = map[string]gamework.EngineFactory{
+	"werks": newEngine,
+	"test": func() gamework.GameEngine {
+		return new(gamework.TestGameEngine)
+	},
+}
+
+// ReplayStep is one step in a game's history.  Snapshot is the game as it
+// stood after the step's action, and is only included when a single step
+// is requested.  Player IDs are secret, so the action and the outcome's
+// Audience name the players instead.
+type ReplayStep struct {
+	Index    int             `json:"index"`
+	Action   *ReplayAction   `json:"action"`
+	Outcome  gamework.Event  `json:"outcome"`
+	Snapshot json.RawMessage `json:"snapshot,omitempty"`
+}
+
+// ReplayAction is the action taken in a step, by the player named Player.
+// The action closing a simultaneous phase has no player, and a Batch of
+// the actions the players took.
+type ReplayAction struct {
+	Abbr   string         `json:"abbr"`
+	Player string         `json:"player,omitempty"`
+	Batch  []ReplayAction `json:"batch,omitempty"`
+}
+
+// ReplayDiff contains the changes to a game between two steps.
+type ReplayDiff struct {
+	From    int               `json:"from"`
+	To      int               `json:"to"`
+	Changes []gamework.Change `json:"changes"`
+}
+
+// newReplayStep converts a gamework.Step into a ReplayStep, using names to
+// look up the names of the players by ID.
+func newReplayStep(s gamework.Step, names map[string]string) ReplayStep {
+	r := ReplayStep{Index: s.Index, Outcome: s.Outcome}
+	if s.Action != nil {
+		a := newReplayAction(*s.Action, names)
+		r.Action = &a
+	}
+	if s.Outcome.Audience != nil {
+		r.Outcome.Audience = make([]string, len(s.Outcome.Audience))
+		for i, id := range s.Outcome.Audience {
+			r.Outcome.Audience[i] = names[id]
+		}
+	}
+	if s.Snapshot != "" {
+		r.Snapshot = json.RawMessage(s.Snapshot)
+	}
+	return r
+}
+
+// newReplayAction converts a gamework.Action into a ReplayAction.
+func newReplayAction(a gamework.Action, names map[string]string) ReplayAction {
+	r := ReplayAction{Abbr: a.Abbr, Player: names[a.PlayerId]}
+	for _, b := range a.Batch {
+		r.Batch = append(r.Batch, newReplayAction(b, names))
+	}
+	return r
+}
+
+// playerNames maps the IDs of the game's players to their names.
+func playerNames(gg gamework.Game) map[string]string {
+	names := make(map[string]string)
+	for _, p := range gg.Players {
+		names[p.Id] = p.Name
+	}
+	return names
+}
+
+// getReplayGameFromRequest returns the game to replay and the factory for
+// its engine.  A GET replays the running werks game whose ID is g; a POST
+// replays the serialized gamework.Game in the game form value, using the
+// engine named by the engine form value.
+func getReplayGameFromRequest(r *http.Request) (gamework.Game, gamework.EngineFactory, error) {
+	var gg gamework.Game
+	if r.Method != "POST" {
+		g, err := getGameFromRequest(r)
+		if err != nil {
+			return gg, nil, err
+		}
+		return g.gameworkGame(), newEngine, nil
+	}
+
+	factory, ok := ReplayEngines[r.FormValue("engine")]
+	if !ok {
+		return gg, nil, apiError(http.StatusBadRequest, "unknown_engine",
+			"Unknown engine: %s", r.FormValue("engine"))
+	}
+	if err := gamework.ReadFromString(r.FormValue("game"), &gg); err != nil {
+		return gg, nil, badRequest("invalid_game", err)
+	}
+	return gg, factory, nil
+}
+
+// replay computes the response to a replay request.  Without n, it's the
+// list of steps.  With n, it's step n, including a snapshot; with n and
+// from, it's the changes between step from and step n.
+func replay(r *http.Request) (interface{}, error) {
+	gg, newEngine, err := getReplayGameFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	names := playerNames(gg)
+	if r.FormValue("n") == "" {
+		steps, err := gamework.Steps(gg, newEngine, "")
+		if err != nil {
+			return nil, badRequest("invalid_replay", err)
+		}
+		result := make([]ReplayStep, len(steps))
+		for i, s := range steps {
+			result[i] = newReplayStep(s, names)
+		}
+		return result, nil
+	}
+
+	n, err := strconv.Atoi(r.FormValue("n"))
+	if err != nil {
+		return nil, badRequest("bad_request", err)
+	}
+	to, err := gamework.ReplayTo(gg, newEngine, n, "")
+	if err != nil {
+		return nil, badRequest("invalid_replay", err)
+	}
+	if r.FormValue("from") == "" {
+		return newReplayStep(to, names), nil
+	}
+
+	m, err := strconv.Atoi(r.FormValue("from"))
+	if err != nil {
+		return nil, badRequest("bad_request", err)
+	}
+	from, err := gamework.ReplayTo(gg, newEngine, m, "")
+	if err != nil {
+		return nil, badRequest("invalid_replay", err)
+	}
+	changes, err := gamework.Diff(from.Snapshot, to.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayDiff{From: m, To: n, Changes: changes}, nil
+}
+
+// apiReplayHandler lets a client step back and forth through a game's
+// history; see replay for the parameters.
+func apiReplayHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := replay(r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Add("content-type", "application/json")
+	fmt.Fprintf(w, "%s", b)
+}
@@ -0,0 +1,159 @@
+package werks
+
+import (
+	"encoding/json"
+	"gamework"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// playFewActions develops and passes through the first few decisions.
+func playFewActions(t *testing.T, g *Game) {
+	for _, abbr := range []string{"D:a1", "P", "P"} {
+		p := g.getCurrentPlayer()
+		if g.findAction(abbr) == nil {
+			abbr = "P"
+		}
+		if err := g.performAction(p, abbr); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+}
+
+func TestReplayReproducesGame(t *testing.T) {
+	g := newGame()
+	playFewActions(t, g)
+
+	gg := g.gameworkGame()
+	e := new(Game)
+	gg.Engine = e
+	if err := gamework.Replay(gg); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !g.Equals(e) {
+		t.Errorf("Replayed game differs.\n%s\n%s", g.Debug(), e.Debug())
+	}
+}
+
+func TestReplayApi(t *testing.T) {
+	g := newGame()
+	playFewActions(t, g)
+
+	w := serve(t, apiReplayHandler, "GET", "/api/replay?g="+g.ID)
+	var steps []ReplayStep
+	if err := json.Unmarshal(w.Body.Bytes(), &steps); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	if len(steps) != len(g.History)+1 {
+		t.Fatalf("Expected %d steps, got %d", len(g.History)+1, len(steps))
+	}
+	if !strings.Contains(steps[1].Outcome.Text, "developed") {
+		t.Errorf("Step 1 should be a development: %s", steps[1].Outcome.Text)
+	}
+
+	w = serve(t, apiReplayHandler, "GET", "/api/replay?g="+g.ID+"&n=1")
+	var step ReplayStep
+	if err := json.Unmarshal(w.Body.Bytes(), &step); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	var snapshot Game
+	if err := json.Unmarshal(step.Snapshot, &snapshot); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(snapshot.Players[0].Factories) != 2 {
+		t.Errorf("After step 1, %s should have 2 factories.", snapshot.Players[0].Name)
+	}
+
+	w = serve(t, apiReplayHandler, "GET", "/api/replay?g="+g.ID+"&n=1&from=0")
+	var diff ReplayDiff
+	if err := json.Unmarshal(w.Body.Bytes(), &diff); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	changed := make(map[string]bool)
+	for _, c := range diff.Changes {
+		changed[c.Path] = true
+	}
+	if !changed["players.0.money"] || !changed["players.0.factories.1"] {
+		t.Errorf("Unexpected changes: %v", diff.Changes)
+	}
+
+	w = serve(t, apiReplayHandler, "GET", "/api/replay?g="+g.ID+"&n=99")
+	if w.Code == http.StatusOK {
+		t.Errorf("Replaying past the end should fail.")
+	}
+}
+
+func TestReplayHidesPlayerIDs(t *testing.T) {
+	g := newGame()
+	playFewActions(t, g)
+
+	for _, query := range []string{"", "&n=1", "&n=3&from=1"} {
+		w := serve(t, apiReplayHandler, "GET", "/api/replay?g="+g.ID+query)
+		for _, p := range g.Players {
+			if strings.Contains(w.Body.String(), p.ID) {
+				t.Errorf("%s: the replay shouldn't include %s's ID: %s", query, p.Name, w.Body.String())
+			}
+		}
+	}
+	w := serve(t, apiReplayHandler, "GET", "/api/replay?g="+g.ID)
+	var steps []ReplayStep
+	if err := json.Unmarshal(w.Body.Bytes(), &steps); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	if steps[1].Action == nil || steps[1].Action.Player != g.Players[0].Name {
+		t.Errorf("Step 1 should name the player who acted, got %+v", steps[1].Action)
+	}
+}
+
+func TestReplayStoredTestGame(t *testing.T) {
+	gg := gamework.InitTestGameWithTestEngine()
+	gg.Actions = []gamework.Action{{Abbr: "A", PlayerId: "A"}, {Abbr: "P", PlayerId: "A"}}
+	s, err := gamework.WriteToString(gg)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	form := url.Values{"engine": {"test"}, "game": {s}, "n": {"2"}}
+	r, err := http.NewRequest("POST", "/api/replay", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	apiReplayHandler(w, r)
+
+	var step ReplayStep
+	if err := json.Unmarshal(w.Body.Bytes(), &step); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	if step.Outcome.Text != "Allen passed." {
+		t.Errorf("Unexpected outcome: %s", step.Outcome.Text)
+	}
+}
+
+func TestReplayRejectsBadGames(t *testing.T) {
+	s := apiServer()
+	defer s.Close()
+	one := gamework.Game{Players: []gamework.Player{{Id: "A", Name: "Allen"}}}
+	zz := gamework.InitTestGameWithTestEngine()
+	zz.Actions = []gamework.Action{{Abbr: "ZZ", PlayerId: "A"}}
+	tests := []struct {
+		engine string
+		game   gamework.Game
+	}{
+		{"test", zz},
+		{"test", gamework.Game{}},
+		{"werks", one},
+	}
+	for _, test := range tests {
+		game, err := gamework.WriteToString(test.game)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		expectError(t, s, "POST", "/api/v1/replay", url.Values{"engine": {test.engine}, "game": {game}},
+			http.StatusBadRequest, "invalid_replay")
+	}
+}
@@ -0,0 +1,196 @@
+package werks
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"user"
+)
+
+// ServerConfig configures the server.  RootPath is the directory containing
+// the static files and the json directory, and DataDir is where the users
+// file and chat histories are kept.  If TLSCert and TLSKey are set, the
+// server uses TLS.  Salt is added to passwords before they're hashed, so
+// changing it invalidates every saved password.  If Verbose is set, every
+// request is logged, including the ones clients poll with.  AuditLog is the
+// file security-relevant events are appended to; it's audit.log in DataDir
+// unless it's set.  Limits limit how often clients may try to log in and
+// register, and Policy is what nicknames and passwords must be like.
+// AdminPassword is the password of the admin user created when there's no
+// users file yet; if it's empty, a random one is logged instead.  It's only
+// read from the file or the environment, so that it isn't on the command
+// line.
+type ServerConfig struct {
+	Addr          string      `json:"addr"`
+	TLSCert       string      `json:"tlsCert"`
+	TLSKey        string      `json:"tlsKey"`
+	RootPath      string      `json:"rootPath"`
+	StaticDirs    []string    `json:"staticDirs"`
+	DataDir       string      `json:"dataDir"`
+	Salt          string      `json:"salt"`
+	Verbose       bool        `json:"verbose"`
+	AuditLog      string      `json:"auditLog"`
+	Limits        RateLimits  `json:"limits"`
+	Policy        user.Policy `json:"policy"`
+	AdminPassword string      `json:"adminPassword"`
+}
+
+// DefaultServerConfig returns the configuration the server uses unless
+// it's told otherwise.
+func DefaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Addr:       ":8080",
+		RootPath:   ".",
+		StaticDirs: []string{"css", "html", "js", "lib", "views"},
+		DataDir:    ".",
+		Salt:       "CaCl",
+		Limits:     DefaultRateLimits(),
+		Policy:     user.DefaultPolicy()}
+}
+
+// LoadServerConfig returns the configuration described by the command line
+// arguments, starting from defaults.  The settings come from, in order of
+// precedence, the flags, the WERKS_* environment variables, and the JSON
+// file named by the -config flag or WERKS_CONFIG.
+func LoadServerConfig(defaults *ServerConfig, args []string) (*ServerConfig, error) {
+	c := *defaults
+	fs := flag.NewFlagSet("werks", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("WERKS_CONFIG"), "a JSON file of settings")
+	flags := &ServerConfig{}
+	staticDirs := ""
+	fs.StringVar(&flags.Addr, "addr", "", "the address to listen on (default "+c.Addr+")")
+	fs.StringVar(&flags.TLSCert, "tls-cert", "", "the TLS certificate file")
+	fs.StringVar(&flags.TLSKey, "tls-key", "", "the TLS key file")
+	fs.StringVar(&flags.RootPath, "root", "", "the directory of the static files (default "+c.RootPath+")")
+	fs.StringVar(&staticDirs, "static", "", "the comma-separated static directories under the root")
+	fs.StringVar(&flags.DataDir, "data", "", "the directory to keep users and chat in (default "+c.DataDir+")")
+	fs.StringVar(&flags.Salt, "salt", "", "the password salt")
+	fs.BoolVar(&flags.Verbose, "verbose", false, "log every request")
+	fs.StringVar(&flags.AuditLog, "audit-log", "", "the audit log file (default audit.log in the data directory)")
+	fs.IntVar(&flags.Limits.LoginsPerIP, "login-limit", 0,
+		fmt.Sprintf("the login attempts allowed per IP address per window (default %d; 0 for none)", c.Limits.LoginsPerIP))
+	fs.IntVar(&flags.Limits.RegistrationsPerIP, "register-limit", 0,
+		fmt.Sprintf("the registrations allowed per IP address per window (default %d; 0 for none)", c.Limits.RegistrationsPerIP))
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("Unexpected argument: %s", fs.Arg(0))
+	}
+
+	if *file != "" {
+		b, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("%s: %s", *file, err)
+		}
+	}
+
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			c.Addr = flags.Addr
+		case "tls-cert":
+			c.TLSCert = flags.TLSCert
+		case "tls-key":
+			c.TLSKey = flags.TLSKey
+		case "root":
+			c.RootPath = flags.RootPath
+		case "static":
+			c.StaticDirs = splitList(staticDirs)
+		case "data":
+			c.DataDir = flags.DataDir
+		case "salt":
+			c.Salt = flags.Salt
+		case "verbose":
+			c.Verbose = flags.Verbose
+		case "audit-log":
+			c.AuditLog = flags.AuditLog
+		case "login-limit":
+			c.Limits.LoginsPerIP = flags.Limits.LoginsPerIP
+		case "register-limit":
+			c.Limits.RegistrationsPerIP = flags.Limits.RegistrationsPerIP
+		}
+	})
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// applyEnv applies the settings in the WERKS_* environment variables.
+func (c *ServerConfig) applyEnv() error {
+	vars := map[string]*string{
+		"WERKS_ADDR":           &c.Addr,
+		"WERKS_TLS_CERT":       &c.TLSCert,
+		"WERKS_TLS_KEY":        &c.TLSKey,
+		"WERKS_ROOT":           &c.RootPath,
+		"WERKS_DATA_DIR":       &c.DataDir,
+		"WERKS_SALT":           &c.Salt,
+		"WERKS_AUDIT_LOG":      &c.AuditLog,
+		"WERKS_ADMIN_PASSWORD": &c.AdminPassword}
+	for name, field := range vars {
+		if v, ok := os.LookupEnv(name); ok {
+			*field = v
+		}
+	}
+	if v, ok := os.LookupEnv("WERKS_STATIC_DIRS"); ok {
+		c.StaticDirs = splitList(v)
+	}
+	if v, ok := os.LookupEnv("WERKS_VERBOSE"); ok {
+		verbose, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("WERKS_VERBOSE: %s", err)
+		}
+		c.Verbose = verbose
+	}
+	limits := map[string]*int{
+		"WERKS_LOGIN_LIMIT":    &c.Limits.LoginsPerIP,
+		"WERKS_REGISTER_LIMIT": &c.Limits.RegistrationsPerIP}
+	for name, field := range limits {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			*field = n
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list.
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// validate checks that the configuration is usable.
+func (c *ServerConfig) validate() error {
+	if c.Addr == "" {
+		return errors.New("The address to listen on must be set.")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("TLS needs both a certificate and a key.")
+	}
+	if c.Salt == "" {
+		return errors.New("The password salt must be set.")
+	}
+	return c.Limits.validate()
+}
@@ -0,0 +1,227 @@
+package werks
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"user"
+)
+
+func TestServerConfigDefaults(t *testing.T) {
+	c, err := LoadServerConfig(DefaultServerConfig(), []string{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !reflect.DeepEqual(c, DefaultServerConfig()) {
+		t.Errorf("Expected the defaults, got %+v", c)
+	}
+}
+
+func TestServerConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "werks")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "werks.json")
+	contents := `{"addr": ":9000", "dataDir": "/var/werks", "salt": "file", "staticDirs": ["html"], "limits": {"window": 120}}`
+	if err = ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	os.Setenv("WERKS_SALT", "env")
+	os.Setenv("WERKS_VERBOSE", "true")
+	os.Setenv("WERKS_ADDR", ":9001")
+	os.Setenv("WERKS_LOGIN_LIMIT", "5")
+	defer os.Unsetenv("WERKS_SALT")
+	defer os.Unsetenv("WERKS_VERBOSE")
+	defer os.Unsetenv("WERKS_ADDR")
+	defer os.Unsetenv("WERKS_LOGIN_LIMIT")
+
+	c, err := LoadServerConfig(DefaultServerConfig(),
+		[]string{"-config", file, "-addr", ":9002", "-static", "css, js", "-register-limit", "1"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	limits := DefaultRateLimits()
+	limits.Window, limits.LoginsPerIP, limits.RegistrationsPerIP = 120, 5, 1
+	expected := &ServerConfig{
+		Addr:       ":9002",
+		RootPath:   ".",
+		StaticDirs: []string{"css", "js"},
+		DataDir:    "/var/werks",
+		Salt:       "env",
+		Verbose:    true,
+		Limits:     limits,
+		Policy:     user.DefaultPolicy()}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, c)
+	}
+}
+
+func TestServerConfigValidation(t *testing.T) {
+	bad := [][]string{
+		{"-tls-cert", "cert.pem"},
+		{"-salt", ""},
+		{"-addr", ""},
+		{"-login-limit", "-1"},
+		{"-nonsense"},
+		{"extra"},
+		{"-config", "/nonexistent/werks.json"},
+	}
+	for _, args := range bad {
+		if _, err := LoadServerConfig(DefaultServerConfig(), args); err == nil {
+			t.Errorf("%v should have been rejected.", args)
+		}
+	}
+	c, err := LoadServerConfig(DefaultServerConfig(), []string{"-tls-cert", "cert.pem", "-tls-key", "key.pem"})
+	if err != nil || c.TLSCert != "cert.pem" || c.TLSKey != "key.pem" {
+		t.Errorf("Expected TLS to be configured, got %+v %v", c, err)
+	}
+}
+
+func TestInitApp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "werks")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	oldRoot, oldChatDir := rootPath, ChatDir
+	defer func() { rootPath, ChatDir = oldRoot, oldChatDir }()
+
+	logged := new(bytes.Buffer)
+	log.SetOutput(logged)
+	defer log.SetOutput(os.Stderr)
+
+	c := DefaultServerConfig()
+	c.DataDir = filepath.Join(dir, "data")
+	c.RootPath = "../.."
+	if err = initApp(c); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer func() { users.Unlock() }()
+	if _, err = os.Stat(filepath.Join(c.DataDir, "users.json")); err != nil {
+		t.Errorf("The users file should be in the data directory: %s", err)
+	}
+	if ChatDir != filepath.Join(c.DataDir, "chat") || rootPath != "../.." {
+		t.Errorf("Unexpected paths: %s, %s", ChatDir, rootPath)
+	}
+	// the admin user gets a random password, which is logged.
+	if _, err = users.Login("admin", "admin"); err == nil {
+		t.Errorf("The admin user shouldn't have a default password.")
+	}
+	fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(logged.String()), "."))
+	if len(fields) == 0 {
+		t.Fatalf("The admin password should be logged.")
+	}
+	if _, err = users.Login("admin", fields[len(fields)-1]); err != nil {
+		t.Errorf("The admin user should have the logged password: %s: %s", err, logged)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(c.DataDir, "audit.log"))
+	if err != nil || !strings.Contains(string(b), `"kind":"admin"`) {
+		t.Errorf("The default user's creation should be audited, got %q %v", b, err)
+	}
+	if strings.Contains(string(b), fields[len(fields)-1]) {
+		t.Errorf("The admin password shouldn't be audited.")
+	}
+}
+
+func TestInitAppAdminPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "werks")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	oldRoot, oldChatDir := rootPath, ChatDir
+	defer func() { rootPath, ChatDir = oldRoot, oldChatDir }()
+
+	c := DefaultServerConfig()
+	c.DataDir = dir
+	c.AdminPassword = "short"
+	if err = initApp(c); err == nil {
+		t.Errorf("The admin password should have to satisfy the policy.")
+	}
+	users.Unlock()
+	c.AdminPassword = "open sesame"
+	if err = initApp(c); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer func() { users.Unlock() }()
+	if _, err = users.Login("admin", "open sesame"); err != nil {
+		t.Errorf("The admin user should have the configured password: %s", err)
+	}
+}
+
+func TestInitAppUsersFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "werks")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	oldRoot, oldChatDir := rootPath, ChatDir
+	defer func() { rootPath, ChatDir = oldRoot, oldChatDir }()
+	c := DefaultServerConfig()
+	c.DataDir = dir
+	c.AdminPassword = "open sesame"
+	path := filepath.Join(dir, "users.json")
+	if err = initApp(c); err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer func() { users.Unlock() }()
+
+	// a second server can't use the same users file.
+	users.Unlock()
+	other := user.Init(c.Salt, path)
+	if err = other.Lock(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err = initApp(c); err != user.ErrLocked {
+		t.Errorf("Expected the users file to be locked, got %v", err)
+	}
+	other.Unlock()
+
+	// a corrupt users file is restored from its backup, rather than
+	// replaced with a new admin user.
+	if err = initApp(c); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err = users.Register("keeper", "open sesame"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err = users.SaveUsers(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err = ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err = initApp(c); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err = users.Login("admin", "open sesame"); err != nil {
+		t.Errorf("The admin user should have been restored: %s", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	if err != nil || strings.Count(string(b), `"kind":"admin"`) != 1 ||
+		!strings.Contains(string(b), `"kind":"users_recovered"`) {
+		t.Errorf("The recovery should be audited, got %q %v", b, err)
+	}
+
+	// without a backup, the server refuses to start.
+	for i := 1; i <= users.Backups; i++ {
+		os.Remove(fmt.Sprintf("%s.%d", path, i))
+	}
+	if err = ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err = initApp(c); err == nil {
+		t.Errorf("The server shouldn't start with a corrupt users file.")
+	}
+	if b, _ = ioutil.ReadFile(path); string(b) != "{" {
+		t.Errorf("The corrupt users file shouldn't have been replaced.")
+	}
+}
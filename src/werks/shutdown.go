@@ -0,0 +1,81 @@
+package werks
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ShutdownTimeout is how long the server waits for the requests in flight
+// to finish when it's shutting down.
+var ShutdownTimeout = 10 * time.Second
+
+// RestartMessage is sent to every game's players and spectators when the
+// server shuts down.
+var RestartMessage = "The server is restarting; your game will be back shortly."
+
+// ErrShuttingDown is returned for actions taken while the server is
+// shutting down.
+var ErrShuttingDown = errors.New("The server is shutting down.")
+
+// shuttingDown is set once the server has started shutting down.
+var shuttingDown atomic.Bool
+
+// beginShutdown stops the games from accepting actions, and tells their
+// players and spectators that the server is restarting.
+func beginShutdown() {
+	shuttingDown.Store(true)
+	gamesMutex.Lock()
+	defer gamesMutex.Unlock()
+	for _, g := range Games {
+		g.addMessage(RestartMessage)
+	}
+}
+
+// flushState saves the games and users.
+func flushState() error {
+	gamesMutex.Lock()
+	err := saveGames()
+	gamesMutex.Unlock()
+	if users != nil {
+		if uerr := users.SaveUsers(); uerr != nil && err == nil {
+			err = uerr
+		}
+	}
+	return err
+}
+
+// serveUntil runs srv with listen until listen fails or a signal arrives on
+// stop.  On a signal, it shuts down gracefully:  it stops accepting
+// actions, waits up to ShutdownTimeout for the requests in flight, and
+// saves the games and users.
+func serveUntil(srv *http.Server, listen func() error, stop <-chan os.Signal) error {
+	errs := make(chan error, 1)
+	go func() { errs <- listen() }()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		log.Printf("Got %s, shutting down.", sig)
+	}
+
+	beginShutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Couldn't finish the requests in flight: %s", err)
+	}
+	if ferr := flushState(); ferr != nil {
+		return ferr
+	}
+	gamesMutex.Lock()
+	log.Printf("Saved %d games.", len(Games))
+	gamesMutex.Unlock()
+	return err
+}
@@ -0,0 +1,78 @@
+package werks
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+	"user"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	defer useGamesDir(t)()
+	defer shuttingDown.Store(false)
+	users = user.Init("salt", filepath.Join(GamesDir, "users.json"))
+	g := newGame()
+
+	// a request that's in flight when the signal arrives should finish.
+	started := make(chan bool)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	srv := &http.Server{Handler: mux}
+	stop := make(chan os.Signal, 1)
+	done := make(chan error)
+	go func() { done <- serveUntil(srv, func() error { return srv.Serve(l) }, stop) }()
+
+	slow := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		slow <- string(b)
+	}()
+	<-started
+	stop <- syscall.SIGTERM
+
+	if body := <-slow; body != "done" {
+		t.Errorf("The request in flight should have finished, got %q", body)
+	}
+	if err = <-done; err != nil {
+		t.Errorf("%s", err)
+	}
+	if _, err = os.Stat(filepath.Join(GamesDir, g.ID+".json")); err != nil {
+		t.Errorf("The game should have been saved: %s", err)
+	}
+	if _, err = os.Stat(filepath.Join(GamesDir, "users.json")); err != nil {
+		t.Errorf("The users should have been saved: %s", err)
+	}
+	if err = g.performAction(g.getCurrentPlayer(), "P"); err != ErrShuttingDown {
+		t.Errorf("Actions should be refused, got %v", err)
+	}
+	if e := asAPIError(ErrShuttingDown); e.Status != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", e.Status)
+	}
+	var last string
+	for m := g.getMessage(); m != ""; m = g.getMessage() {
+		last = m
+	}
+	if last != RestartMessage {
+		t.Errorf("The players should have been told the server is restarting, got %q", last)
+	}
+}
@@ -0,0 +1,151 @@
+package werks
+
+import (
+	"net/http"
+	"sort"
+	"time"
+	"uuid"
+)
+
+// SpectatorTimeout is how long a spectator can go without polling the game
+// before they're no longer counted as watching it.
+var SpectatorTimeout = 5 * time.Minute
+
+// Spectator is someone watching a game without playing in it.  Each
+// spectator gets their own copy of the game's messages, so they can follow
+// the game without taking messages away from the players.  If the game
+// allows it, they can also read the public chat.
+type Spectator struct {
+	ID       string
+	UserID   string
+	Messages Queue
+	LastSeen time.Time
+}
+
+// PublicPlayer is what anyone can see of a player:  it leaves out the
+// player's ID, which would let them act for the player.
+type PublicPlayer struct {
+	Name       string    `json:"name"`
+	Money      int       `json:"money"`
+	Factories  []Factory `json:"factories"`
+	IsCurrent  bool      `json:"isCurrent"`
+	TurnOrder  int       `json:"turnOrder"`
+	Eliminated bool      `json:"eliminated"`
+}
+
+// SpectatorView is the part of the game that spectators can see.
+type SpectatorView struct {
+	ID         string         `json:"id"`
+	Name       string         `json:"name"`
+	Turn       int            `json:"turn"`
+	Phase      string         `json:"phase"`
+	Locos      []*Loco        `json:"locos"`
+	Players    []PublicPlayer `json:"players"`
+	Log        []string       `json:"log"`
+	Spectators int            `json:"spectators"`
+}
+
+// LobbyGame summarizes a game for the lobby, including the options it was
+// set up with.
+type LobbyGame struct {
+	ID         string         `json:"id"`
+	Name       string         `json:"name"`
+	Players    []PublicPlayer `json:"players"`
+	Turn       int            `json:"turn"`
+	Phase      string         `json:"phase"`
+	Spectators int            `json:"spectators"`
+	Config     *GameConfig    `json:"config"`
+}
+
+// publicPlayers returns what anyone can see of the game's players.
+func (g *Game) publicPlayers() []PublicPlayer {
+	players := make([]PublicPlayer, len(g.Players))
+	for i, p := range g.Players {
+		players[i] = PublicPlayer{
+			Name:       p.Name,
+			Money:      p.Money,
+			Factories:  p.Factories,
+			IsCurrent:  p.IsCurrent,
+			TurnOrder:  p.TurnOrder,
+			Eliminated: p.Eliminated}
+	}
+	return players
+}
+
+// addSpectator adds a new spectator, belonging to the user with the given ID
+// (which may be empty), to the game.
+func (g *Game) addSpectator(userID string) *Spectator {
+	id, err := uuid.GenUUID()
+	if err != nil {
+		panic(err)
+	}
+	s := &Spectator{
+		ID:       id,
+		UserID:   userID,
+		Messages: Queue{Capacity: g.Config.QueueCapacity},
+		LastSeen: clock.Now()}
+	if g.Spectators == nil {
+		g.Spectators = make(map[string]*Spectator)
+	}
+	g.Spectators[s.ID] = s
+	return s
+}
+
+// getSpectator returns the spectator with the given ID, noting that they're
+// still watching.
+func (g *Game) getSpectator(id string) (*Spectator, error) {
+	s, ok := g.Spectators[id]
+	if !ok {
+		return nil, apiError(http.StatusNotFound, "spectator_not_found", "Unknown spectator ID: %s", id)
+	}
+	s.LastSeen = clock.Now()
+	return s, nil
+}
+
+// spectatorCount returns the number of spectators watching the game, and
+// forgets the ones who have stopped.
+func (g *Game) spectatorCount() int {
+	now := clock.Now()
+	for id, s := range g.Spectators {
+		if now.Sub(s.LastSeen) > SpectatorTimeout {
+			delete(g.Spectators, id)
+		}
+	}
+	return len(g.Spectators)
+}
+
+// getSpectatorView returns what spectators can see of the game.
+func (g *Game) getSpectatorView() *SpectatorView {
+	return &SpectatorView{
+		ID:         g.ID,
+		Name:       g.Name,
+		Turn:       g.Turn,
+		Phase:      Phases[g.Phase-1],
+		Locos:      g.Locos,
+		Players:    g.publicPlayers(),
+		Log:        g.Log,
+		Spectators: g.spectatorCount()}
+}
+
+// getLobbyGames returns a summary of every game, ordered by name.
+func getLobbyGames() []LobbyGame {
+	result := make([]LobbyGame, 0, len(Games))
+	for _, g := range Games {
+		result = append(result, LobbyGame{
+			ID:         g.ID,
+			Name:       g.Name,
+			Players:    g.publicPlayers(),
+			Turn:       g.Turn,
+			Phase:      Phases[g.Phase-1],
+			Spectators: g.spectatorCount(),
+			Config:     g.Config})
+	}
+	sort.Sort(byName(result))
+	return result
+}
+
+type byName []LobbyGame
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,139 @@
+package werks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"user"
+)
+
+// serve sends a request to a handler and returns the recorded response.
+func serve(t *testing.T, handler http.HandlerFunc, method, url string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// spectate joins game g as an anonymous spectator and returns the ID.
+func spectate(t *testing.T, g *Game) string {
+	users = user.Init("salt", "")
+	w := serve(t, apiSpectateHandler, "POST", "/api/spectate?g="+g.ID)
+	var resp SpectateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	if resp.Spectator == "" || resp.View == nil || resp.View.ID != g.ID {
+		t.Fatalf("Unexpected response: %s", w.Body.String())
+	}
+	return resp.Spectator
+}
+
+func TestSpectatorView(t *testing.T) {
+	g := newGame()
+	s := spectate(t, g)
+
+	w := serve(t, apiSpectateHandler, "GET", "/api/spectate?g="+g.ID+"&s="+s)
+	var view SpectatorView
+	if err := json.Unmarshal(w.Body.Bytes(), &view); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	if len(view.Locos) != len(g.Locos) || len(view.Players) != len(g.Players) {
+		t.Errorf("Spectators should see the whole board.")
+	}
+	if view.Phase != "Locomotive Development" || view.Spectators != 1 {
+		t.Errorf("Unexpected view: phase %s, %d spectators", view.Phase, view.Spectators)
+	}
+	if len(view.Log) == 0 {
+		t.Errorf("Spectators should see the game log.")
+	}
+
+	w = serve(t, apiSpectateHandler, "GET", "/api/spectate?g="+g.ID+"&s=bogus")
+	if w.Code == http.StatusOK {
+		t.Errorf("An unknown spectator shouldn't see the game.")
+	}
+}
+
+func TestSpectatorsFollowMessages(t *testing.T) {
+	g := newGame()
+	s := spectate(t, g)
+
+	g.addMessage("Something happened.")
+	w := serve(t, apiMessageHandler, "GET", "/api/message?g="+g.ID+"&s="+s)
+	if !strings.Contains(w.Body.String(), "Something happened.") {
+		t.Errorf("Spectator didn't get the message: %s", w.Body.String())
+	}
+
+	// the players' queue is unaffected.
+	found := false
+	for m := g.getMessage(); m != ""; m = g.getMessage() {
+		found = found || m == "Something happened."
+	}
+	if !found {
+		t.Errorf("A spectator shouldn't take messages away from the players.")
+	}
+}
+
+func TestSpectatorChat(t *testing.T) {
+	g := newGame()
+	s := spectate(t, g)
+	url := "/api/chat?g=" + g.ID + "&s=" + s
+
+	g.sendChat(g.Players[0], "hidden", "")
+	w := serve(t, apiChatHandler, "GET", url)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Spectators shouldn't read chat unless the game allows it.")
+	}
+
+	g.SpectatorChat = true
+	g.sendChat(g.Players[0], "visible", "")
+	g.sendChat(g.Players[0], "whispered", g.Players[1].Name)
+	w = serve(t, apiChatHandler, "GET", url)
+	if !strings.Contains(w.Body.String(), "visible") || strings.Contains(w.Body.String(), "whispered") {
+		t.Errorf("Spectator should get only the public chat: %s", w.Body.String())
+	}
+
+	w = serve(t, apiChatHandler, "POST", url+"&text=hi")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Spectators shouldn't be able to chat.")
+	}
+}
+
+func TestSpectatorsCantAct(t *testing.T) {
+	g := newGame()
+	s := spectate(t, g)
+	w := serve(t, apiActionHandler, "POST", "/api/action?g="+g.ID+"&p="+s+"&abbr=P")
+	if w.Code == http.StatusOK {
+		t.Errorf("A spectator shouldn't be able to act.")
+	}
+}
+
+func TestLobbySpectatorCounts(t *testing.T) {
+	c, restore := useFakeClock()
+	defer restore()
+
+	Games = make(map[string]*Game)
+	g := newGame()
+	spectate(t, g)
+	spectate(t, g)
+
+	w := serve(t, apiGamesHandler, "GET", "/api/games")
+	var lobby []LobbyGame
+	if err := json.Unmarshal(w.Body.Bytes(), &lobby); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+	if len(lobby) != 1 || lobby[0].Spectators != 2 || len(lobby[0].Players) != 3 {
+		t.Errorf("Unexpected lobby: %s", w.Body.String())
+	}
+
+	c.Advance(SpectatorTimeout + time.Second)
+	if n := g.spectatorCount(); n != 0 {
+		t.Errorf("Spectators who stopped polling shouldn't be counted, got %d", n)
+	}
+}
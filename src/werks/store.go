@@ -0,0 +1,156 @@
+package werks
+
+import (
+	"encoding/json"
+	"fmt"
+	"gamework"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GamesDir is the directory games are saved in when the server shuts down,
+// one JSON file per game.  If it's empty, games are only kept in memory.
+var GamesDir = ""
+
+// savedGame is a game as it's saved in GamesDir.  The game itself is saved
+// as its gamework representation, and is restored by replaying it; the rest
+// is what replaying doesn't restore.  TimeBanks are in seconds, by player
+// ID; the current player's decision starts again when the game is
+// restored, so the time the server was down doesn't count against them.
+type savedGame struct {
+	Game          gamework.Game    `json:"game"`
+	TimeControl   *TimeControl     `json:"timeControl"`
+	TimeBanks     map[string]int64 `json:"timeBanks"`
+	Async         bool             `json:"async"`
+	SpectatorChat bool             `json:"spectatorChat"`
+}
+
+// save writes the game to GamesDir.
+func (g *Game) save() error {
+	s := savedGame{
+		Game:          g.gameworkGame(),
+		TimeControl:   g.TimeControl,
+		TimeBanks:     make(map[string]int64),
+		Async:         g.Async,
+		SpectatorChat: g.SpectatorChat}
+	for _, p := range g.Players {
+		s.TimeBanks[p.ID] = int64(p.TimeBank / time.Second)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so that a failed write doesn't
+	// lose the game.
+	path := filepath.Join(GamesDir, g.ID+".json")
+	if err = ioutil.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// saveGames writes every game to GamesDir.  It saves as many as it can,
+// returning the first error.
+func saveGames() error {
+	if GamesDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(GamesDir, 0755); err != nil {
+		return err
+	}
+	var first error
+	for _, g := range Games {
+		if err := g.save(); err != nil && first == nil {
+			first = fmt.Errorf("Couldn't save game %s: %s", g.ID, err)
+		}
+	}
+	return first
+}
+
+// restoreGame replays a saved game.  A saved game that can't be replayed,
+// even one that makes the game panic, is an error.
+func restoreGame(s *savedGame) (g *Game, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			g, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	g = new(Game)
+	if s.Game.Config != nil {
+		if err := g.Configure(*s.Game.Config); err != nil {
+			return nil, err
+		}
+	}
+	if err := g.start(s.Game.Id, s.Game.Name, s.Game.Players, s.Game.Seed); err != nil {
+		return nil, err
+	}
+	for _, a := range s.Game.Actions {
+		p, err := g.getPlayer(a.PlayerId)
+		if err != nil {
+			return nil, err
+		}
+		if err = g.performAction(p, a.Abbr); err != nil {
+			return nil, err
+		}
+	}
+	if err := g.setTimeControl(s.TimeControl); err != nil {
+		return nil, err
+	}
+	for _, p := range g.Players {
+		if bank, ok := s.TimeBanks[p.ID]; ok && g.TimeControl != nil {
+			p.TimeBank = time.Duration(bank) * time.Second
+		}
+	}
+	g.Async = s.Async
+	g.SpectatorChat = s.SpectatorChat
+	g.updateTimeRemaining()
+
+	// the players have already seen the messages replaying added.
+	g.Messages = Queue{Capacity: g.Config.QueueCapacity}
+	return g, nil
+}
+
+// loadGames restores the games saved in GamesDir.  A game that can't be
+// restored is logged and skipped, and its file is left alone, so that one
+// bad file can't keep the server from starting.
+func loadGames() error {
+	if GamesDir == "" {
+		return nil
+	}
+	files, err := ioutil.ReadDir(GamesDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		g, err := loadGame(filepath.Join(GamesDir, f.Name()))
+		if err != nil {
+			log.Printf("Couldn't restore game %s: %s", f.Name(), err)
+			continue
+		}
+		Games[g.ID] = g
+	}
+	return nil
+}
+
+// loadGame restores the game saved in the file at path.
+func loadGame(path string) (*Game, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s savedGame
+	if err = json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return restoreGame(&s)
+}
@@ -0,0 +1,114 @@
+package werks
+
+import (
+	"encoding/json"
+	"gamework"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useGamesDir saves games in a new temporary directory, with no other
+// games running, until the returned function is called.
+func useGamesDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "werks")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	oldGames, oldDir := Games, GamesDir
+	Games, GamesDir = make(map[string]*Game), dir
+	return func() {
+		os.RemoveAll(dir)
+		Games, GamesDir = oldGames, oldDir
+	}
+}
+
+func TestSaveAndLoadGames(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	c, restoreClock := useFakeClock()
+	defer restoreClock()
+	defer useGamesDir(t)()
+
+	g := newGame()
+	if err := g.setTimeControl(&TimeControl{Bank: 60, OnTimeout: "pass"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	g.Async = true
+	playFewActions(t, g)
+	c.Advance(40 * time.Second)
+	g.checkClock()
+	if err := saveGames(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	Games = make(map[string]*Game)
+	if err := loadGames(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	restored, ok := Games[g.ID]
+	if !ok {
+		t.Fatalf("The game wasn't restored.")
+	}
+	// the current decision starts again, rather than being charged for
+	// the time the server was down.
+	g.startDecision()
+	if !restored.Equals(g) {
+		t.Errorf("The restored game should match the original.")
+	}
+	for i, p := range g.Players {
+		if restored.Players[i].TimeBank != p.TimeBank {
+			t.Errorf("%s's time bank should be %s, got %s", p.Name, p.TimeBank, restored.Players[i].TimeBank)
+		}
+	}
+	if restored.Messages.Count != 0 {
+		t.Errorf("The restored game shouldn't repeat its messages, got %d", restored.Messages.Count)
+	}
+}
+
+func TestLoadGamesSkipsBadFiles(t *testing.T) {
+	LocosJsonPath = "../../json/locos.json"
+	defer useGamesDir(t)()
+
+	g := newGame()
+	playFewActions(t, g)
+	if err := g.save(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	onePlayer := g.gameworkGame()
+	onePlayer.Id = "one-player"
+	onePlayer.Players = onePlayer.Players[:1]
+	badAction := g.gameworkGame()
+	badAction.Id = "bad-action"
+	badAction.Actions = []gamework.Action{{Abbr: "nonsense", PlayerId: g.Players[0].ID}}
+	bad := map[string][]byte{"truncated.json": []byte("{")}
+	for _, s := range []gamework.Game{onePlayer, badAction} {
+		b, err := json.Marshal(savedGame{Game: s})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		bad[s.Id+".json"] = b
+	}
+	for name, b := range bad {
+		if err := ioutil.WriteFile(filepath.Join(GamesDir, name), b, 0644); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	Games = make(map[string]*Game)
+	if err := loadGames(); err != nil {
+		t.Fatalf("Bad saved games should be skipped, got %s", err)
+	}
+	if len(Games) != 1 || Games[g.ID] == nil {
+		t.Errorf("Only the good game should be restored, got %d games.", len(Games))
+	}
+}
+
+func TestLoadGamesWithoutDir(t *testing.T) {
+	defer useGamesDir(t)()
+	GamesDir = GamesDir + "/nonexistent"
+	if err := loadGames(); err != nil || len(Games) != 0 {
+		t.Errorf("A missing directory should mean no games, got %d %v", len(Games), err)
+	}
+}
@@ -8,55 +8,60 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
-	"strings"
+	"syscall"
 	"time"
 	"user"
+	"uuid"
 )
 
 var rootPath string
 var users *user.Users
 var en = errors.New
-var verboseLogs = false
 
-// getGameFromRequest finds the game whose ID is in the URL's query string or form.
+// OpenAPIPath is the path of the OpenAPI document describing the API.
+var OpenAPIPath = "./doc/openapi.json"
+
+// getGameFromRequest finds the game whose ID is in the URL's query string or
+// form.  If the request identifies one of the game's players, as p, they're
+// seen as connected.
 func getGameFromRequest(r *http.Request) (*Game, error) {
 	id := r.FormValue("g")
 	g, ok := Games[id]
 	if !ok {
-		return nil, errors.New("Unknown game ID: " + id)
+		return nil, errUnknownGame(id)
+	}
+	if p, err := g.getPlayer(r.FormValue("p")); err == nil {
+		p.LastSeen = clock.Now()
 	}
+	g.checkClock()
 	return g, nil
 }
 
 // getGameAndPlayerFromRequest finds the game and player from the request
 func getGameAndPlayerFromRequest(r *http.Request) (*Game, *Player, error) {
-	var g *Game
-	var p *Player
-	var ok bool
-	var err error
-
-	g_id := r.FormValue("g")
-	p_id := r.FormValue("p")
-	g, ok = Games[g_id]
-	if !ok {
-		return nil, nil, errors.New("Unrecognized game ID.")
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		return nil, nil, err
 	}
-	p, err = g.getPlayer(p_id)
+	p, err := g.getPlayer(r.FormValue("p"))
 	if err != nil {
 		return nil, nil, err
 	}
 	return g, p, nil
 }
 
-// apiGameHandler returns the requested game.
+// apiGameHandler returns the requested game, in which only the player whose
+// ID is p keeps their ID.
 func apiGameHandler(w http.ResponseWriter, r *http.Request) {
 	g, err := getGameFromRequest(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	gameJson := g.getGameJson()
+	gameJson := g.getGameJsonFor(r.FormValue("p"))
 	w.Header().Add("content-type", "application/json")
 	fmt.Fprintf(w, "%s", gameJson)
 }
@@ -73,14 +78,15 @@ func apiLocosHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "%s", locoJson)
 }
 
-// apiPlayersHandler returns the Players in the requested game.
+// apiPlayersHandler returns the Players in the requested game, of whom only
+// the player whose ID is p keeps their ID.
 func apiPlayersHandler(w http.ResponseWriter, r *http.Request) {
 	g, err := getGameFromRequest(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	playersJson := g.getPlayersJson()
+	playersJson := g.getPlayersJson(r.FormValue("p"))
 	w.Header().Add("content-type", "application/json")
 	fmt.Fprintf(w, "%s", playersJson)
 }
@@ -91,7 +97,17 @@ func apiMessageHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	messageJson := g.getMessageJson()
+	var messageJson []byte
+	if r.FormValue("s") != "" {
+		var s *Spectator
+		if s, err = g.getSpectator(r.FormValue("s")); err != nil {
+			serveError(w, err)
+			return
+		}
+		messageJson = popMessageJson(&s.Messages)
+	} else {
+		messageJson = g.getMessageJson()
+	}
 	if messageJson == nil {
 		return
 	}
@@ -99,35 +115,177 @@ func apiMessageHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "%s", messageJson)
 }
 
-type ChatMessageJson struct {
-	Who  string `json:"who"`
-	Text string `json:"text"`
+// getChatSince returns the since form value, the ID of the last chat
+// message the client has seen.
+func getChatSince(r *http.Request) int {
+	since, _ := strconv.Atoi(r.FormValue("since"))
+	return since
 }
 
+// serveChat writes chat messages (or a single message) as JSON.
+func serveChat(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Add("content-type", "application/json")
+	fmt.Fprintf(w, "%s", b)
+}
+
+// apiChatHandler handles the game's chat.  GET returns the messages after
+// the one whose ID is since that the player can see, and POST sends text
+// (whispering it to the player whose name is to, if it's set) and returns
+// the message that was sent.
 func apiChatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("s") != "" {
+		apiSpectatorChatHandler(w, r)
+		return
+	}
 	g, p, err := getGameAndPlayerFromRequest(r)
 	if err != nil {
 		serveError(w, err)
 		return
 	}
 	if r.Method == "GET" {
-		e := p.ChatMessages.Pop()
-		if e == nil {
+		messages, err := g.chat().since(getChatSince(r), p.ID)
+		if err != nil {
+			serveError(w, err)
 			return
 		}
-		m := e.Value.(ChatMessage)
-		c := ChatMessageJson{Who: m.Player.Name, Text: m.Text}
-		b, err := json.Marshal(c)
+		serveChat(w, messages)
+	}
+	if r.Method == "POST" {
+		m, err := g.sendChat(p, r.FormValue("text"), r.FormValue("to"))
 		if err != nil {
-			panic(err)
+			serveError(w, err)
+			return
 		}
-		w.Header().Add("content-type", "application/json")
-		fmt.Fprintf(w, "%s", b)
+		serveChat(w, m)
+	}
+}
+
+// apiSpectatorChatHandler returns the public chat messages after the one
+// whose ID is since to a spectator.  Spectators can't chat, and can only
+// read the chat if the game allows it.
+func apiSpectatorChatHandler(w http.ResponseWriter, r *http.Request) {
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	if _, err = g.getSpectator(r.FormValue("s")); err != nil {
+		serveError(w, err)
+		return
+	}
+	if r.Method != "GET" || !g.SpectatorChat {
+		http.Error(w, "Spectators can't chat.", http.StatusForbidden)
+		return
+	}
+	messages, err := g.chat().since(getChatSince(r), "")
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	serveChat(w, messages)
+}
+
+// apiLobbyChatHandler handles the lobby's chat.  GET returns the messages
+// after the one whose ID is since, and POST sends text from the user whose
+// token is u, returning the message that was sent.
+func apiLobbyChatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		messages, err := lobbyChat.since(getChatSince(r), "")
+		if err != nil {
+			serveError(w, err)
+			return
+		}
+		serveChat(w, messages)
 	}
 	if r.Method == "POST" {
-		text := r.FormValue("text")
-		g.pushChatMessage(p, text)
+		u := users.LookupByToken(r.FormValue("u"))
+		if u == nil {
+			serveError(w, errUnknownToken)
+			return
+		}
+		m, err := sendLobbyChat(u.Id, u.Nickname, r.FormValue("text"))
+		if err != nil {
+			serveError(w, err)
+			return
+		}
+		serveChat(w, m)
+	}
+}
+
+// SpectateResponse is returned to a spectator who joins a game.
+type SpectateResponse struct {
+	Spectator string         `json:"spectator"`
+	View      *SpectatorView `json:"view"`
+}
+
+// apiSpectateHandler lets spectators watch a game.  POST joins the game
+// (with the user's token in u, if the spectator is logged in) and returns
+// the spectator's ID, GET returns what the spectator with ID s can see of
+// the game, and DELETE stops watching it.
+func apiSpectateHandler(w http.ResponseWriter, r *http.Request) {
+	g, err := getGameFromRequest(r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+
+	var result interface{}
+	switch r.Method {
+	case "POST":
+		userID := ""
+		if u := users.LookupByToken(r.FormValue("u")); u != nil {
+			userID = u.Id
+		}
+		s := g.addSpectator(userID)
+		result = &SpectateResponse{Spectator: s.ID, View: g.getSpectatorView()}
+	case "GET":
+		if _, err = g.getSpectator(r.FormValue("s")); err != nil {
+			serveError(w, err)
+			return
+		}
+		result = g.getSpectatorView()
+	case "DELETE":
+		delete(g.Spectators, r.FormValue("s"))
+		return
+	default:
+		return
 	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Add("content-type", "application/json")
+	fmt.Fprintf(w, "%s", b)
+}
+
+// apiCatalogsHandler returns the names of the catalogs a new game can use.
+func apiCatalogsHandler(w http.ResponseWriter, r *http.Request) {
+	names, err := catalogNames()
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	b, err := json.Marshal(names)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Add("content-type", "application/json")
+	fmt.Fprintf(w, "%s", b)
+}
+
+// apiGamesHandler returns the lobby's list of games.
+func apiGamesHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(getLobbyGames())
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Add("content-type", "application/json")
+	fmt.Fprintf(w, "%s", b)
 }
 
 // serveError writes an error message.
@@ -164,9 +322,11 @@ func apiLoginHandler(w http.ResponseWriter, r *http.Request) {
 	password := r.FormValue("p")
 	responseJson := invalidUserResponse("Invalid login.")
 	if username != "" && password != "" {
-		u, err := users.Login(username, password)
+		u, err := login(r, username, password)
 		if err == nil {
 			responseJson = validUserResponse(u)
+		} else if e, ok := err.(*APIError); ok {
+			responseJson = invalidUserResponse(e.Message)
 		}
 	}
 	w.Header().Add("content-type", "application/json")
@@ -176,43 +336,80 @@ func apiLoginHandler(w http.ResponseWriter, r *http.Request) {
 // apiRegisterHandler registers a user
 func apiRegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var responseJson []byte
-	var err error
-	var u *user.User
-
-	username := r.FormValue("u")
-	password := r.FormValue("p")
 
-	u, err = users.Register(username, password)
+	u, err := register(r, r.FormValue("u"), r.FormValue("p"))
 	if err != nil {
 		responseJson = invalidUserResponse(fmt.Sprintf("%s", err))
 	} else {
-		err = users.SaveUsers()
-		if err != nil {
-			responseJson = invalidUserResponse("Registration failed.")
-		} else {
-			responseJson = validUserResponse(u)
-		}
+		responseJson = validUserResponse(u)
 	}
 
 	w.Header().Add("content-type", "application/json")
 	fmt.Fprintf(w, "%s", responseJson)
 }
 
-// apiNewGameHandler creates a new game.
-func apiNewGameHandler(w http.ResponseWriter, r *http.Request) {
-	var err error
+// getTimeControlFromRequest returns the TimeControl described by the
+// perDecision, bank and onTimeout form values, or nil if the game isn't
+// to be timed.  It fails if either time isn't a number.
+func getTimeControlFromRequest(r *http.Request) (*TimeControl, error) {
+	seconds := func(key string) (int, error) {
+		v := r.FormValue(key)
+		if v == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be a whole number of seconds, not %q.", key, v)
+		}
+		return n, nil
+	}
+	perDecision, err := seconds("perDecision")
+	if err != nil {
+		return nil, err
+	}
+	bank, err := seconds("bank")
+	if err != nil {
+		return nil, err
+	}
+	if perDecision == 0 && bank == 0 {
+		return nil, nil
+	}
+	return &TimeControl{
+		PerDecision: perDecision,
+		Bank:        bank,
+		OnTimeout:   r.FormValue("onTimeout")}, nil
+}
 
-	if r.Method != "POST" {
-		return
+// getGameConfigFromRequest returns the GameConfig described by the config
+// form value (a JSON object overriding the DefaultGameConfig).  The catalog
+// form value, if set, overrides the config's catalog.
+func getGameConfigFromRequest(r *http.Request) (*GameConfig, error) {
+	config, err := parseGameConfig(r.FormValue("config"))
+	if err != nil {
+		return nil, err
 	}
-	if err = r.ParseForm(); err != nil {
-		serveError(w, err)
-		return
+	if catalog := r.FormValue("catalog"); catalog != "" {
+		config.Catalog = catalog
 	}
+	return config, nil
+}
 
-	var playerCount int
-	if playerCount, err = strconv.Atoi(r.FormValue("playerCount")); err != nil {
-		serveError(w, err)
+// newGameFromRequest creates the new game described by the request.
+func newGameFromRequest(r *http.Request) (*Game, error) {
+	config, err := getGameConfigFromRequest(r)
+	if err != nil {
+		return nil, badRequest("invalid_config", err)
+	}
+	playerCount, err := strconv.Atoi(r.FormValue("playerCount"))
+	if err != nil {
+		return nil, badRequest("bad_request", err)
+	}
+	if err = config.checkPlayerCount(playerCount); err != nil {
+		return nil, badRequest("invalid_config", err)
+	}
+	tc, err := getTimeControlFromRequest(r)
+	if err != nil {
+		return nil, badRequest("invalid_time_control", err)
 	}
 
 	name := r.FormValue("name")
@@ -222,12 +419,84 @@ func apiNewGameHandler(w http.ResponseWriter, r *http.Request) {
 		playerNames[i] = r.FormValue(key)
 	}
 
-	g := makeNewGame(name, playerNames)
+	g, err := makeNewGame(name, playerNames, config)
+	if err != nil {
+		return nil, badRequest("invalid_config", err)
+	}
+	if err = g.setTimeControl(tc); err != nil {
+		delete(Games, g.ID)
+		return nil, badRequest("invalid_time_control", err)
+	}
+	if err = claimCreatorSeat(r, g); err != nil {
+		delete(Games, g.ID)
+		return nil, err
+	}
+	g.SpectatorChat = r.FormValue("spectatorChat") == "true"
+	if r.FormValue("async") == "true" {
+		g.Async = true
+		g.notifyTurn(g.getCurrentPlayer())
+	}
+	e := AuditEvent{Kind: AuditGameCreated, GameID: g.ID, Detail: g.Name}
+	if u := userFromRequest(r); u != nil {
+		e.UserID = u.Id
+		e.Nickname = u.Nickname
+	}
+	audit(r, e)
+	return g, nil
+}
+
+// claimCreatorSeat claims the player at the index in the seat form value, if
+// it's set, for the user whose token is u.
+func claimCreatorSeat(r *http.Request, g *Game) error {
+	if r.FormValue("seat") == "" {
+		return nil
+	}
+	u := userFromRequest(r)
+	if u == nil {
+		return errUnknownToken
+	}
+	seat, err := strconv.Atoi(r.FormValue("seat"))
+	if err != nil || seat < 0 || seat >= len(g.Players) {
+		return apiError(http.StatusBadRequest, "bad_request", "There's no seat %s.", r.FormValue("seat"))
+	}
+	return g.claimSeat(g.Players[seat], u)
+}
+
+// apiNewGameHandler creates a new game.
+func apiNewGameHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		serveError(w, err)
+		return
+	}
+	g, err := newGameFromRequest(r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
 	gameJson := g.getGameJson()
 	w.Header().Add("content-type", "application/json")
 	fmt.Fprintf(w, "%s", gameJson)
 }
 
+// apiAwaitingHandler returns the asynchronous games that are waiting on the
+// user whose token is given.
+func apiAwaitingHandler(w http.ResponseWriter, r *http.Request) {
+	u := users.LookupByToken(r.FormValue("u"))
+	if u == nil {
+		serveError(w, errUnknownToken)
+		return
+	}
+	b, err := json.Marshal(gamesAwaitingMove(u.Id))
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Add("content-type", "application/json")
+	fmt.Fprintf(w, "%s", b)
+}
+
 func apiActionHandler(w http.ResponseWriter, r *http.Request) {
 	var g *Game
 	var p *Player
@@ -236,6 +505,7 @@ func apiActionHandler(w http.ResponseWriter, r *http.Request) {
 	g, p, err = getGameAndPlayerFromRequest(r)
 	if err != nil {
 		serveError(w, err)
+		return
 	}
 	if r.Method == "GET" {
 		if !p.IsCurrent {
@@ -254,15 +524,24 @@ func apiActionHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		abbr := r.FormValue("abbr")
-		g.performAction(abbr)
+		if err = g.playAction(p, abbr); err != nil {
+			serveError(w, err)
+			return
+		}
 
-		gameStateJson := g.getGameStateJson()
+		gameStateJson := g.getGameStateJson(p.ID)
 		w.Header().Add("content-type", "application/json")
 		fmt.Fprintf(w, "%s", gameStateJson)
 		return
 	}
 }
 
+// apiOpenAPIHandler returns the OpenAPI document describing the API.
+func apiOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+	http.ServeFile(w, r, filepath.Join(rootPath, OpenAPIPath))
+}
+
 // handleContentRequest handles most HTTP GET requests for static resources.
 func handleContentRequest(w http.ResponseWriter, r *http.Request) {
 	path := rootPath + r.URL.Path
@@ -293,66 +572,172 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 	file.Close()
 }
 
-func initApp() {
+// initApp sets up the server's state from its configuration.
+func initApp(config *ServerConfig) error {
 	rand.Seed(time.Now().UnixNano())
-	users = user.Init("CaCl", "users.json")
-
-	// load the users file, and create a default user if no users
-	// file is found.
-	var err error
+	rootPath = config.RootPath
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return err
+	}
+	auditPath := config.AuditLog
+	if auditPath == "" {
+		auditPath = filepath.Join(config.DataDir, "audit.log")
+	}
+	f, err := os.OpenFile(auditPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	AuditLog = f
+	Limits = config.Limits
+	if users != nil {
+		users.Unlock()
+	}
+	users = user.Init(config.Salt, filepath.Join(config.DataDir, "users.json"))
+	users.Policy = config.Policy
+	if err = users.Lock(); err != nil {
+		return err
+	}
 
+	// load the users file, recovering it from a backup if it's corrupt,
+	// and create a default user if there's no users file yet.
 	err = users.LoadUsers()
-	if err != nil {
-		_, err = users.Register("admin", "admin")
-		if err != nil {
-			panic(err)
+	if _, corrupt := err.(*user.CorruptError); corrupt {
+		backup, rerr := users.Recover()
+		if rerr != nil {
+			return fmt.Errorf("%s; %s", err, rerr)
 		}
-		err = users.SaveUsers()
-		if err != nil {
-			panic(err)
+		detail := fmt.Sprintf("%s; restored the users from %s.", err, backup)
+		log.Print(detail)
+		audit(nil, AuditEvent{Kind: AuditRecovered, Detail: detail})
+	} else if os.IsNotExist(err) {
+		if err = createAdmin(config.AdminPassword); err != nil {
+			return err
 		}
+	} else if err != nil {
+		return err
+	}
+
+	// keep chat histories and saved games alongside the users file.
+	ChatDir = filepath.Join(config.DataDir, "chat")
+	if err = os.MkdirAll(ChatDir, 0755); err != nil {
+		return err
 	}
+	GamesDir = filepath.Join(config.DataDir, "games")
+	return loadGames()
 }
 
-func Serve(path string) {
-	rootPath = path
+// createAdmin creates the admin user, with the password given or, if it's
+// empty, a random one that's logged once, and saves the users.  The
+// password must satisfy the users' Policy, even though the nickname is
+// reserved.
+func createAdmin(password string) error {
+	generated := password == ""
+	if generated {
+		var err error
+		if password, err = uuid.GenUUID(); err != nil {
+			return err
+		}
+	}
+	if errs := users.Policy.ValidatePassword(password); len(errs) > 0 {
+		return fmt.Errorf("The admin password isn't allowed: %s", errs)
+	}
+	u, err := users.Add("admin", password)
+	if err != nil {
+		return err
+	}
+	if err = users.SaveUsers(); err != nil {
+		return err
+	}
+	audit(nil, AuditEvent{Kind: AuditAdmin, UserID: u.Id, Nickname: u.Nickname,
+		Detail: "Created the default admin user."})
+	if generated {
+		log.Printf("Created the admin user, with the password %s.", password)
+	}
+	return nil
+}
 
-	initApp()
+// apiHandlers contains the handlers for the original API calls, by path.
+// Every path is described in the OpenAPI document at OpenAPIPath.
+var apiHandlers = map[string]http.HandlerFunc{
+	"/api/locos":        apiLocosHandler,
+	"/api/login":        apiLoginHandler,
+	"/api/register":     apiRegisterHandler,
+	"/api/players":      apiPlayersHandler,
+	"/api/game":         apiGameHandler,
+	"/api/newGame":      apiNewGameHandler,
+	"/api/message":      apiMessageHandler,
+	"/api/chat":         apiChatHandler,
+	"/api/lobbyChat":    apiLobbyChatHandler,
+	"/api/action":       apiActionHandler,
+	"/api/awaiting":     apiAwaitingHandler,
+	"/api/spectate":     apiSpectateHandler,
+	"/api/games":        apiGamesHandler,
+	"/api/replay":       apiReplayHandler,
+	"/api/catalogs":     apiCatalogsHandler,
+	"/api/ledger":       apiLedgerHandler,
+	"/api/openapi.json": apiOpenAPIHandler,
+	"/api/users/":       apiUsersEndpoint.ServeHTTP,
+}
 
-	// register handlers for the static URLs
-	static_dirs := []string{"css", "html", "js", "lib", "views"}
-	for _, path := range static_dirs {
-		http.HandleFunc("/"+path+"/", handleContentRequest)
+// gamelessPaths are the API paths that never touch a game, and so don't
+// wait for gamesMutex.  Logging in and registering are slow on purpose.
+var gamelessPaths = map[string]bool{
+	"/api/login":        true,
+	"/api/register":     true,
+	"/api/catalogs":     true,
+	"/api/openapi.json": true,
+	"/api/v1/login":     true,
+	"/api/v1/register":  true,
+	"/api/v1/catalogs":  true,
+}
+
+// handleAPI adds the handler for an API path to mux, holding gamesMutex
+// unless the path is gameless.
+func handleAPI(mux *http.ServeMux, path string, handler http.Handler) {
+	if !gamelessPaths[path] {
+		handler = lockGames(handler)
 	}
+	mux.Handle(path, handler)
+}
 
-	// register handlers for API calls
-	http.HandleFunc("/", rootHandler)
-	http.HandleFunc("/api/locos", apiLocosHandler)
-	http.HandleFunc("/api/login", apiLoginHandler)
-	http.HandleFunc("/api/register", apiRegisterHandler)
-	http.HandleFunc("/api/players", apiPlayersHandler)
-	http.HandleFunc("/api/game", apiGameHandler)
-	http.HandleFunc("/api/newGame", apiNewGameHandler)
-	http.HandleFunc("/api/message", apiMessageHandler)
-	http.HandleFunc("/api/chat", apiChatHandler)
-	http.HandleFunc("/api/action", apiActionHandler)
+// registerHandlers adds the handlers for the static directories and the API
+// to mux.
+func registerHandlers(mux *http.ServeMux, staticDirs []string) {
+	for _, path := range staticDirs {
+		mux.HandleFunc("/"+path+"/", handleContentRequest)
+	}
 
-	// start serving
-	http.ListenAndServe(":8080", Log(http.DefaultServeMux))
+	mux.HandleFunc("/", rootHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	for path, handler := range apiHandlers {
+		handleAPI(mux, path, handler)
+	}
+	registerAPIV1(mux)
 }
 
-func Log(handler http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if logUrl(r.URL.String()) {
-			log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL)
-		}
-		handler.ServeHTTP(w, r)
-	})
-}
+// Serve runs the server with the given configuration, until it gets
+// SIGINT or SIGTERM; see serveUntil.
+func Serve(config *ServerConfig) error {
+	if err := initApp(config); err != nil {
+		return err
+	}
+	registerHandlers(http.DefaultServeMux, config.StaticDirs)
 
-func logUrl(url string) bool {
-	if verboseLogs {
-		return true
+	// remind players of the asynchronous games waiting on them
+	go remindPeriodically(time.Hour)
+
+	// start serving
+	srv := &http.Server{
+		Addr:    config.Addr,
+		Handler: Log(http.DefaultServeMux, config.Verbose)}
+	listen := srv.ListenAndServe
+	if config.TLSCert != "" {
+		listen = func() error {
+			return srv.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+		}
 	}
-	return !strings.HasPrefix(url, "/api/message") && !strings.HasPrefix(url, "/api/chat")
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	log.Printf("Listening on %s.", config.Addr)
+	return serveUntil(srv, listen, stop)
 }
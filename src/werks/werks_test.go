@@ -1,6 +1,8 @@
 package werks
 
 import (
+	"gamework"
+	"strings"
 	"testing"
 )
 
@@ -50,27 +52,11 @@ func newGame() *Game {
 	name := "test"
 	names := []string{"Abel", "Baker", "Charlie"}
 	LocosJsonPath = "../../json/locos.json"
-	g := makeNewGame(name, names)
-	return g
-}
-
-func TestAddChatMessage(t *testing.T) {
-	g := newGame()
-	if g.Name != "test" {
-		t.Errorf("Game has the wrong name.")
-	}
-	g.addChatMessage(g.Players[0], "test message")
-	for _, p := range g.Players {
-		t.Logf("Player %s", p.Name)
-		c := g.getChatMessage(p)
-		if c == nil {
-			t.Errorf("Should have received at least one chat message.")
-		}
-		c = g.getChatMessage(p)
-		if c != nil {
-			t.Errorf("Should have received only one chat message.")
-		}
+	g, err := makeNewGame(name, names, nil)
+	if err != nil {
+		panic(err)
 	}
+	return g
 }
 
 func TestIsLocoAvailableForDevelopment(t *testing.T) {
@@ -80,7 +66,7 @@ func TestIsLocoAvailableForDevelopment(t *testing.T) {
 		avail := g.isLocoAvailableForDevelopment(loco)
 		if avail {
 			if loco.Key != "a1" {
-				t.Errorf("%s.InitialOrders.Render = %s", loco.Key, loco.InitialOrders.Render)
+				t.Errorf("%s.InitialOrders.Render = %t", loco.Key, loco.InitialOrders.Render)
 				t.Errorf("%s is available and shouldn't be", loco.Key)
 			}
 		} else {
@@ -121,9 +107,13 @@ func TestGetNextPlayer(t *testing.T) {
 	var p *Player
 	var expectedNames []string
 
+	// the start player, Abel, is already taking the first turn.
 	t.Logf("wrap = true")
 	g = newGame()
-	expectedNames = []string{"Abel", "Baker", "Charlie", "Abel", "Baker", "Charlie"}
+	if g.getCurrentPlayer().Name != "Abel" {
+		t.Errorf("Abel should start, not %s", g.getCurrentPlayer().Name)
+	}
+	expectedNames = []string{"Baker", "Charlie", "Abel", "Baker", "Charlie", "Abel"}
 	for i, name := range expectedNames {
 		p = g.getNextPlayer(true)
 		if p.Name != name {
@@ -133,7 +123,7 @@ func TestGetNextPlayer(t *testing.T) {
 
 	t.Logf("wrap = false")
 	g = newGame()
-	expectedNames = []string{"Abel", "Baker", "Charlie"}
+	expectedNames = []string{"Baker", "Charlie"}
 	for i, name := range expectedNames {
 		p = g.getNextPlayer(false)
 		if p.Name != name {
@@ -146,3 +136,68 @@ func TestGetNextPlayer(t *testing.T) {
 	}
 
 }
+
+func TestPerformActionRejectsIllegalInput(t *testing.T) {
+	g := newGame()
+	current := g.getCurrentPlayer()
+	var other *Player
+	for _, p := range g.Players {
+		if p != current {
+			other = p
+		}
+	}
+
+	err := g.performAction(other, "P")
+	if _, ok := err.(*gamework.NotYourTurnError); !ok {
+		t.Errorf("Expected NotYourTurnError, got %v", err)
+	}
+	err = g.performAction(current, "D:s1")
+	if _, ok := err.(*gamework.UnknownOptionError); !ok {
+		t.Errorf("Expected UnknownOptionError, got %v", err)
+	}
+	if g.getCurrentPlayer() != current {
+		t.Errorf("Rejected actions shouldn't change the current player.")
+	}
+
+	if err = g.performAction(current, "P"); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestTurnOrderRecomputedEachTurn(t *testing.T) {
+	g := newGame()
+	for _, abbr := range []string{"D:a1", "P", "P"} {
+		if err := g.performAction(g.getCurrentPlayer(), abbr); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	before := len(g.Log)
+	for i := 0; i < 2*len(g.Players); i++ {
+		if err := g.performAction(g.getCurrentPlayer(), "P"); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if g.Turn != 2 || g.Phase != Development {
+		t.Fatalf("Expected turn 2 to have started, got turn %d, phase %d.", g.Turn, g.Phase)
+	}
+
+	expectedNames := []string{"Baker", "Charlie", "Abel"}
+	for _, name := range expectedNames {
+		if g.getCurrentPlayer().Name != name {
+			t.Errorf("Expected %s to be current, got %s.", name, g.getCurrentPlayer().Name)
+		}
+		if err := g.performAction(g.getCurrentPlayer(), "P"); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	log := strings.Join(g.Log[before:], "\n")
+	for _, expected := range []string{
+		"Turn order: Baker (12), Charlie (12), Abel (4).",
+		"Baker goes before Charlie: both have 12, and Baker was ahead of Charlie in the last turn order.",
+	} {
+		if !strings.Contains(log, expected) {
+			t.Errorf("The log should contain %q:\n%s", expected, log)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// Package werksclient is a client for the werks server's /api/v1 endpoints,
+// for bots and other automation.  Its types match the schemas in the
+// server's OpenAPI document, doc/openapi.json.
+package werksclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the werks API at BaseURL, e.g. "http://localhost:8080".
+// Token is the logged in user's token; Login and Register set it.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Token      string
+}
+
+// New returns a client for the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: http.DefaultClient}
+}
+
+// Error is an error returned by the server.  Code is machine-readable, e.g.
+// "game_not_found" or "not_your_turn".  If the error is in the values of
+// form fields, such as a nickname that's taken, Fields says what's wrong
+// with each.
+type Error struct {
+	Status  int           `json:"-"`
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Fields  []*FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is what's wrong with the value of a form field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.Status, e.Code)
+}
+
+// IsCode returns whether err is an Error with the given code.
+func IsCode(err error, code string) bool {
+	e, ok := err.(*Error)
+	return ok && e.Code == code
+}
+
+// Die is a slot for a die, and the die in it, if Pips isn't 0.
+type Die struct {
+	Pips   int  `json:"pips"`
+	Render bool `json:"render"`
+}
+
+// Loco is a locomotive type, and its state in a game.
+type Loco struct {
+	Kind              string `json:"kind"`
+	Generation        int    `json:"generation"`
+	Name              string `json:"name"`
+	Years             string `json:"years"`
+	DevelopmentCost   int    `json:"developmentCost"`
+	ProductionCost    int    `json:"productionCost"`
+	Income            int    `json:"income"`
+	MaxExistingOrders int    `json:"maxExistingOrders"`
+	MaxCustomerBase   int    `json:"maxCustomerBase"`
+	Count             int    `json:"count"`
+	Key               string `json:"key"`
+	UpgradeTo         string `json:"upgradeTo"`
+	UpgradeCost       int    `json:"upgradeCost"`
+	ExistingOrders    []Die  `json:"existingOrders"`
+	InitialOrders     Die    `json:"initialOrders"`
+	CustomerBase      []Die  `json:"customerBase"`
+	Obsolete          bool   `json:"obsolete"`
+}
+
+// Factory is a factory owned by a player.
+type Factory struct {
+	Key       string `json:"key"`
+	Capacity  int    `json:"capacity"`
+	UnitsSold int    `json:"unitsSold"`
+}
+
+// Player is one of the players in a game.  Profile is the profile of the
+// user playing, if the player is linked to one.  The server only sends a
+// player's ID to the player themselves, and to whoever created the game.
+type Player struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	UserID        string    `json:"userId"`
+	Money         int       `json:"money"`
+	Factories     []Factory `json:"factories"`
+	IsCurrent     bool      `json:"isCurrent"`
+	TurnOrder     int       `json:"turnOrder"`
+	TimeRemaining int       `json:"timeRemaining"`
+	Loans         int       `json:"loans"`
+	Eliminated    bool      `json:"eliminated"`
+	Profile       *Profile  `json:"profile,omitempty"`
+}
+
+// Profile is what a user tells other players about themselves.
+type Profile struct {
+	DisplayName string   `json:"displayName,omitempty"`
+	AvatarURL   string   `json:"avatarUrl,omitempty"`
+	Colors      []string `json:"colors,omitempty"`
+	Bio         string   `json:"bio,omitempty"`
+}
+
+// TimeControl is how long players have to decide, in seconds.
+type TimeControl struct {
+	PerDecision int    `json:"perDecision"`
+	Bank        int    `json:"bank"`
+	OnTimeout   string `json:"onTimeout"`
+}
+
+// Game is a game.  Phase is 1 for development, 2 for capacity and 3 for
+// production.  Config is the options it was set up with.
+type Game struct {
+	ID            string          `json:"id"`
+	Name          string          `json:"name"`
+	Players       []Player        `json:"players"`
+	Locos         []Loco          `json:"locos"`
+	Turn          int             `json:"turn"`
+	StartPlayer   int             `json:"startPlayer"`
+	CurrentPlayer int             `json:"currentPlayer"`
+	Phase         int             `json:"phase"`
+	TimeControl   *TimeControl    `json:"timeControl"`
+	Async         bool            `json:"async"`
+	Config        json.RawMessage `json:"config"`
+	Over          bool            `json:"over"`
+	SpectatorChat bool            `json:"spectatorChat"`
+}
+
+// Current returns the player whose turn it is, or nil if there's none.
+func (g *Game) Current() *Player {
+	for i := range g.Players {
+		if g.Players[i].IsCurrent {
+			return &g.Players[i]
+		}
+	}
+	return nil
+}
+
+// Action is an action available to the current player.
+type Action struct {
+	Abbr string `json:"abbr"`
+	Verb string `json:"verb"`
+	Noun string `json:"noun"`
+	Cost int    `json:"cost"`
+}
+
+// Actions are the actions available in the current phase.
+type Actions struct {
+	Phase   string   `json:"phase"`
+	Actions []Action `json:"actions"`
+}
+
+// GameState is a game, with the actions now available.
+type GameState struct {
+	Game    *Game    `json:"game"`
+	Actions *Actions `json:"actions"`
+}
+
+// ChatMessage is a message in a game's chat or the lobby's.
+type ChatMessage struct {
+	ID     int       `json:"id"`
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	UserID string    `json:"userId"`
+	Who    string    `json:"who"`
+	ToName string    `json:"toName,omitempty"`
+	Text   string    `json:"text"`
+}
+
+// NewGame describes a game to create.  Config is a JSON GameConfig
+// overriding the server's defaults, and may be empty.  If PerDecision or
+// Bank is set, the game is timed.
+type NewGame struct {
+	Name          string
+	Players       []string
+	Config        string
+	Catalog       string
+	PerDecision   int
+	Bank          int
+	OnTimeout     string
+	Async         bool
+	SpectatorChat bool
+}
+
+func (n *NewGame) form() url.Values {
+	form := url.Values{
+		"name":        {n.Name},
+		"playerCount": {strconv.Itoa(len(n.Players))},
+		"config":      {n.Config},
+		"catalog":     {n.Catalog},
+		"onTimeout":   {n.OnTimeout}}
+	for i, name := range n.Players {
+		form.Set(fmt.Sprintf("player%d", i), name)
+	}
+	if n.PerDecision != 0 || n.Bank != 0 {
+		form.Set("perDecision", strconv.Itoa(n.PerDecision))
+		form.Set("bank", strconv.Itoa(n.Bank))
+	}
+	form.Set("async", strconv.FormatBool(n.Async))
+	form.Set("spectatorChat", strconv.FormatBool(n.SpectatorChat))
+	return form
+}
+
+// do calls the endpoint at /api/v1/path, sending form in the query for a GET
+// and in the body otherwise, and decodes the response into v, unless
+// there's no content.
+func (c *Client) do(method, path string, form url.Values, v interface{}) error {
+	u := c.BaseURL + "/api/v1/" + path
+	var body io.Reader
+	if method == "GET" {
+		u += "?" + form.Encode()
+	} else {
+		body = strings.NewReader(form.Encode())
+	}
+	r, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := c.HTTPClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var e struct {
+			Error *Error `json:"error"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == nil {
+			return &Error{Status: resp.StatusCode, Code: "unknown", Message: resp.Status}
+		}
+		e.Error.Status = resp.StatusCode
+		return e.Error
+	}
+	if resp.StatusCode == http.StatusNoContent || v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Login logs in, setting the client's Token.
+func (c *Client) Login(nickname, password string) error {
+	return c.authenticate("login", nickname, password)
+}
+
+// Register registers a new user, and logs in as them.
+func (c *Client) Register(nickname, password string) error {
+	return c.authenticate("register", nickname, password)
+}
+
+func (c *Client) authenticate(path, nickname, password string) error {
+	var t struct {
+		Token string `json:"token"`
+	}
+	if err := c.do("POST", path, url.Values{"u": {nickname}, "p": {password}}, &t); err != nil {
+		return err
+	}
+	c.Token = t.Token
+	return nil
+}
+
+// CreateGame creates a new game.
+func (c *Client) CreateGame(n NewGame) (*Game, error) {
+	var g Game
+	if err := c.do("POST", "games", n.form(), &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// Game returns the game with the given ID.
+func (c *Client) Game(gameID string) (*Game, error) {
+	var g Game
+	if err := c.do("GET", "game", url.Values{"g": {gameID}}, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ClaimSeat claims the player for the logged in user, returning the game.
+func (c *Client) ClaimSeat(gameID, playerID string) (*Game, error) {
+	var g Game
+	form := url.Values{"g": {gameID}, "p": {playerID}, "u": {c.Token}}
+	if err := c.do("POST", "seat", form, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// Actions returns the actions available to a player, which are none unless
+// it's their turn.
+func (c *Client) Actions(gameID, playerID string) (*Actions, error) {
+	var a Actions
+	if err := c.do("GET", "actions", url.Values{"g": {gameID}, "p": {playerID}}, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Act performs the action with the given abbreviation for a player.
+func (c *Client) Act(gameID, playerID, abbr string) (*GameState, error) {
+	var s GameState
+	form := url.Values{"g": {gameID}, "p": {playerID}, "abbr": {abbr}}
+	if err := c.do("POST", "actions", form, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Chat returns the messages in a game's chat after the one with ID since
+// that a player can see.
+func (c *Client) Chat(gameID, playerID string, since int) ([]ChatMessage, error) {
+	var messages []ChatMessage
+	form := url.Values{"g": {gameID}, "p": {playerID}, "since": {strconv.Itoa(since)}}
+	if err := c.do("GET", "chat", form, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// SendChat sends text to a game's chat from a player.  If to is set, it's
+// the name of the player to whisper to.
+func (c *Client) SendChat(gameID, playerID, text, to string) (*ChatMessage, error) {
+	var m ChatMessage
+	form := url.Values{"g": {gameID}, "p": {playerID}, "text": {text}, "to": {to}}
+	if err := c.do("POST", "chat", form, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// LobbyChat returns the messages in the lobby's chat after the one with ID
+// since.
+func (c *Client) LobbyChat(since int) ([]ChatMessage, error) {
+	var messages []ChatMessage
+	if err := c.do("GET", "lobby/chat", url.Values{"since": {strconv.Itoa(since)}}, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// SendLobbyChat sends text to the lobby's chat as the logged in user.
+func (c *Client) SendLobbyChat(text string) (*ChatMessage, error) {
+	var m ChatMessage
+	if err := c.do("POST", "lobby/chat", url.Values{"u": {c.Token}, "text": {text}}, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
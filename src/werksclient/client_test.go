@@ -0,0 +1,98 @@
+package werksclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeServer records the last request, and answers every request with the
+// given status and body.
+func fakeServer(status int, body string, last **http.Request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		*last = r
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+}
+
+func TestAct(t *testing.T) {
+	var r *http.Request
+	s := fakeServer(http.StatusOK, `{"game":{"id":"g1","players":[{"id":"p2","isCurrent":true}]},"actions":{"phase":"Locomotive Development"}}`, &r)
+	defer s.Close()
+
+	state, err := New(s.URL+"/").Act("g1", "p1", "P")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if r.Method != "POST" || r.URL.Path != "/api/v1/actions" {
+		t.Errorf("Expected POST /api/v1/actions, got %s %s", r.Method, r.URL.Path)
+	}
+	if r.PostForm.Get("g") != "g1" || r.PostForm.Get("p") != "p1" || r.PostForm.Get("abbr") != "P" {
+		t.Errorf("Unexpected form: %v", r.PostForm)
+	}
+	if state.Game.ID != "g1" || state.Game.Current().ID != "p2" || state.Actions.Phase != "Locomotive Development" {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+}
+
+func TestCreateGameForm(t *testing.T) {
+	var r *http.Request
+	s := fakeServer(http.StatusCreated, `{"id":"g1"}`, &r)
+	defer s.Close()
+
+	_, err := New(s.URL).CreateGame(NewGame{Name: "Test", Players: []string{"Abel", "Baker"}, Bank: 60})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expected := map[string]string{
+		"name": "Test", "playerCount": "2", "player0": "Abel", "player1": "Baker",
+		"perDecision": "0", "bank": "60", "async": "false"}
+	for k, v := range expected {
+		if r.PostForm.Get(k) != v {
+			t.Errorf("Expected %s=%s, got %s", k, v, r.PostForm.Get(k))
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var r *http.Request
+	s := fakeServer(http.StatusNotFound, `{"error":{"code":"game_not_found","message":"Unknown game ID: g1"}}`, &r)
+	defer s.Close()
+
+	_, err := New(s.URL).Game("g1")
+	if !IsCode(err, "game_not_found") || err.(*Error).Status != http.StatusNotFound {
+		t.Errorf("Expected game_not_found, got %v", err)
+	}
+	if r.Method != "GET" || r.URL.Query().Get("g") != "g1" {
+		t.Errorf("Expected GET with g=g1, got %s %s", r.Method, r.URL)
+	}
+
+	plain := fakeServer(http.StatusBadGateway, "Bad gateway", &r)
+	defer plain.Close()
+	_, err = New(plain.URL).Game("g1")
+	if e, ok := err.(*Error); !ok || e.Status != http.StatusBadGateway {
+		t.Errorf("Expected a 502 error, got %v", err)
+	}
+}
+
+func TestLoginSetsToken(t *testing.T) {
+	var r *http.Request
+	s := fakeServer(http.StatusOK, `{"token":"t1"}`, &r)
+	defer s.Close()
+
+	c := New(s.URL)
+	if err := c.Login("abel", "secret"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if c.Token != "t1" || r.PostForm.Get("u") != "abel" || r.PostForm.Get("p") != "secret" {
+		t.Errorf("Expected token t1, got %q for %v", c.Token, r.PostForm)
+	}
+	c.SendLobbyChat("Hello")
+	if r.URL.Path != "/api/v1/lobby/chat" || r.PostForm.Get("u") != "t1" {
+		t.Errorf("Lobby chat should send the token, got %s %v", r.URL.Path, r.PostForm)
+	}
+}